@@ -0,0 +1,18 @@
+//go:build !windows
+// +build !windows
+
+package archive
+
+import (
+	"errors"
+	"syscall"
+)
+
+// processAlive проверяет, что процесс с таким PID есть. Сигнал 0 ничего не посылает, только проверяет.
+func processAlive(pid int) bool {
+	if pid <= 0 {
+		return false
+	}
+	err := syscall.Kill(pid, 0)
+	return err == nil || errors.Is(err, syscall.EPERM)
+}
@@ -0,0 +1,19 @@
+//go:build windows
+// +build windows
+
+package archive
+
+import "os"
+
+// processAlive проверяет, что процесс с таким PID есть. На windows FindProcess открывает процесс и падает, если его нет.
+func processAlive(pid int) bool {
+	if pid <= 0 {
+		return false
+	}
+	proc, err := os.FindProcess(pid)
+	if err != nil {
+		return false
+	}
+	_ = proc.Release()
+	return true
+}
@@ -0,0 +1,424 @@
+// Package archive хранит копии папок с логами, потому что игра удаляет их через 5 дней.
+//
+// Файлы лежат сжатыми и адресуются по sha256 содержимого, так что одинаковые файлы хранятся один раз:
+//
+//	store/
+//	  manifest.json
+//	  objects/ab/abcdef...gz
+//
+// В manifest.json записано какие сессии есть в архиве и из каких файлов они состоят.
+package archive
+
+import (
+	"compress/gzip"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/Feresey/haward/internal/atomicfile"
+)
+
+const (
+	manifestFile = "manifest.json"
+	objectsDir   = "objects"
+	lockFile     = ".lock"
+	// staleLock через сколько замок считается брошенным, даже если процесс с таким PID жив:
+	// архивация идёт минуты, а PID за сутки мог достаться другому процессу
+	staleLock = 24 * time.Hour
+)
+
+// ErrLocked значит что архив уже занят другим процессом.
+var ErrLocked = errors.New("archive is locked by another process")
+
+// Store это архив сессий. Пока он открыт, другой процесс открыть его не может.
+type Store struct {
+	root string
+	// содержимое замка этого процесса, чтобы не удалить чужой
+	lock     string
+	manifest Manifest
+	now      func() time.Time
+}
+
+// Manifest это список сессий в архиве.
+type Manifest struct {
+	Sessions []Session
+}
+
+// Session это одна папка с логами.
+type Session struct {
+	// Name это имя папки, например "2021.08.10 20.02.16.123"
+	Name       string
+	ArchivedAt time.Time
+	Files      []File
+}
+
+// File это один файл из сессии.
+type File struct {
+	Name    string
+	Size    int64
+	ModTime time.Time
+	// SHA256 несжатого содержимого, по нему же ищется объект в архиве
+	SHA256 string
+}
+
+// File ищет файл сессии по имени.
+func (s *Session) File(name string) (File, bool) {
+	for _, f := range s.Files {
+		if f.Name == name {
+			return f, true
+		}
+	}
+	return File{}, false
+}
+
+// Open открывает архив, а если его нет, то создаёт.
+// Архив надо закрыть, иначе следующий запуск решит что он занят.
+// Замок, который оставил упавший процесс, снимается сам.
+func Open(root string) (*Store, error) {
+	if err := os.MkdirAll(filepath.Join(root, objectsDir), 0o755); err != nil {
+		return nil, fmt.Errorf("create archive: %w", err)
+	}
+
+	path := filepath.Join(root, lockFile)
+	lock, err := createLock(path)
+	if errors.Is(err, os.ErrExist) {
+		lock, err = takeStaleLock(path)
+	}
+	if err != nil {
+		if errors.Is(err, os.ErrExist) {
+			return nil, fmt.Errorf("%w: remove %q if it is not running", ErrLocked, path)
+		}
+		return nil, fmt.Errorf("lock archive: %w", err)
+	}
+
+	s := &Store{
+		root: root,
+		lock: lock,
+		now:  time.Now,
+	}
+	if err := s.loadManifest(); err != nil {
+		s.Close()
+		return nil, err
+	}
+	return s, nil
+}
+
+// Close отпускает архив.
+// Если замок успели забрать как брошенный, то он уже чужой и остаётся на месте.
+func (s *Store) Close() error {
+	path := filepath.Join(s.root, lockFile)
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return err
+	}
+	if string(data) != s.lock {
+		return fmt.Errorf("%w: lock was taken over by another process", ErrLocked)
+	}
+	return os.Remove(path)
+}
+
+// createLock создаёт замок с PID текущего процесса и временем, чтобы отличать замки одного процесса.
+// Если замок уже есть, то ошибка os.ErrExist.
+func createLock(path string) (string, error) {
+	lock, err := os.OpenFile(path, os.O_CREATE|os.O_EXCL|os.O_WRONLY, 0o644)
+	if err != nil {
+		return "", err
+	}
+	data := strconv.Itoa(os.Getpid()) + " " + strconv.FormatInt(time.Now().UnixNano(), 10)
+	_, err = io.WriteString(lock, data)
+	if closeErr := lock.Close(); err == nil {
+		err = closeErr
+	}
+	return data, err
+}
+
+// takeStaleLock забирает замок, если он брошен.
+// Замок сначала переименовывается, и только потом ещё раз проверяется, что он брошен:
+// иначе два процесса могли бы снять один и тот же замок, и второй удалил бы свежий замок первого.
+func takeStaleLock(path string) (string, error) {
+	if !staleLockFile(path) {
+		return "", os.ErrExist
+	}
+
+	taken := path + ".stale." + strconv.Itoa(os.Getpid()) + "." + strconv.FormatInt(time.Now().UnixNano(), 36)
+	if err := os.Rename(path, taken); err != nil {
+		if errors.Is(err, os.ErrNotExist) {
+			// замок уже снял кто-то другой, теперь кто первый создаст
+			return createLock(path)
+		}
+		return "", fmt.Errorf("take stale lock: %w", err)
+	}
+
+	if !staleLockFile(taken) {
+		// пока проверяли, замок снял и создал заново другой процесс - возвращаем ему его замок.
+		// Link не перезапишет замок, если его уже кто-то создал
+		if err := os.Link(taken, path); err == nil {
+			os.Remove(taken)
+		}
+		return "", os.ErrExist
+	}
+
+	if err := os.Remove(taken); err != nil {
+		return "", fmt.Errorf("remove stale lock: %w", err)
+	}
+	return createLock(path)
+}
+
+// staleLockFile проверяет, что замок остался от процесса, который уже не работает, или что он слишком старый.
+func staleLockFile(path string) bool {
+	info, err := os.Stat(path)
+	if err != nil {
+		return false
+	}
+	if time.Since(info.ModTime()) > staleLock {
+		return true
+	}
+
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return false
+	}
+	// замок это "PID время"
+	pid, err := strconv.Atoi(strings.SplitN(string(data), " ", 2)[0])
+	if err != nil {
+		// замок пишется не атомарно, пустой файл может значить что его как раз сейчас создают
+		return false
+	}
+	return !processAlive(pid)
+}
+
+func (s *Store) loadManifest() error {
+	data, err := os.ReadFile(filepath.Join(s.root, manifestFile))
+	if err != nil {
+		if errors.Is(err, os.ErrNotExist) {
+			return nil
+		}
+		return fmt.Errorf("read manifest: %w", err)
+	}
+	if err := json.Unmarshal(data, &s.manifest); err != nil {
+		return fmt.Errorf("decode manifest: %w", err)
+	}
+	return nil
+}
+
+func (s *Store) saveManifest() error {
+	sort.Slice(s.manifest.Sessions, func(i, j int) bool {
+		return s.manifest.Sessions[i].Name < s.manifest.Sessions[j].Name
+	})
+	data, err := json.MarshalIndent(s.manifest, "", "\t")
+	if err != nil {
+		return err
+	}
+	return atomicfile.WriteFile(filepath.Join(s.root, manifestFile), data)
+}
+
+// Sessions возвращает сессии из архива по порядку.
+func (s *Store) Sessions() []Session {
+	res := make([]Session, len(s.manifest.Sessions))
+	copy(res, s.manifest.Sessions)
+	return res
+}
+
+// Session ищет сессию по имени папки.
+func (s *Store) Session(name string) (Session, bool) {
+	for _, session := range s.manifest.Sessions {
+		if session.Name == name {
+			return session, true
+		}
+	}
+	return Session{}, false
+}
+
+// AddSession кладёт в архив все файлы из папки dir под именем name.
+// Файлы, которые не изменились с прошлого раза, не перечитываются.
+// Если файла уже нет в папке, то в архиве остаётся его прошлая версия.
+// Возвращает true, если в архиве что-то поменялось.
+func (s *Store) AddSession(name, dir string) (bool, error) {
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		return false, fmt.Errorf("read session: %w", err)
+	}
+
+	idx := -1
+	for i := range s.manifest.Sessions {
+		if s.manifest.Sessions[i].Name == name {
+			idx = i
+		}
+	}
+	var old Session
+	if idx != -1 {
+		old = s.manifest.Sessions[idx]
+	}
+
+	session := Session{Name: name, ArchivedAt: old.ArchivedAt}
+	changed := idx == -1
+	for _, entry := range entries {
+		if !entry.Type().IsRegular() {
+			continue
+		}
+		info, err := entry.Info()
+		if err != nil {
+			return false, fmt.Errorf("stat %q: %w", entry.Name(), err)
+		}
+
+		prev, ok := old.File(entry.Name())
+		if ok && prev.Size == info.Size() && prev.ModTime.Equal(info.ModTime()) {
+			session.Files = append(session.Files, prev)
+			continue
+		}
+
+		file, err := s.addFile(filepath.Join(dir, entry.Name()))
+		if err != nil {
+			return false, err
+		}
+		file.ModTime = info.ModTime()
+		if !ok || prev.SHA256 != file.SHA256 {
+			changed = true
+		}
+		session.Files = append(session.Files, file)
+	}
+
+	// игра могла удалить часть файлов, архив их помнит
+	for _, prev := range old.Files {
+		if _, ok := session.File(prev.Name); !ok {
+			session.Files = append(session.Files, prev)
+		}
+	}
+	sort.Slice(session.Files, func(i, j int) bool {
+		return session.Files[i].Name < session.Files[j].Name
+	})
+
+	if !changed {
+		if idx != -1 {
+			// время изменения могло поменяться, содержимое нет
+			s.manifest.Sessions[idx] = session
+		}
+		return false, s.saveManifest()
+	}
+
+	session.ArchivedAt = s.now()
+	if idx == -1 {
+		s.manifest.Sessions = append(s.manifest.Sessions, session)
+	} else {
+		s.manifest.Sessions[idx] = session
+	}
+	return true, s.saveManifest()
+}
+
+// addFile сжимает файл в объект архива. Если такой объект уже есть, то второй раз он не пишется.
+func (s *Store) addFile(path string) (File, error) {
+	src, err := os.Open(path)
+	if err != nil {
+		return File{}, fmt.Errorf("open log: %w", err)
+	}
+	defer src.Close()
+
+	tmp, err := os.CreateTemp(filepath.Join(s.root, objectsDir), "tmp-*")
+	if err != nil {
+		return File{}, fmt.Errorf("create temp object: %w", err)
+	}
+	defer os.Remove(tmp.Name())
+
+	hash := sha256.New()
+	zw := gzip.NewWriter(tmp)
+	size, err := io.Copy(io.MultiWriter(zw, hash), src)
+	if err == nil {
+		err = zw.Close()
+	}
+	if cerr := tmp.Close(); err == nil {
+		err = cerr
+	}
+	if err != nil {
+		return File{}, fmt.Errorf("compress %q: %w", path, err)
+	}
+
+	file := File{
+		Name:   filepath.Base(path),
+		Size:   size,
+		SHA256: hex.EncodeToString(hash.Sum(nil)),
+	}
+
+	object := s.objectPath(file.SHA256)
+	if _, err := os.Stat(object); err == nil {
+		return file, nil
+	}
+	if err := os.MkdirAll(filepath.Dir(object), 0o755); err != nil {
+		return File{}, fmt.Errorf("create object dir: %w", err)
+	}
+	if err := os.Rename(tmp.Name(), object); err != nil {
+		return File{}, fmt.Errorf("store object: %w", err)
+	}
+	return file, nil
+}
+
+func (s *Store) objectPath(sum string) string {
+	return filepath.Join(s.root, objectsDir, sum[:2], sum+".gz")
+}
+
+// Open открывает файл сессии из архива на чтение.
+func (s *Store) Open(file File) (io.ReadCloser, error) {
+	f, err := os.Open(s.objectPath(file.SHA256))
+	if err != nil {
+		return nil, fmt.Errorf("open object: %s: %w", file.Name, err)
+	}
+	zr, err := gzip.NewReader(f)
+	if err != nil {
+		f.Close()
+		return nil, fmt.Errorf("open object: %s: %w", file.Name, err)
+	}
+	return &objectReader{Reader: zr, file: f}, nil
+}
+
+type objectReader struct {
+	*gzip.Reader
+	file *os.File
+}
+
+func (r *objectReader) Close() error {
+	err := r.Reader.Close()
+	if cerr := r.file.Close(); err == nil {
+		err = cerr
+	}
+	return err
+}
+
+// Verify перечитывает все файлы из архива и сверяет их с контрольными суммами.
+// Возвращает ошибку на каждый битый или пропавший файл.
+func (s *Store) Verify() []error {
+	var errs []error
+	for _, session := range s.manifest.Sessions {
+		for _, file := range session.Files {
+			if err := s.verifyFile(file); err != nil {
+				errs = append(errs, fmt.Errorf("%s/%s: %w", session.Name, file.Name, err))
+			}
+		}
+	}
+	return errs
+}
+
+func (s *Store) verifyFile(file File) error {
+	rd, err := s.Open(file)
+	if err != nil {
+		return err
+	}
+	defer rd.Close()
+
+	hash := sha256.New()
+	size, err := io.Copy(hash, rd)
+	if err != nil {
+		return fmt.Errorf("read object: %w", err)
+	}
+	if sum := hex.EncodeToString(hash.Sum(nil)); sum != file.SHA256 || size != file.Size {
+		return fmt.Errorf("checksum mismatch: want %s (%d bytes), got %s (%d bytes)", file.SHA256, file.Size, sum, size)
+	}
+	return nil
+}
@@ -0,0 +1,154 @@
+package archive
+
+import (
+	"errors"
+	"io"
+	"os"
+	"path/filepath"
+	"strconv"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+)
+
+func writeLog(t *testing.T, dir, name, data string, mtime time.Time) {
+	t.Helper()
+	path := filepath.Join(dir, name)
+	require.NoError(t, os.WriteFile(path, []byte(data), 0o644))
+	require.NoError(t, os.Chtimes(path, mtime, mtime))
+}
+
+func readFile(t *testing.T, s *Store, file File) string {
+	t.Helper()
+	rd, err := s.Open(file)
+	require.NoError(t, err)
+	defer rd.Close()
+	data, err := io.ReadAll(rd)
+	require.NoError(t, err)
+	return string(data)
+}
+
+func countObjects(t *testing.T, root string) int {
+	t.Helper()
+	var n int
+	err := filepath.Walk(filepath.Join(root, objectsDir), func(_ string, info os.FileInfo, err error) error {
+		if err == nil && info.Mode().IsRegular() {
+			n++
+		}
+		return err
+	})
+	require.NoError(t, err)
+	return n
+}
+
+func TestStore(t *testing.T) {
+	r := require.New(t)
+
+	logs := t.TempDir()
+	root := filepath.Join(t.TempDir(), "archive")
+	mtime := time.Date(2021, 8, 10, 20, 2, 16, 0, time.UTC)
+
+	first := filepath.Join(logs, "2021.08.10 20.02.16.123")
+	second := filepath.Join(logs, "2021.08.11 19.00.00.5")
+	r.NoError(os.Mkdir(first, 0o755))
+	r.NoError(os.Mkdir(second, 0o755))
+	writeLog(t, first, "combat.log", "kill\n", mtime)
+	writeLog(t, first, "game.log", "level\n", mtime)
+	// одинаковое содержимое хранится один раз
+	writeLog(t, second, "combat.log", "kill\n", mtime)
+
+	s, err := Open(root)
+	r.NoError(err)
+
+	_, err = Open(root)
+	r.True(errors.Is(err, ErrLocked), err)
+
+	changed, err := s.AddSession("2021.08.10 20.02.16.123", first)
+	r.NoError(err)
+	r.True(changed)
+	changed, err = s.AddSession("2021.08.11 19.00.00.5", second)
+	r.NoError(err)
+	r.True(changed)
+	r.Equal(2, countObjects(t, root))
+
+	// второй запуск ничего не меняет
+	changed, err = s.AddSession("2021.08.10 20.02.16.123", first)
+	r.NoError(err)
+	r.False(changed)
+
+	// лог дописался, пока шёл бой
+	writeLog(t, first, "combat.log", "kill\nkill again\n", mtime.Add(time.Minute))
+	changed, err = s.AddSession("2021.08.10 20.02.16.123", first)
+	r.NoError(err)
+	r.True(changed)
+	r.NoError(s.Close())
+
+	// игра удалила логи, а архив их помнит
+	r.NoError(os.RemoveAll(first))
+
+	s, err = Open(root)
+	r.NoError(err)
+	defer s.Close()
+
+	sessions := s.Sessions()
+	r.Len(sessions, 2)
+	r.Equal("2021.08.10 20.02.16.123", sessions[0].Name)
+
+	combat, ok := sessions[0].File("combat.log")
+	r.True(ok)
+	r.Equal(int64(len("kill\nkill again\n")), combat.Size)
+	r.Equal("kill\nkill again\n", readFile(t, s, combat))
+
+	game, ok := sessions[0].File("game.log")
+	r.True(ok)
+	r.Equal("level\n", readFile(t, s, game))
+
+	r.Empty(s.Verify())
+
+	// битый объект находится проверкой
+	r.NoError(os.WriteFile(s.objectPath(game.SHA256), []byte("garbage"), 0o644))
+	errs := s.Verify()
+	r.Len(errs, 1)
+	r.Contains(errs[0].Error(), "2021.08.10 20.02.16.123/game.log")
+}
+
+func TestStaleLock(t *testing.T) {
+	r := require.New(t)
+
+	root := t.TempDir()
+	path := filepath.Join(root, lockFile)
+
+	// процесс упал и замок остался, а такого PID уже нет
+	r.NoError(os.WriteFile(path, []byte("1073741824"), 0o644))
+	s, err := Open(root)
+	r.NoError(err)
+	r.NoError(s.Close())
+
+	// процесс жив, значит архив занят
+	r.NoError(os.WriteFile(path, []byte(strconv.Itoa(os.Getpid())), 0o644))
+	_, err = Open(root)
+	r.True(errors.Is(err, ErrLocked), err)
+
+	// но слишком старый замок не держит архив, даже если PID занят
+	old := time.Now().Add(-2 * staleLock)
+	r.NoError(os.Chtimes(path, old, old))
+	s, err = Open(root)
+	r.NoError(err)
+
+	// процесс, у которого забрали замок, не удаляет чужой
+	r.NoError(os.Chtimes(path, old, old))
+	taken, err := Open(root)
+	r.NoError(err)
+	r.True(errors.Is(s.Close(), ErrLocked))
+	r.FileExists(path)
+	r.NoError(taken.Close())
+	r.NoFileExists(path)
+
+	// от снятых замков ничего не остаётся
+	entries, err := os.ReadDir(root)
+	r.NoError(err)
+	for _, entry := range entries {
+		r.NotContains(entry.Name(), lockFile)
+	}
+}
@@ -0,0 +1,332 @@
+package main
+
+import (
+	"context"
+	"encoding/csv"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"text/tabwriter"
+	"time"
+
+	"github.com/Feresey/haward/evidence"
+	"github.com/Feresey/haward/leaderboard"
+	"github.com/Feresey/haward/ledger"
+	"github.com/Feresey/haward/parse"
+	"github.com/Feresey/haward/rules"
+	"github.com/Feresey/haward/signing"
+)
+
+// aggregateCommand сводит отчёты всех охотников в одну таблицу. Это для организаторов.
+func aggregateCommand(args []string) error {
+	fs := flag.NewFlagSet("aggregate", flag.ExitOnError)
+	rulesFile := fs.String("rules", "rules.txt", "Path to the current rules file, the kills are scored again with it. Empty to keep the scores from the reports")
+	clansFile := fs.String("clans", "clans.json", "Path to the clan tag and name directory")
+	teamsFile := fs.String("teams", "", "Path to the CSV of hunters and their teams (hunter,team)")
+	perTarget := fs.Int("per-target", 0, "How many kills of the same target are paid to a hunter a day, 0 for no limit")
+	perDay := fs.Int("per-day", 0, "How many points a hunter can get a day, 0 for no limit")
+	killsFile := fs.String("kills", "", "Path to the CSV with every submitted kill and what is counted for it")
+	hunter := fs.String("hunter", "", "Print the kills of this hunter instead of the leaderboard")
+	fs.Usage = func() {
+		fmt.Fprintf(fs.Output(), "Usage: haward aggregate [flags] submissions/\n")
+		fs.PrintDefaults()
+	}
+	_ = fs.Parse(args)
+	if fs.NArg() != 1 {
+		fs.Usage()
+		return fmt.Errorf("want the directory with the reports")
+	}
+
+	sub, err := loadSubmissions(fs.Arg(0))
+	if err != nil {
+		return err
+	}
+	kills := sub.Kills
+
+	opts := leaderboard.Options{
+		Caps: leaderboard.Caps{
+			PerTarget: *perTarget,
+			PerDay:    *perDay,
+		},
+		Witnesses: sub.Witnesses,
+	}
+	if *teamsFile != "" {
+		opts.Teams, err = loadTeams(*teamsFile)
+		if err != nil {
+			return err
+		}
+	}
+	if *rulesFile != "" {
+		clans, err := rules.LoadClanDirectory(*clansFile)
+		if err != nil {
+			return fmt.Errorf("load clans: %w", err)
+		}
+		opts.Score, err = currentScore(*rulesFile, clans, kills)
+		if err != nil {
+			return err
+		}
+	}
+
+	board := leaderboard.Build(kills, opts)
+
+	if *killsFile != "" {
+		if err := writeEntries(*killsFile, board.Entries); err != nil {
+			return err
+		}
+	}
+	if *hunter != "" {
+		entries := board.HunterEntries(*hunter)
+		if len(entries) == 0 {
+			return fmt.Errorf("no kills of %s", *hunter)
+		}
+		return printEntries(os.Stdout, entries)
+	}
+	return printBoard(os.Stdout, board, opts.Teams)
+}
+
+// submissions это всё, что прислали охотники
+type submissions struct {
+	Kills []ledger.Kill
+	// Witnesses это все сбития боёв из доказательств
+	Witnesses []leaderboard.Witness
+}
+
+// loadSubmissions читает все присланные отчёты (*.csv), журналы (*.jsonl) и доказательства (*.json) из папки, на любой глубине.
+// В журнале охотник записан в каждом сбитии. У отчёта охотник берётся из подписи рядом с ним,
+// а без подписи из имени файла: ZiroTwo.csv. В отчёте нет номера боя, тега с названием клана по отдельности
+// и профиля цели, они берутся из доказательства сбития.
+func loadSubmissions(dir string) (*submissions, error) {
+	var (
+		kills     []ledger.Kill
+		witnesses []leaderboard.Witness
+		// map[session/line]доказательство
+		bundles = make(map[string]evidence.Bundle)
+	)
+	err := filepath.WalkDir(dir, func(path string, entry fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+		if entry.IsDir() {
+			return nil
+		}
+
+		switch filepath.Ext(path) {
+		case ".json":
+			if strings.HasSuffix(path, evidence.MatchSuffix) {
+				m, err := evidence.LoadMatch(path)
+				if err != nil {
+					return err
+				}
+				witnesses = append(witnesses, matchWitness(m))
+				return nil
+			}
+			b, err := evidence.Load(path)
+			if err != nil {
+				return err
+			}
+			bundles[killKey(b.Session, b.Line)] = b
+		case ".jsonl":
+			got, err := ledger.Read(path)
+			if err != nil {
+				return err
+			}
+			kills = append(kills, got...)
+		case ".csv":
+			hunter, err := reportHunter(path)
+			if err != nil {
+				return err
+			}
+			rows, err := readReport(path)
+			if err != nil {
+				return fmt.Errorf("%q: %w", path, err)
+			}
+			for _, row := range rows {
+				kills = append(kills, ledger.Kill{
+					Session:  row.Session,
+					Line:     row.Line,
+					Hunter:   hunter,
+					Killed:   row.Killed,
+					Clan:     row.Clan,
+					KilledAt: row.KilledAt,
+					Score:    row.Score,
+				})
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, fmt.Errorf("read submissions: %w", err)
+	}
+
+	for i := range kills {
+		b, ok := bundles[killKey(kills[i].Session, kills[i].Line)]
+		if !ok {
+			continue
+		}
+		if kills[i].MatchID == "" {
+			kills[i].MatchID = b.MatchID
+		}
+		if kills[i].ClanTag == "" && kills[i].ClanName == "" {
+			kills[i].ClanTag, kills[i].ClanName = b.ClanTag, b.ClanName
+		}
+		if kills[i].Profile == nil {
+			kills[i].Profile = b.Profile
+		}
+	}
+	return &submissions{
+		Kills:     kills,
+		Witnesses: witnesses,
+	}, nil
+}
+
+func matchWitness(m evidence.Match) leaderboard.Witness {
+	w := leaderboard.Witness{
+		MatchID: m.ID,
+		Hunter:  m.Hunter,
+	}
+	for _, kill := range m.Kills {
+		w.Kills = append(w.Kills, leaderboard.Sighting{
+			Killed: kill.Killed,
+			Killer: kill.Killer,
+		})
+	}
+	return w
+}
+
+// reportHunter узнаёт чей это отчёт. Подпись тут не проверяется, для этого есть haward verify.
+func reportHunter(path string) (string, error) {
+	manifest, err := signing.LoadManifest(path + manifestSuffix)
+	if err == nil {
+		return manifest.Hunter, nil
+	}
+	if !errors.Is(err, os.ErrNotExist) {
+		return "", err
+	}
+	return strings.TrimSuffix(filepath.Base(path), filepath.Ext(path)), nil
+}
+
+// loadTeams читает CSV hunter,team. Заголовок не обязателен.
+func loadTeams(path string) (map[string]string, error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return nil, fmt.Errorf("open teams: %w", err)
+	}
+	defer file.Close()
+
+	r := csv.NewReader(file)
+	r.FieldsPerRecord = 2
+	r.TrimLeadingSpace = true
+	records, err := r.ReadAll()
+	if err != nil {
+		return nil, fmt.Errorf("read teams: %w", err)
+	}
+
+	teams := make(map[string]string)
+	for idx, record := range records {
+		if idx == 0 && record[0] == "hunter" {
+			continue
+		}
+		teams[record[0]] = record[1]
+	}
+	return teams, nil
+}
+
+// currentScore пересчитывает очки по текущим правилам. Кланы и профили берутся из самих отчётов, в сеть не ходим.
+// Условия штрафов проверить не по чему, так что штраф из правил просто засчитывается.
+func currentScore(rulesFile string, directory *rules.ClanDirectory, kills []ledger.Kill) (leaderboard.Scorer, error) {
+	players := newReportPlayers()
+	for _, kill := range kills {
+		players.add(kill.Killed, reportClan(kill.Clan, kill.ClanTag, kill.ClanName), kill.Profile)
+	}
+	players.addTo(directory)
+
+	r, err := rules.LoadRules(rulesFile,
+		rules.WithClanDirectory(directory),
+		rules.WithResolver(players),
+	)
+	if err != nil {
+		return nil, fmt.Errorf("parse rules: %w", err)
+	}
+
+	return func(kill ledger.Kill) (int, bool) {
+		at, _ := time.Parse(sessionTimeFormat, kill.Session)
+		clan := reportClan(kill.Clan, kill.ClanTag, kill.ClanName)
+		player := parse.Player{Name: kill.Killed, ClanTag: clan.Tag}
+		bounty, ok := r.GetBounty(context.Background(), player, at)
+		return bounty.Score, ok
+	}, nil
+}
+
+func printBoard(out io.Writer, board *leaderboard.Board, teams map[string]string) error {
+	w := tabwriter.NewWriter(out, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(w, "#\tHUNTER\tTEAM\tKILLS\tSCORE\tCONFIRMED\tDISPUTED")
+	for idx, s := range board.Hunters {
+		team, ok := teams[s.Name]
+		if !ok {
+			team = "-"
+		}
+		fmt.Fprintf(w, "%d\t%s\t%s\t%d\t%d\t%d\t%d\n", idx+1, s.Name, team, s.Kills, s.Score, s.Confirmed, s.Disputed)
+	}
+	if len(board.Teams) != 0 {
+		fmt.Fprintln(w)
+		fmt.Fprintln(w, "#\tTEAM\tHUNTERS\tKILLS\tSCORE")
+		for idx, s := range board.Teams {
+			fmt.Fprintf(w, "%d\t%s\t%d\t%d\t%d\n", idx+1, s.Name, s.Hunters, s.Kills, s.Score)
+		}
+	}
+	return w.Flush()
+}
+
+func printEntries(out io.Writer, entries []leaderboard.Entry) error {
+	w := tabwriter.NewWriter(out, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(w, "SESSION\tKILLED_AT\tLINE\tMATCH\tKILLED\tCLAN\tSCORE\tCOUNTED\tNOTE\tCONFIRMED BY\tDISPUTED BY")
+	for _, e := range entries {
+		fmt.Fprintf(w, "%s\t%s\t%d\t%s\t%s\t%s\t%d\t%d\t%s\t%s\t%s\n",
+			e.Session, e.KilledAt, e.Line, e.MatchID, e.Killed, e.Clan, e.Score, e.Counted, e.Note,
+			strings.Join(e.ConfirmedBy, " "), strings.Join(e.DisputedBy, " "))
+	}
+	return w.Flush()
+}
+
+// writeEntries пишет все сбития с тем, что за них засчитано, чтобы было что показать на вопрос "а почему так мало".
+func writeEntries(path string, entries []leaderboard.Entry) error {
+	file, err := os.Create(path)
+	if err != nil {
+		return fmt.Errorf("create kills file: %w", err)
+	}
+	defer file.Close()
+
+	w := csv.NewWriter(file)
+	_ = w.Write([]string{
+		"hunter", "team", "session_start", "killed_at", "line_in_log", "match_id",
+		"killed", "clan", "score", "counted", "note", "confirmed_by", "disputed_by",
+	})
+	for _, e := range entries {
+		_ = w.Write([]string{
+			e.Hunter,
+			e.Team,
+			e.Session,
+			e.KilledAt,
+			strconv.Itoa(e.Line),
+			e.MatchID,
+			e.Killed,
+			e.Clan,
+			strconv.Itoa(e.Score),
+			strconv.Itoa(e.Counted),
+			e.Note,
+			strings.Join(e.ConfirmedBy, " "),
+			strings.Join(e.DisputedBy, " "),
+		})
+	}
+	w.Flush()
+	if err := w.Error(); err != nil {
+		return fmt.Errorf("write kills file: %w", err)
+	}
+	return file.Close()
+}
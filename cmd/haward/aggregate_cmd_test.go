@@ -0,0 +1,147 @@
+package main
+
+import (
+	"bytes"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/Feresey/haward/evidence"
+	"github.com/Feresey/haward/leaderboard"
+	"github.com/Feresey/haward/ledger"
+	"github.com/Feresey/haward/rules"
+	"github.com/Feresey/haward/signing"
+	"github.com/stretchr/testify/require"
+)
+
+func TestAggregate(t *testing.T) {
+	r := require.New(t)
+	dir := t.TempDir()
+
+	const header = "session_start,killed_at,line_in_log,killed,clan,score\n"
+	r.NoError(os.WriteFile(filepath.Join(dir, "ZiroTwo.csv"), []byte(header+
+		"2021.10.12 12.36.09.316,12:46:54.431,4744,MeXico,xIDx,5\n"+
+		"2021.10.12 12.36.09.316,12:48:02.256,6813,Cat,NEKO,5\n"), 0o644))
+
+	// подписанный отчёт в своей папке, охотник из подписи
+	r.NoError(os.MkdirAll(filepath.Join(dir, "lopa"), 0o755))
+	r.NoError(os.WriteFile(filepath.Join(dir, "lopa", "out.csv"), []byte(header+
+		"2021.10.12 13.00.00.000,13:10:00.000,10,MeXico,xIDx,5\n"), 0o644))
+	keyPath := filepath.Join(t.TempDir(), "haward.key")
+	_, err := signing.GenerateKey(keyPath)
+	r.NoError(err)
+	key, err := signing.LoadPrivateKey(keyPath)
+	r.NoError(err)
+	m, err := signing.Sign(key, "lOpa", filepath.Join(dir, "lopa"), []string{"out.csv"})
+	r.NoError(err)
+	r.NoError(m.Save(filepath.Join(dir, "lopa", "out.csv"+manifestSuffix)))
+
+	// журнал с тем же сбитием, что и в отчёте
+	l, err := ledger.Open(filepath.Join(dir, "ZiroTwo.jsonl"))
+	r.NoError(err)
+	_, err = l.Add(ledger.Kill{
+		Session: "2021.10.12 12.36.09.316", Match: "12:40:00.000", Line: 4744,
+		Hunter: "ZiroTwo", Killed: "MeXico", Clan: "xIDx", KilledAt: "12:46:54.431", Score: 5,
+	})
+	r.NoError(err)
+
+	// доказательство сбития с номером боя, и lOpa был в том же бою и видел его
+	zirotwo, err := evidence.Open(filepath.Join(dir, "evidence"))
+	r.NoError(err)
+	_, err = zirotwo.Add(evidence.Bundle{
+		Session: "2021.10.12 12.36.09.316", Match: "12:40:00.000", Line: 4744, MatchID: "777",
+		Clan: "xIDx", ClanTag: "xIDx", ClanName: "The Dark Invaders",
+	})
+	r.NoError(err)
+	lopa, err := evidence.Open(filepath.Join(dir, "lopa", "evidence"))
+	r.NoError(err)
+	_, err = lopa.AddMatch(evidence.Match{
+		Session: "2021.10.12 12.37.00.000", Match: "12:40:01.000", ID: "777", Hunter: "lOpa",
+		Kills: []evidence.Sighting{{Killed: "MeXico", Killer: "ZiroTwo"}},
+	})
+	r.NoError(err)
+
+	sub, err := loadSubmissions(dir)
+	r.NoError(err)
+	kills := sub.Kills
+	r.Len(kills, 4)
+	r.Len(sub.Witnesses, 1)
+	for _, kill := range kills {
+		if kill.Line == 4744 {
+			r.Equal("777", kill.MatchID)
+			r.Equal("The Dark Invaders", kill.ClanName)
+		}
+	}
+
+	teamsFile := filepath.Join(dir, "teams.txt")
+	r.NoError(os.WriteFile(teamsFile, []byte("hunter,team\nZiroTwo, red\nlOpa,red\n"), 0o644))
+	teams, err := loadTeams(teamsFile)
+	r.NoError(err)
+	r.Equal(map[string]string{"ZiroTwo": "red", "lOpa": "red"}, teams)
+
+	// за NEKO больше не дают, а за xIDx теперь 7
+	rulesFile := filepath.Join(dir, "rules.txt")
+	r.NoError(os.WriteFile(rulesFile, []byte("=== CORPORATIONS ===\n+7\nThe Dark Invaders [xIDx]\n"), 0o644))
+	score, err := currentScore(rulesFile, rules.NewClanDirectory(), kills)
+	r.NoError(err)
+
+	board := leaderboard.Build(kills, leaderboard.Options{Teams: teams, Score: score, Witnesses: sub.Witnesses})
+	r.Equal([]leaderboard.Standing{
+		{Name: "ZiroTwo", Hunters: 1, Kills: 1, Score: 7, Confirmed: 1},
+		{Name: "lOpa", Hunters: 1, Kills: 1, Score: 7},
+	}, board.Hunters)
+	r.Equal([]leaderboard.Standing{{Name: "red", Hunters: 2, Kills: 2, Score: 14, Confirmed: 1}}, board.Teams)
+
+	var buf bytes.Buffer
+	r.NoError(printBoard(&buf, board, teams))
+	r.Contains(buf.String(), "1  ZiroTwo  red   1      7")
+
+	killsFile := filepath.Join(t.TempDir(), "kills.csv")
+	r.NoError(writeEntries(killsFile, board.Entries))
+	data, err := os.ReadFile(killsFile)
+	r.NoError(err)
+	r.Contains(string(data), "ZiroTwo,red,2021.10.12 12.36.09.316,12:48:02.256,6813,,Cat,NEKO,5,0,not in the rules,,\n")
+	r.Contains(string(data), "ZiroTwo,red,2021.10.12 12.36.09.316,12:46:54.431,4744,777,MeXico,xIDx,5,7,,lOpa,\n")
+}
+
+func TestCurrentScoreClans(t *testing.T) {
+	r := require.New(t)
+
+	rulesFile := filepath.Join(t.TempDir(), "rules.txt")
+	r.NoError(os.WriteFile(rulesFile, []byte(`=== CORPORATIONS ===
++5
+Neko Corp
+===
++3
+Nekopara
+===
++2
+[FINS]
+
+=== PROFILES ===
++4
+rating >= 5000
+`), 0o644))
+
+	kills := []ledger.Kill{
+		// в логе тег, а в правилах только название
+		{Session: "2021.10.12 12.36.09.316", Line: 1, Killed: "Cat", Clan: "NEKO", ClanTag: "NEKO", ClanName: "Neko Corp"},
+		// в логе тега нет, в отчёте название
+		{Session: "2021.10.12 12.36.09.316", Line: 2, Killed: "Kitten", Clan: "Nekopara", ClanName: "Nekopara"},
+		// старая запись, только тег
+		{Session: "2021.10.12 12.36.09.316", Line: 3, Killed: "Fish", Clan: "FINS"},
+		{Session: "2021.10.12 12.36.09.316", Line: 4, Killed: "Dog", Clan: "DOGS", ClanTag: "DOGS", ClanName: "Dogs"},
+		// награда за профиль, профиль записан в журнале
+		{Session: "2021.10.12 12.36.09.316", Line: 5, Killed: "Pro", Profile: &rules.PlayerProfile{Nickname: "Pro", EffRating: 6000}},
+	}
+	score, err := currentScore(rulesFile, rules.NewClanDirectory(), kills)
+	r.NoError(err)
+
+	for kill, want := range map[int]int{0: 5, 1: 3, 2: 2, 4: 4} {
+		got, ok := score(kills[kill])
+		r.True(ok, kills[kill].Killed)
+		r.Equal(want, got, kills[kill].Killed)
+	}
+	_, ok := score(kills[3])
+	r.False(ok)
+}
@@ -0,0 +1,76 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"net/url"
+	"time"
+
+	"github.com/Feresey/haward/rules"
+	"go.uber.org/zap"
+)
+
+// apiFlags это настройки API игры. Они одни на все подкоманды, которые туда ходят.
+type apiFlags struct {
+	url      string
+	rate     int
+	burst    int
+	timeout  time.Duration
+	retries  int
+	workers  int
+	cacheTTL time.Duration
+	errorTTL time.Duration
+}
+
+func (f *apiFlags) register(fs *flag.FlagSet) {
+	fs.DurationVar(&f.cacheTTL, "cache-ttl", rules.DefaultCacheTTL, "How long a player clan is cached")
+	fs.DurationVar(&f.errorTTL, "error-ttl", rules.DefaultErrorTTL, "How long a failed clan lookup is cached")
+	fs.StringVar(&f.url, "api-url", rules.DefaultAPIURL, "Base URL of the game API, e.g. a mirror or a local stub")
+	fs.IntVar(&f.rate, "api-rate", rules.DefaultRate, "How many requests per second to send to the game API, 0 for no limit")
+	fs.IntVar(&f.burst, "api-burst", rules.DefaultBurst, "How many requests to the game API can be sent at once after a pause")
+	fs.DurationVar(&f.timeout, "api-timeout", rules.DefaultTimeout, "Timeout of a single request to the game API")
+	fs.IntVar(&f.workers, "api-workers", rules.DefaultWorkers, "How many players to look up in the game API at once")
+	fs.IntVar(&f.retries, "api-retries", rules.DefaultRetries, "How many times to retry a failed request to the game API")
+}
+
+// newResolver создаёт резолвер с этими настройками и загружает в него кэш
+func (f apiFlags) newResolver(cacheFile string) (*rules.PlayerClanResolver, error) {
+	if err := checkAPIURL(f.url); err != nil {
+		return nil, fmt.Errorf("bad api url: %w", err)
+	}
+
+	resolver := rules.NewPlayerResolver()
+	resolver.SetBaseURL(f.url)
+	resolver.SetRateLimit(f.rate, f.burst)
+	resolver.SetTTL(f.cacheTTL)
+	resolver.SetErrorTTL(f.errorTTL)
+	resolver.SetTimeout(f.timeout)
+	resolver.SetRetries(f.retries, rules.DefaultBackoff)
+	if err := resolver.LoadCache(cacheFile); err != nil {
+		return nil, fmt.Errorf("load clan cache: %w", err)
+	}
+	return resolver, nil
+}
+
+// checkAPIURL проверяет, что адрес API похож на адрес, а не на опечатку в флаге
+func checkAPIURL(raw string) error {
+	u, err := url.Parse(raw)
+	if err != nil {
+		return err
+	}
+	if (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+		return fmt.Errorf("%q: want http(s)://host/path", raw)
+	}
+	return nil
+}
+
+func logAPIStats(logger *zap.Logger, stats rules.APIStats) {
+	logger.Info("api usage",
+		zap.Int("requests", stats.Requests),
+		zap.Int("failed", stats.Failed),
+		zap.Int("cache_hits", stats.CacheHits),
+		zap.Int("cache_misses", stats.CacheMisses),
+		zap.Float64("hit_ratio", stats.HitRatio()),
+		zap.Duration("avg_latency", stats.AvgLatency()),
+		zap.Duration("max_latency", stats.MaxLatency))
+}
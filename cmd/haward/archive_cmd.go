@@ -0,0 +1,76 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"os"
+	"path/filepath"
+	"time"
+
+	"github.com/Feresey/haward/archive"
+)
+
+// archiveCommand копирует папки с логами в архив, пока игра их не удалила.
+// Его можно запускать сколько угодно раз, например по расписанию: что уже в архиве, второй раз не копируется.
+func archiveCommand(args []string) error {
+	fs := flag.NewFlagSet("archive", flag.ExitOnError)
+	logsDir := fs.String("dir", ".local/share/starconflict/logs", "Path to logs directory")
+	storeDir := fs.String("store", "haward_archive", "Path to the archive")
+	verify := fs.Bool("verify", false, "Check checksums of everything in the archive instead of archiving")
+	quiet := fs.Bool("q", false, "Print only errors, for running from a scheduler")
+	fs.Usage = func() {
+		fmt.Fprintf(fs.Output(), "Usage: haward archive [flags]\n")
+		fs.PrintDefaults()
+	}
+	_ = fs.Parse(args)
+
+	store, err := archive.Open(*storeDir)
+	if err != nil {
+		return err
+	}
+	defer store.Close()
+
+	if *verify {
+		errs := store.Verify()
+		for _, err := range errs {
+			fmt.Fprintf(os.Stderr, "archive: %v\n", err)
+		}
+		if len(errs) != 0 {
+			return fmt.Errorf("%d files are damaged", len(errs))
+		}
+		if !*quiet {
+			fmt.Printf("%d sessions are ok\n", len(store.Sessions()))
+		}
+		return nil
+	}
+
+	entries, err := os.ReadDir(*logsDir)
+	if err != nil {
+		return err
+	}
+
+	var failed int
+	for _, entry := range entries {
+		if !entry.IsDir() {
+			continue
+		}
+		if _, err := time.Parse(sessionTimeFormat, entry.Name()); err != nil {
+			continue
+		}
+
+		changed, err := store.AddSession(entry.Name(), filepath.Join(*logsDir, entry.Name()))
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "archive: %s: %v\n", entry.Name(), err)
+			failed++
+			continue
+		}
+		if changed && !*quiet {
+			fmt.Printf("archived %s\n", entry.Name())
+		}
+	}
+
+	if failed != 0 {
+		return fmt.Errorf("%d sessions are not archived", failed)
+	}
+	return nil
+}
@@ -0,0 +1,77 @@
+package main
+
+import (
+	"errors"
+	"flag"
+	"fmt"
+	"os"
+	"sort"
+	"text/tabwriter"
+	"time"
+
+	"github.com/Feresey/haward/rules"
+)
+
+func cacheCommand(args []string) error {
+	const usage = "usage: haward cache list|clear [-failed] [flags] [nicknames...]"
+
+	if len(args) == 0 {
+		return errors.New(usage)
+	}
+
+	fs := flag.NewFlagSet("cache "+args[0], flag.ExitOnError)
+	cacheFile := fs.String("cache", "clan_cache.json", "Path to the player clan cache")
+	failed := fs.Bool("failed", false, "clear only failed lookups, so they are retried (for clear)")
+	_ = fs.Parse(args[1:])
+
+	resolver := rules.NewPlayerResolver()
+	if err := resolver.LoadCache(*cacheFile); err != nil {
+		return err
+	}
+
+	switch args[0] {
+	case "list":
+		return printCache(resolver.Cached())
+	case "clear":
+		switch {
+		case *failed:
+			cleared := resolver.InvalidateFailed()
+			fmt.Printf("cleared %d failed lookups\n", len(cleared))
+		case fs.NArg() == 0:
+			// без ников чистим всё
+			resolver.InvalidateAll()
+		default:
+			resolver.Invalidate(fs.Args()...)
+		}
+		return resolver.SaveCache(*cacheFile)
+	default:
+		return fmt.Errorf("unknown command: %q, %s", args[0], usage)
+	}
+}
+
+func printCache(cached []rules.CachedClan) error {
+	sort.Slice(cached, func(i, j int) bool {
+		return cached[i].Nickname < cached[j].Nickname
+	})
+
+	now := time.Now()
+
+	w := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(w, "NICKNAME\tTAG\tCLAN\tFETCHED\tSTATUS")
+	for _, entry := range cached {
+		status := "fresh"
+		if !now.Before(entry.ExpiresAt) {
+			status = "expired"
+		}
+		switch {
+		case entry.Err != "":
+			status += ", failed: " + entry.Err
+		case entry.Clan.IsZero():
+			status += ", no clan"
+		}
+		fmt.Fprintf(w, "%s\t%s\t%s\t%s\t%s\n",
+			entry.Nickname, entry.Clan.Tag, entry.Clan.Name,
+			entry.FetchedAt.Format(time.RFC3339), status)
+	}
+	return w.Flush()
+}
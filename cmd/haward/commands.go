@@ -0,0 +1,66 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"os"
+	"sort"
+)
+
+type command struct {
+	usage string
+	run   func(args []string) error
+}
+
+// commands это подкоманды. Без подкоманды haward просто считает очки по логам.
+var commands = map[string]command{
+	"aggregate": {
+		usage: "merge the reports of all hunters into one leaderboard",
+		run:   aggregateCommand,
+	},
+	"archive": {
+		usage: "copy session logs into an archive before the game deletes them",
+		run:   archiveCommand,
+	},
+	"cache": {
+		usage: "show or invalidate the player clan cache",
+		run:   cacheCommand,
+	},
+	"keygen": {
+		usage: "create the keys to sign reports with",
+		run:   keygenCommand,
+	},
+	"roster": {
+		usage: "collect members of the hunted corporations into a roster",
+		run:   rosterCommand,
+	},
+	"rules": {
+		usage: "format rules files or print the merged rules",
+		run:   rulesCommand,
+	},
+	"verify": {
+		usage: "check a signed report: signature, hashes, evidence and scores",
+		run:   verifyCommand,
+	},
+	"watch": {
+		usage: "follow the logs of the running game and score each battle as it ends",
+		run:   watchCommand,
+	},
+}
+
+func usage() {
+	out := flag.CommandLine.Output()
+	fmt.Fprintf(out, "Usage of %s:\n", os.Args[0])
+	flag.PrintDefaults()
+
+	names := make([]string, 0, len(commands))
+	for name := range commands {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	fmt.Fprintf(out, "\nCommands:\n")
+	for _, name := range names {
+		fmt.Fprintf(out, "  %s\n        %s\n", name, commands[name].usage)
+	}
+}
@@ -0,0 +1,66 @@
+package main
+
+import (
+	"bufio"
+	"flag"
+	"fmt"
+	"os"
+	"strings"
+)
+
+// parseFlags разбирает флаги подкоманды. Флаги, которых нет в командной строке,
+// берутся из файла настроек -config, так что адрес API и лимиты не надо повторять в каждом запуске.
+func parseFlags(fs *flag.FlagSet, args []string) error {
+	configFile := fs.String("config", "", "Path to the file with default flag values, one \"name = value\" per line, e.g. haward.conf")
+	if err := fs.Parse(args); err != nil {
+		return err
+	}
+	if *configFile == "" {
+		return nil
+	}
+	return loadConfig(fs, *configFile)
+}
+
+// loadConfig задаёт флаги из файла настроек. Флаги из командной строки важнее файла.
+// Строчки с # - комментарии. Файл один на все подкоманды, так что чужие флаги пропускаются.
+func loadConfig(fs *flag.FlagSet, path string) error {
+	file, err := os.Open(path)
+	if err != nil {
+		return fmt.Errorf("open config: %w", err)
+	}
+	defer file.Close()
+
+	set := make(map[string]bool)
+	fs.Visit(func(f *flag.Flag) {
+		set[f.Name] = true
+	})
+
+	scanner := bufio.NewScanner(file)
+	for lineNum := 1; scanner.Scan(); lineNum++ {
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+
+		idx := strings.Index(line, "=")
+		if idx == -1 {
+			return fmt.Errorf("config %s: line %d: want name = value: %q", path, lineNum, line)
+		}
+		name := strings.TrimSpace(line[:idx])
+		value := strings.TrimSpace(line[idx+1:])
+
+		if name == "config" {
+			return fmt.Errorf("config %s: line %d: config can not include another config", path, lineNum)
+		}
+		if fs.Lookup(name) == nil || set[name] {
+			continue
+		}
+		if err := fs.Set(name, value); err != nil {
+			return fmt.Errorf("config %s: line %d: %w", path, lineNum, err)
+		}
+	}
+	if err := scanner.Err(); err != nil {
+		return fmt.Errorf("read config: %w", err)
+	}
+	return nil
+}
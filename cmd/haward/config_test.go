@@ -0,0 +1,73 @@
+package main
+
+import (
+	"flag"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/Feresey/haward/rules"
+	"github.com/stretchr/testify/require"
+)
+
+func TestParseFlagsConfig(t *testing.T) {
+	dir := t.TempDir()
+	writeConfig := func(t *testing.T, data string) string {
+		path := filepath.Join(dir, t.Name()+".conf")
+		require.NoError(t, os.MkdirAll(filepath.Dir(path), 0o755))
+		require.NoError(t, os.WriteFile(path, []byte(data), 0o644))
+		return path
+	}
+	newFlags := func() (*flag.FlagSet, *apiFlags) {
+		var api apiFlags
+		fs := flag.NewFlagSet("test", flag.ContinueOnError)
+		api.register(fs)
+		return fs, &api
+	}
+
+	t.Run("ok", func(t *testing.T) {
+		r := require.New(t)
+		path := writeConfig(t, `
+# зеркало API
+api-url = https://mirror.example/pubapi/v1
+api-rate = 5
+api-timeout = 3s
+
+# флаг другой подкоманды
+nick = ZiroTwo
+`)
+		fs, api := newFlags()
+		r.NoError(parseFlags(fs, []string{"-config", path, "-api-rate", "7"}))
+
+		r.Equal("https://mirror.example/pubapi/v1", api.url)
+		// командная строка важнее файла
+		r.Equal(7, api.rate)
+		r.Equal(3*time.Second, api.timeout)
+		r.Equal(rules.DefaultBurst, api.burst)
+	})
+
+	t.Run("no config", func(t *testing.T) {
+		r := require.New(t)
+		fs, api := newFlags()
+		r.NoError(parseFlags(fs, []string{"-api-burst", "1"}))
+		r.Equal(rules.DefaultAPIURL, api.url)
+		r.Equal(1, api.burst)
+	})
+
+	t.Run("errors", func(t *testing.T) {
+		for name, data := range map[string]string{
+			"no value":  "api-url\n",
+			"bad value": "api-rate = many\n",
+			"recursion": "config = other.conf\n",
+		} {
+			fs, _ := newFlags()
+			err := parseFlags(fs, []string{"-config", writeConfig(t, "\n"+data)})
+			require.Error(t, err, name)
+			require.Contains(t, err.Error(), "line 2", name)
+		}
+
+		fs, _ := newFlags()
+		require.Error(t, parseFlags(fs, []string{"-config", filepath.Join(dir, "missing.conf")}))
+	})
+}
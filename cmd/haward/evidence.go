@@ -0,0 +1,127 @@
+package main
+
+import (
+	"github.com/Feresey/haward/evidence"
+	"github.com/Feresey/haward/ledger"
+	"github.com/Feresey/haward/session"
+	"go.uber.org/zap"
+)
+
+// evidenceLogs это логи, хэши которых кладутся в доказательства
+var evidenceLogs = []string{"combat.log", "game.log"}
+
+// sessionBundles собирает доказательства на каждое сбитие из отчёта по сессии
+func sessionBundles(sessionName, hunter string, s *SessionReport, files []evidence.File) []evidence.Bundle {
+	var res []evidence.Bundle
+	for _, level := range s.Levels {
+		for _, line := range level.Score {
+			res = append(res, evidence.Bundle{
+				Session:       sessionName,
+				Match:         level.StartedAt.Format(ledger.MatchFormat),
+				MatchID:       level.MatchID,
+				StartedAt:     level.StartedAt,
+				MapName:       level.MapName,
+				GameMode:      level.GameMode,
+				Line:          line.LineNum,
+				Hunter:        hunter,
+				Killed:        line.Killed,
+				Clan:          level.Enemies[line.Killed].Clan,
+				ClanTag:       level.Enemies[line.Killed].KnownClan.Tag,
+				ClanName:      level.Enemies[line.Killed].KnownClan.Name,
+				Profile:       level.Enemies[line.Killed].Profile,
+				KilledAt:      line.Time,
+				Score:         line.Award,
+				ShotFirst:     line.ShotFirst,
+				KilledInGroup: level.Enemies[line.Killed].InGroup,
+				HunterInGroup: level.Hunter.InGroup,
+				CombatLines:   line.Context,
+				FirstLine:     line.ContextLine,
+				HunterLines:   level.PlayerLines[hunter],
+				KilledLines:   level.PlayerLines[line.Killed],
+				Files:         files,
+			})
+		}
+	}
+	return res
+}
+
+// sessionMatches собирает все сбития каждого боя сессии. Бои без номера сверить не с чем, они пропускаются.
+func sessionMatches(sessionName, hunter string, s *SessionReport, files []evidence.File) []evidence.Match {
+	var res []evidence.Match
+	for _, level := range s.Levels {
+		if level.MatchID == "" {
+			continue
+		}
+		m := evidence.Match{
+			Session:   sessionName,
+			Match:     level.StartedAt.Format(ledger.MatchFormat),
+			ID:        level.MatchID,
+			StartedAt: level.StartedAt,
+			MapName:   level.MapName,
+			GameMode:  level.GameMode,
+			Hunter:    hunter,
+			Files:     files,
+		}
+		for _, kill := range level.Kills {
+			m.Kills = append(m.Kills, evidence.Sighting{
+				Line:   kill.LineNum,
+				Time:   kill.Time,
+				Killed: kill.Killed,
+				Killer: kill.Killer,
+			})
+		}
+		res = append(res, m)
+	}
+	return res
+}
+
+// saveEvidence записывает доказательства сбитий сессии и все сбития её боёв, если они включены
+func (p *Parser) saveEvidence(source session.Source, sessionName string, s *SessionReport) error {
+	if p.evidence == nil {
+		return nil
+	}
+
+	bundles := sessionBundles(sessionName, p.f.yourNickname, s, nil)
+	matches := sessionMatches(sessionName, p.f.yourNickname, s, nil)
+	if len(bundles) == 0 && len(matches) == 0 {
+		return nil
+	}
+
+	var files []evidence.File
+	for _, name := range evidenceLogs {
+		file, err := hashLog(source, sessionName, name)
+		if err != nil {
+			return err
+		}
+		files = append(files, file)
+	}
+
+	var added int
+	for _, bundle := range bundles {
+		bundle.Files = files
+		ok, err := p.evidence.Add(bundle)
+		if err != nil {
+			return err
+		}
+		if ok {
+			added++
+		}
+	}
+	for _, m := range matches {
+		m.Files = files
+		if _, err := p.evidence.AddMatch(m); err != nil {
+			return err
+		}
+	}
+	p.logger.Info("save evidence", zap.Int("new", added), zap.Int("matches", len(matches)))
+	return nil
+}
+
+func hashLog(source session.Source, sessionName, name string) (evidence.File, error) {
+	rd, err := source.Open(sessionName, name)
+	if err != nil {
+		return evidence.File{}, err
+	}
+	defer rd.Close()
+	return evidence.HashFile(name, rd)
+}
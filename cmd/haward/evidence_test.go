@@ -0,0 +1,71 @@
+package main
+
+import (
+	"context"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/Feresey/haward/evidence"
+	"github.com/Feresey/haward/rules"
+	"github.com/Feresey/haward/session"
+	"github.com/stretchr/testify/require"
+	"go.uber.org/zap"
+)
+
+func TestSessionBundles(t *testing.T) {
+	r := require.New(t)
+
+	const gameLog = `12:46:15.000         | MasterServerSession: connect to dedicated server, session 45996460, at addr 23.111.211.203|35010
+12:46:15.531         | ====== starting level: 'levels/area1/s1338_pandora_anomaly' KingOfTheHill client =====
+12:46:16.000         | client: ADD_PLAYER 0 (ZiroTwo [xIDx], 2516405) status 2 team 1 group 4778580
+12:46:16.000         | client: ADD_PLAYER 0 (ZiroTwo [xIDx], 2516405) status 4 team 1 group 4778580
+12:46:16.000         | client: ADD_PLAYER 1 (Cat [NEKO], 111) status 4 team 2
+12:50:00.000         | ====== starting level: 'levels/mainmenu/mainmenu' client =====
+`
+	const combatLog = `12:47:00.000  CMBT   | ======= Start gameplay =======
+12:47:59.000  CMBT   | Damage        ZiroTwo|0000002708 ->        Cat|0000000111  91.25 (h:0.00 s:91.25) Weapon_X KINETIC
+12:48:00.000  CMBT   | Killed Cat	 Ship_T5|0000000111;	 killer ZiroTwo|0000002708 Weapon_X
+12:48:30.000  CMBT   | Killed ZiroTwo	 Ship_T5|0000002708;	 killer Cat|0000000111 Weapon_Y
+`
+	rule, err := rules.NewRules(strings.NewReader("=== PLAYERS ===\n+5\nCat\n"), rules.WithResolver(rules.NewChainResolver()))
+	r.NoError(err)
+
+	startedAt := time.Date(2021, 8, 10, 12, 45, 0, 0, time.UTC)
+	parser := session.NewParser("ZiroTwo", startedAt, strings.NewReader(combatLog), strings.NewReader(gameLog), rule)
+	levels := make(chan *session.LevelReport, 10)
+	r.Error(parser.Parse(context.Background(), zap.NewNop(), levels))
+	close(levels)
+
+	s := &SessionReport{StartedAt: startedAt}
+	for level := range levels {
+		s.Levels = append(s.Levels, level)
+	}
+
+	files := []evidence.File{{Name: "combat.log", Size: 1, SHA256: "ab"}}
+	bundles := sessionBundles("2021.08.10 12.45.00", "ZiroTwo", s, files)
+	r.Len(bundles, 1)
+
+	b := bundles[0]
+	r.NoError(b.Check())
+	r.Equal("12:46:15.531", b.Match)
+	r.Equal(3, b.Line)
+	r.Equal(1, b.FirstLine)
+	r.Len(b.CombatLines, 4)
+	r.Len(b.HunterLines, 2)
+	r.Equal([]string{"12:46:16.000         | client: ADD_PLAYER 1 (Cat [NEKO], 111) status 4 team 2"}, b.KilledLines)
+	r.Equal("NEKO", b.Clan)
+	r.Equal(files, b.Files)
+	r.Equal("45996460", b.MatchID)
+
+	// в ангаре номера боя нет, а в бою видно и чужие сбития
+	matches := sessionMatches("2021.08.10 12.45.00", "ZiroTwo", s, files)
+	r.Len(matches, 1)
+	m := matches[0]
+	r.Equal("45996460", m.ID)
+	r.Equal(b.Match, m.Match)
+	r.Equal([]evidence.Sighting{
+		{Line: 3, Time: "12:48:00.000", Killed: "Cat", Killer: "ZiroTwo"},
+		{Line: 4, Time: "12:48:30.000", Killed: "ZiroTwo", Killer: "Cat"},
+	}, m.Kills)
+}
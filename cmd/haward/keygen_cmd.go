@@ -0,0 +1,30 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+
+	"github.com/Feresey/haward/signing"
+)
+
+// keygenCommand создаёт ключи, которыми охотник подписывает отчёт.
+// Публичный ключ надо заранее отдать организаторам, приватный никому не показывать.
+func keygenCommand(args []string) error {
+	fs := flag.NewFlagSet("keygen", flag.ExitOnError)
+	keyPath := fs.String("key", "haward.key", "Path to the private key, the public key is written next to it with "+signing.PublicKeySuffix)
+	fs.Usage = func() {
+		fmt.Fprintf(fs.Output(), "Usage: haward keygen [flags]\n")
+		fs.PrintDefaults()
+	}
+	_ = fs.Parse(args)
+
+	public, err := signing.GenerateKey(*keyPath)
+	if err != nil {
+		return err
+	}
+
+	fmt.Printf("private key: %s (keep it to yourself)\n", *keyPath)
+	fmt.Printf("public key:  %s (send it to the organizers)\n", *keyPath+signing.PublicKeySuffix)
+	fmt.Printf("fingerprint: %s\n", signing.Fingerprint(public))
+	return nil
+}
@@ -0,0 +1,46 @@
+package main
+
+import (
+	"strconv"
+
+	"github.com/Feresey/haward/ledger"
+)
+
+// sessionKills превращает отчёт по сессии в записи журнала
+func sessionKills(sessionName, hunter string, s *SessionReport) []ledger.Kill {
+	var res []ledger.Kill
+	for _, level := range s.Levels {
+		match := level.StartedAt.Format(ledger.MatchFormat)
+		for _, line := range level.Score {
+			res = append(res, ledger.Kill{
+				Session:  sessionName,
+				Match:    match,
+				Line:     line.LineNum,
+				MatchID:  level.MatchID,
+				Hunter:   hunter,
+				Killed:   line.Killed,
+				Clan:     level.Enemies[line.Killed].Clan,
+				ClanTag:  level.Enemies[line.Killed].KnownClan.Tag,
+				ClanName: level.Enemies[line.Killed].KnownClan.Name,
+				Profile:  level.Enemies[line.Killed].Profile,
+				KilledAt: line.Time,
+				Score:    line.Award,
+				MapName:  level.MapName,
+				GameMode: level.GameMode,
+			})
+		}
+	}
+	return res
+}
+
+// killLine это строчка отчёта, такая же как у SessionIter
+func killLine(kill ledger.Kill) []string {
+	return []string{
+		kill.Session,
+		kill.KilledAt,
+		strconv.Itoa(kill.Line),
+		kill.Killed,
+		kill.Clan,
+		strconv.Itoa(kill.Score),
+	}
+}
@@ -0,0 +1,40 @@
+package main
+
+import (
+	"testing"
+	"time"
+
+	"github.com/Feresey/haward/parse"
+	"github.com/Feresey/haward/session"
+	"github.com/stretchr/testify/require"
+)
+
+func TestSessionKills(t *testing.T) {
+	s := &SessionReport{
+		StartedAt: time.Date(2021, time.August, 10, 20, 2, 16, 123e6, time.UTC),
+		Levels: []*session.LevelReport{
+			{
+				StartedAt: time.Date(2021, time.August, 10, 20, 10, 0, 0, time.UTC),
+				MapName:   "s1340_thar_aliendebris13_kotch",
+				GameMode:  "KingOfTheHill",
+				Enemies: map[string]session.Player{
+					"first": {Clan: "clan", Player: parse.Player{Name: "first"}},
+				},
+				Score: []parse.DeathRecord{
+					{LineNum: 1, Time: "20:12:01.100", Killed: "first", Award: 42},
+				},
+			},
+		},
+	}
+
+	kills := sessionKills("2021.08.10 20.02.16.123", "me", s)
+	require.Len(t, kills, 1)
+	require.Equal(t, "2021.08.10 20.02.16.123/20:10:00.000/1", kills[0].Key())
+	require.Equal(t, "me", kills[0].Hunter)
+	require.Equal(t, "KingOfTheHill", kills[0].GameMode)
+
+	// из журнала получается та же строчка отчёта, что и из логов
+	ri := NewReportIter(s)
+	require.True(t, ri.Next())
+	require.Equal(t, ri.Line(), killLine(kills[0]))
+}
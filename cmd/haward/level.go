@@ -9,7 +9,22 @@ import (
 
 type SessionReport struct {
 	StartedAt time.Time
-	Levels    []*session.LevelReport
+	// Levels это бои с очками и бои с номером, даже если очков в них нет: по их сбитиям сверяются другие охотники
+	Levels []*session.LevelReport
+	// Checkpoint это место в логах после последнего разобранного боя
+	Checkpoint session.Checkpoint
+}
+
+// Scored оставляет только бои, за которые есть очки, они и попадают в отчёт
+func (s *SessionReport) Scored() *SessionReport {
+	res := *s
+	res.Levels = nil
+	for _, level := range s.Levels {
+		if len(level.Score) != 0 {
+			res.Levels = append(res.Levels, level)
+		}
+	}
+	return &res
 }
 
 type SessionIter struct {
@@ -116,3 +116,25 @@ func TestLevelIter(t *testing.T) {
 		require.False(t, it.Next())
 	})
 }
+
+func TestSessionReportScored(t *testing.T) {
+	scored := &session.LevelReport{
+		MatchID: "45996460",
+		Enemies: map[string]session.Player{"first": {Clan: "clan"}},
+		Score:   []parse.DeathRecord{{LineNum: 1, Time: "time line", Killed: "first", Award: 42}},
+	}
+	// в бою ничего не заработали, но сбития в нём видели
+	witnessed := &session.LevelReport{
+		MatchID: "45996461",
+		Kills:   []parse.DeathRecord{{LineNum: 5, Killed: "first", Killer: "second"}},
+	}
+	s := &SessionReport{Levels: []*session.LevelReport{witnessed, scored, witnessed}}
+
+	require.Equal(t, []*session.LevelReport{scored}, s.Scored().Levels)
+	require.Len(t, s.Levels, 3)
+
+	it := NewReportIter(s.Scored())
+	require.True(t, it.Next())
+	require.Equal(t, "42", it.Line()[5])
+	require.False(t, it.Next())
+}
@@ -2,6 +2,7 @@ package main
 
 import (
 	"context"
+	"crypto/ed25519"
 	"encoding/csv"
 	"errors"
 	"flag"
@@ -9,29 +10,57 @@ import (
 	"io"
 	"os"
 	"os/signal"
-	"path/filepath"
 	"sort"
 	"syscall"
 	"time"
 
+	"github.com/Feresey/haward/evidence"
+	"github.com/Feresey/haward/ledger"
 	"github.com/Feresey/haward/rules"
 	"github.com/Feresey/haward/session"
+	"github.com/Feresey/haward/signing"
 	"go.uber.org/zap"
 	"go.uber.org/zap/zapcore"
 )
 
 type flags struct {
-	logsDir      string
-	outputFile   string
+	logsDir    string
+	outputFile string
+	// ledgerFile пустой - отчёт только по логам на диске
+	ledgerFile string
+	// checkpointsFile пустой - разбирать всё с начала
+	checkpointsFile string
+	// evidenceDir пустой - доказательства не нужны
+	evidenceDir string
+	// signKey пустой - отчёт не подписывается
+	signKey      string
 	rulesFile    string
+	clansFile    string
+	historyFile  string
+	cacheFile    string
+	api          apiFlags
+	rosterFile   string
 	yourNickname string
 	logAfter     string
 	debug        bool
+
+	foldCase       bool
+	foldHomoglyphs bool
 }
 
 const logAferFormat = "_2 1 15:04:05"
 
 func main() {
+	if len(os.Args) > 1 {
+		if command, ok := commands[os.Args[1]]; ok {
+			if err := command.run(os.Args[2:]); err != nil {
+				fmt.Fprintf(os.Stderr, "%s: %v\n", os.Args[1], err)
+				os.Exit(1)
+			}
+			return
+		}
+	}
+
 	var f flags
 
 	// TODO корпорацию того же чела не считать
@@ -39,52 +68,162 @@ func main() {
 	// TODO раскидать нормально этот файл
 	// TODO переименованные жопа c кланом
 
-	flag.StringVar(&f.logsDir, "dir", ".local/share/starconflict/logs", "Path to logs directory")
-	flag.StringVar(&f.outputFile, "o", "out.csv", "Path to the output file")
-	flag.StringVar(&f.rulesFile, "rules", "rules.txt", "Path to the rules file")
-	flag.StringVar(&f.yourNickname, "nick", "ZiroTwo", "Your nickname")
-	flag.BoolVar(&f.debug, "debug", false, "show debug messages")
-	flag.StringVar(&f.logAfter, "after", "", "golang time stamp ("+logAferFormat+")")
-	flag.Parse()
+	f.register(flag.CommandLine)
+	flag.Usage = usage
+	if err := parseFlags(flag.CommandLine, os.Args[1:]); err != nil {
+		fmt.Fprintf(os.Stderr, "%v\n", err)
+		os.Exit(2)
+	}
+
+	logger, err := newLogger(f.debug)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "%v", err)
+		os.Exit(1)
+	}
+
+	p, save, err := newParser(f, logger)
+	if err != nil {
+		logger.Fatal("", zap.Error(err))
+	}
+
+	ctx, cancel := signal.NotifyContext(context.Background(), syscall.SIGILL, syscall.SIGTERM)
+	defer cancel()
+
+	logger.Info("start parse")
+	err = p.run(ctx)
+	save()
+
+	if err != nil {
+		logger.Fatal("", zap.Error(err))
+	}
+}
+
+func (f *flags) register(fs *flag.FlagSet) {
+	fs.StringVar(&f.logsDir, "dir", ".local/share/starconflict/logs", "Path to logs directory, or a .zip or .tar.gz archive of it")
+	fs.StringVar(&f.outputFile, "o", "out.csv", "Path to the output file")
+	fs.StringVar(&f.ledgerFile, "ledger", "", "Path to the journal of all scored kills, e.g. kills.jsonl, the output is made from it. Empty to score only the logs on disk")
+	fs.StringVar(&f.checkpointsFile, "checkpoints", "", "Path to the positions in the logs parsed so far, e.g. checkpoints.json, works with -ledger. Empty to parse everything again")
+	fs.StringVar(&f.evidenceDir, "evidence", "", "Path to the directory for the evidence of each scored kill: log lines around it and hashes of the logs, e.g. evidence. Empty to skip it")
+	fs.StringVar(&f.signKey, "sign", "", "Path to the private key from \"haward keygen\" to sign the report and the evidence with")
+	fs.StringVar(&f.rulesFile, "rules", "rules.txt", "Path to the rules file")
+	fs.StringVar(&f.clansFile, "clans", "clans.json", "Path to the clan tag and name directory, it is updated after each run")
+	fs.StringVar(&f.cacheFile, "cache", "clan_cache.json", "Path to the player clan cache")
+	f.api.register(fs)
+	fs.StringVar(&f.historyFile, "history", "clan_history.json", "Path to the history of player clans, it is updated after each run")
+	fs.StringVar(&f.rosterFile, "roster", "", "Path to the CSV list of players and their clans (nickname,clan_tag,clan_name), it is checked before the web API")
+	fs.StringVar(&f.yourNickname, "nick", "ZiroTwo", "Your nickname")
+	fs.BoolVar(&f.debug, "debug", false, "show debug messages")
+	fs.StringVar(&f.logAfter, "after", "", "golang time stamp ("+logAferFormat+")")
+	fs.BoolVar(&f.foldCase, "fold-case", false, "match nicknames case-insensitively")
+	fs.BoolVar(&f.foldHomoglyphs, "fold-homoglyphs", false, "treat look-alike characters (0 and O, cyrillic and latin) as equal")
+}
 
+func newLogger(debug bool) (*zap.Logger, error) {
 	lc := zap.NewDevelopmentConfig()
 	lc.EncoderConfig.EncodeLevel = zapcore.CapitalColorLevelEncoder
-	if !f.debug {
+	if !debug {
 		lc.Level = zap.NewAtomicLevelAt(zapcore.InfoLevel)
 	}
+	return lc.Build()
+}
 
-	logger, err := lc.Build()
+// newParser загружает правила, кланы, журнал и всё остальное, что нужно для подсчёта очков.
+// save сохраняет то, что за время работы узнали про кланы.
+func newParser(f flags, logger *zap.Logger) (p *Parser, save func(), err error) {
+	clans, err := rules.LoadClanDirectory(f.clansFile)
 	if err != nil {
-		fmt.Fprintf(os.Stderr, "%v", err)
-		os.Exit(1)
+		return nil, nil, fmt.Errorf("load clans: %w", err)
+	}
+	history, err := rules.LoadClanHistory(f.historyFile)
+	if err != nil {
+		return nil, nil, fmt.Errorf("load clan history: %w", err)
 	}
 
-	rulesFile, err := os.Open(f.rulesFile)
+	resolver, err := f.api.newResolver(f.cacheFile)
 	if err != nil {
-		logger.Fatal("", zap.Error(err))
+		return nil, nil, err
+	}
+
+	var clanResolver rules.ClanResolver = resolver
+	if f.rosterFile != "" {
+		roster, err := rules.LoadRoster(f.rosterFile)
+		if err != nil {
+			return nil, nil, fmt.Errorf("load roster: %w", err)
+		}
+		clanResolver = rules.NewChainResolver(roster, resolver)
 	}
-	defer rulesFile.Close()
 
-	rules, err := rules.NewRules(rulesFile)
+	rules, err := rules.LoadRules(f.rulesFile,
+		rules.WithNameMatch(rules.NameMatch{
+			FoldCase:       f.foldCase,
+			FoldHomoglyphs: f.foldHomoglyphs,
+		}),
+		rules.WithClanDirectory(clans),
+		rules.WithClanHistory(history),
+		rules.WithResolver(clanResolver),
+		rules.WithWorkers(f.api.workers),
+	)
 	if err != nil {
-		logger.Fatal("parse rules", zap.Error(err))
+		return nil, nil, fmt.Errorf("parse rules: %w", err)
 	}
 
 	logger.Debug("", zap.Reflect("rules", rules))
 
-	p := &Parser{
-		f:      f,
-		logger: logger,
-		rules:  rules,
+	p = &Parser{
+		f:           f,
+		logger:      logger,
+		rules:       rules,
+		checkpoints: session.NewCheckpoints(),
+	}
+	if f.ledgerFile != "" {
+		p.ledger, err = ledger.Open(f.ledgerFile)
+		if err != nil {
+			return nil, nil, fmt.Errorf("open ledger: %w", err)
+		}
+		if f.checkpointsFile != "" {
+			p.checkpoints, err = session.LoadCheckpoints(f.checkpointsFile)
+			if err != nil {
+				return nil, nil, fmt.Errorf("load checkpoints: %w", err)
+			}
+		}
 	}
 
-	ctx, cancel := signal.NotifyContext(context.Background(), syscall.SIGILL, syscall.SIGTERM)
-	defer cancel()
+	if f.evidenceDir != "" {
+		p.evidence, err = evidence.Open(f.evidenceDir)
+		if err != nil {
+			return nil, nil, err
+		}
+	}
 
-	logger.Info("start parse")
-	if err := p.run(ctx); err != nil {
-		logger.Fatal("", zap.Error(err))
+	// ключ проверяется сразу, а не после того как всё разобрано
+	if f.signKey != "" {
+		// отчёт сверяется с доказательствами, без них подпись ничего не доказывает
+		if f.evidenceDir == "" {
+			return nil, nil, errors.New("-sign needs -evidence")
+		}
+		if err := checkEvidenceDir(f.outputFile, f.evidenceDir); err != nil {
+			return nil, nil, err
+		}
+		p.signKey, err = signing.LoadPrivateKey(f.signKey)
+		if err != nil {
+			return nil, nil, err
+		}
 	}
+
+	save = func() {
+		// то что узнали про кланы пригодится даже если что-то пошло не так
+		if err := clans.Save(f.clansFile); err != nil {
+			logger.Error("save clans", zap.Error(err))
+		}
+		if err := history.Save(f.historyFile); err != nil {
+			logger.Error("save clan history", zap.Error(err))
+		}
+		if err := resolver.SaveCache(f.cacheFile); err != nil {
+			logger.Error("save clan cache", zap.Error(err))
+		}
+		logAPIStats(logger, resolver.Stats())
+	}
+	return p, save, nil
 }
 
 type Parser struct {
@@ -92,14 +231,33 @@ type Parser struct {
 
 	logger *zap.Logger
 	rules  *rules.Rules
+	// ledger это журнал сбитий за все запуски, может не быть
+	ledger *ledger.Ledger
+	// checkpoints докуда разобраны сессии. Без журнала пустые: всё разбирается заново.
+	checkpoints *session.Checkpoints
+	// evidence это папка с доказательствами сбитий, может не быть
+	evidence *evidence.Dir
+	// signKey чем подписывать отчёт, может не быть
+	signKey ed25519.PrivateKey
+
+	// map[ник_из_лога]ник_из_правил
+	nearMisses map[string]string
+	// map[ник]ошибка
+	failedLookups map[string]error
 }
 
 func (p *Parser) run(ctx context.Context) error {
-	sessions, err := p.getSessionList()
+	source, err := session.OpenSource(p.f.logsDir)
+	if err != nil {
+		return err
+	}
+	defer source.Close()
+
+	sessions, err := p.getSessionList(source)
 	if err != nil {
 		return fmt.Errorf("scan sessions: %w", err)
 	}
-	p.logger.Debug("session list", zap.Times("sessions", sessions))
+	p.logger.Debug("session list", zap.Strings("sessions", sessions))
 
 	// TODO
 	output, err := os.Create(p.f.outputFile)
@@ -116,16 +274,32 @@ func (p *Parser) run(ctx context.Context) error {
 	w.Flush()
 	p.logger.Info("write csv header")
 
-	for _, session := range sessions {
-		p.logger.Info("start process session", zap.Stringer("session", session))
+	for idx, sessionName := range sessions {
+		state := p.checkpoints.Get(sessionName)
+		if state.Done {
+			p.logger.Debug("session is already parsed", zap.String("session", sessionName))
+			continue
+		}
+		p.logger.Info("start process session", zap.String("session", sessionName))
 
-		sessionReport, err := p.parseSession(ctx, session)
+		sessionReport, err := p.parseSession(ctx, source, sessionName, state.Checkpoint)
 		if err != nil {
-			return fmt.Errorf("parse session: %s :%w", session, err)
+			return fmt.Errorf("parse session: %s :%w", sessionName, err)
+		}
+		if err := p.saveEvidence(source, sessionName, sessionReport); err != nil {
+			return fmt.Errorf("save evidence: %s: %w", sessionName, err)
+		}
+
+		if p.ledger != nil {
+			// игра пишет только в последнюю сессию, остальные закончились
+			if err := p.record(sessionName, sessionReport, idx != len(sessions)-1); err != nil {
+				return err
+			}
+			continue
 		}
 
 		p.logger.Info("write report")
-		ri := NewReportIter(sessionReport)
+		ri := NewReportIter(sessionReport.Scored())
 		for ri.Next() {
 			err := w.Write(ri.Line())
 			if err != nil {
@@ -136,30 +310,91 @@ func (p *Parser) run(ctx context.Context) error {
 		w.Flush()
 	}
 
+	// в журнале есть и сбития из логов, которых уже нет
+	if p.ledger != nil {
+		p.logger.Info("write report from ledger", zap.Int("kills", p.ledger.Len()))
+		for _, kill := range p.ledger.Kills() {
+			if err := w.Write(killLine(kill)); err != nil {
+				return fmt.Errorf("write result linte: %w", err)
+			}
+		}
+	}
+
+	p.reportNearMisses()
+	p.reportFailedLookups()
+
 	p.logger.Info("flush output")
 	w.Flush()
-	return w.Error()
+	if err := w.Error(); err != nil {
+		return err
+	}
+
+	if p.signKey == nil {
+		return nil
+	}
+	return p.signReport()
 }
 
-const sessionTimeFormat = "2006.01.02 15.04.05.999"
+// reportNearMisses выводит ники врагов, которые почти совпали с никами из правил
+func (p *Parser) reportNearMisses() {
+	seen := make([]string, 0, len(p.nearMisses))
+	for nickname := range p.nearMisses {
+		seen = append(seen, nickname)
+	}
+	sort.Strings(seen)
 
-func (p *Parser) parseSession(ctx context.Context, sessionStart time.Time) (*SessionReport, error) {
-	sessionName := sessionStart.Format(sessionTimeFormat)
-	startedAt, err := time.Parse(sessionTimeFormat, sessionName)
-	if err != nil {
-		return nil, fmt.Errorf("parse session date: %q: %w", sessionName, err)
+	for _, nickname := range seen {
+		p.logger.Warn("did you mean",
+			zap.String("seen_in_logs", nickname),
+			zap.String("in_rules", p.nearMisses[nickname]))
+	}
+}
+
+// reportFailedLookups выводит врагов, клан которых так и не узнали.
+// Если среди них были охотничьи корпорации, то очки за них не посчитались.
+func (p *Parser) reportFailedLookups() {
+	if len(p.failedLookups) == 0 {
+		return
 	}
 
-	combat, err := os.Open(filepath.Join(p.f.logsDir, sessionName, "combat.log"))
+	failed := make([]string, 0, len(p.failedLookups))
+	for nickname := range p.failedLookups {
+		failed = append(failed, nickname)
+	}
+	sort.Strings(failed)
+
+	for _, nickname := range failed {
+		p.logger.Warn("clan lookup failed",
+			zap.String("nickname", nickname),
+			zap.Error(p.failedLookups[nickname]))
+	}
+	p.logger.Warn("some clans are unknown, run \"haward cache clear -failed\" and try again later",
+		zap.Int("count", len(failed)))
+}
+
+const sessionTimeFormat = session.TimeFormat
+
+// parseSession разбирает сессию начиная с чекпоинта cp
+func (p *Parser) parseSession(
+	ctx context.Context,
+	source session.Source,
+	sessionName string,
+	cp session.Checkpoint,
+) (*SessionReport, error) {
+	startedAt, err := session.StartedAt(sessionName)
 	if err != nil {
-		return nil, fmt.Errorf("open combat log: %w", err)
+		return nil, err
 	}
-	game, err := os.Open(filepath.Join(p.f.logsDir, sessionName, "game.log"))
+
+	combat, game, cp, err := p.openSession(source, sessionName, cp)
 	if err != nil {
-		return nil, fmt.Errorf("open game log: %w", err)
+		return nil, err
 	}
+	defer combat.Close()
+	defer game.Close()
 
-	parser := session.NewParser(p.f.yourNickname, combat, game, p.rules)
+	parser := session.NewParser(p.f.yourNickname, startedAt, combat, game, p.rules)
+	parser.Resume(cp)
 
 	done := make(chan error, 1)
 	levelReports := make(chan *session.LevelReport)
@@ -180,11 +415,18 @@ func (p *Parser) parseSession(ctx context.Context, sessionStart time.Time) (*Ses
 	var s SessionReport
 
 	s.StartedAt = startedAt
+	s.Checkpoint = cp
 
 	for levelReport := range levelReports {
+		s.Checkpoint = levelReport.Checkpoint
+		p.collectWarnings(levelReport)
+
+		// бой без очков нужен ради сбитий, которые в нём видно
+		if len(levelReport.Score) != 0 || levelReport.MatchID != "" {
+			s.Levels = append(s.Levels, levelReport)
+		}
 		lvl := zapcore.DebugLevel
 		if len(levelReport.Score) != 0 {
-			s.Levels = append(s.Levels, levelReport)
 			lvl = zapcore.InfoLevel
 		}
 		p.logger.Check(lvl, "got level report").Write(zap.Int("length", len(levelReport.Score)))
@@ -194,8 +436,79 @@ func (p *Parser) parseSession(ctx context.Context, sessionStart time.Time) (*Ses
 	return &s, <-done
 }
 
-func (p *Parser) getSessionList() ([]time.Time, error) {
-	sessions, err := os.ReadDir(p.f.logsDir)
+// record дописывает сбития в журнал и запоминает докуда разобрана сессия.
+// done значит что сессия закончилась и больше её читать не надо.
+func (p *Parser) record(sessionName string, s *SessionReport, done bool) error {
+	added, err := p.ledger.Add(sessionKills(sessionName, p.f.yourNickname, s)...)
+	if err != nil {
+		return err
+	}
+	p.logger.Info("add kills to ledger", zap.Int("new", added))
+
+	p.checkpoints.Set(sessionName, session.State{
+		Done:       done,
+		Checkpoint: s.Checkpoint,
+	})
+	if p.f.checkpointsFile == "" {
+		return nil
+	}
+	return p.checkpoints.Save(p.f.checkpointsFile)
+}
+
+// collectWarnings запоминает подозрительных врагов из боя, чтобы показать их в конце
+func (p *Parser) collectWarnings(levelReport *session.LevelReport) {
+	for seen, target := range levelReport.NearMisses {
+		if p.nearMisses == nil {
+			p.nearMisses = make(map[string]string)
+		}
+		p.nearMisses[seen] = target
+	}
+	for nickname, err := range levelReport.FailedLookups {
+		if p.failedLookups == nil {
+			p.failedLookups = make(map[string]error)
+		}
+		p.failedLookups[nickname] = err
+	}
+}
+
+// openSession открывает логи сессии и проматывает их до чекпоинта.
+// Если логи короче чекпоинта, то это уже другие логи, и они читаются с начала.
+func (p *Parser) openSession(
+	source session.Source,
+	sessionName string,
+	cp session.Checkpoint,
+) (combat, game io.ReadCloser, _ session.Checkpoint, err error) {
+	for {
+		combat, err = source.Open(sessionName, "combat.log")
+		if err != nil {
+			return nil, nil, cp, fmt.Errorf("open combat log: %w", err)
+		}
+		game, err = source.Open(sessionName, "game.log")
+		if err != nil {
+			combat.Close()
+			return nil, nil, cp, fmt.Errorf("open game log: %w", err)
+		}
+
+		err = session.Skip(combat, cp.CombatOffset)
+		if err == nil {
+			err = session.Skip(game, cp.Game.Offset)
+		}
+		if err == nil {
+			return combat, game, cp, nil
+		}
+
+		combat.Close()
+		game.Close()
+		if !errors.Is(err, session.ErrShortLog) {
+			return nil, nil, cp, err
+		}
+		p.logger.Warn("logs are shorter than the checkpoint, parse them again", zap.String("session", sessionName))
+		cp = session.Checkpoint{}
+	}
+}
+
+func (p *Parser) getSessionList(source session.Source) ([]string, error) {
+	sessions, err := source.Sessions()
 	if err != nil {
 		return nil, err
 	}
@@ -211,13 +524,10 @@ func (p *Parser) getSessionList() ([]time.Time, error) {
 		p.logger.Debug("log after", zap.Time("time", logsAfter))
 	}
 
-	var res []time.Time
+	var res []string
 
-	for _, session := range sessions {
-		if !session.IsDir() {
-			continue
-		}
-		sessionStart, err := time.Parse(sessionTimeFormat, session.Name())
+	for _, sessionName := range sessions {
+		sessionStart, err := session.StartedAt(sessionName)
 		if err != nil {
 			continue
 		}
@@ -226,12 +536,8 @@ func (p *Parser) getSessionList() ([]time.Time, error) {
 			continue
 		}
 
-		res = append(res, sessionStart)
+		res = append(res, sessionName)
 	}
 
-	sort.Slice(res, func(i, j int) bool {
-		return res[j].After(res[i])
-	})
-
 	return res, nil
 }
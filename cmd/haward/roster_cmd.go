@@ -0,0 +1,119 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"flag"
+	"fmt"
+	"os"
+	"os/signal"
+	"syscall"
+
+	"github.com/Feresey/haward/rules"
+)
+
+// rosterCommand собирает составы корпораций, за которые охотятся, из API или из готового списка.
+// Составы попадают в кэш кланов, так что при подсчёте очков за ними в сеть уже не ходим,
+// и пишутся в CSV, который можно опубликовать и потом отдать в -roster.
+func rosterCommand(args []string) error {
+	fs := flag.NewFlagSet("roster", flag.ExitOnError)
+	rulesFile := fs.String("rules", "rules.txt", "Path to the rules file")
+	clansFile := fs.String("clans", "clans.json", "Path to the clan tag and name directory")
+	cacheFile := fs.String("cache", "clan_cache.json", "Path to the player clan cache")
+	var api apiFlags
+	api.register(fs)
+	from := fs.String("from", "", "Take members from this roster CSV instead of the game API")
+	output := fs.String("o", "roster.csv", "Path to the output roster, - for stdout")
+	fs.Usage = func() {
+		fmt.Fprintf(fs.Output(), "Usage: haward roster [flags]\n")
+		fs.PrintDefaults()
+	}
+	if err := parseFlags(fs, args); err != nil {
+		return err
+	}
+
+	ctx, cancel := signal.NotifyContext(context.Background(), syscall.SIGINT, syscall.SIGTERM)
+	defer cancel()
+
+	clans, err := rules.LoadClanDirectory(*clansFile)
+	if err != nil {
+		return err
+	}
+	resolver, err := api.newResolver(*cacheFile)
+	if err != nil {
+		return err
+	}
+	logger, err := newLogger(false)
+	if err != nil {
+		return err
+	}
+	defer func() {
+		logAPIStats(logger, resolver.Stats())
+	}()
+
+	var source rules.MembersResolver = resolver
+	if *from != "" {
+		roster, err := rules.LoadRoster(*from)
+		if err != nil {
+			return err
+		}
+		source = roster
+	}
+
+	r, err := rules.LoadRules(*rulesFile,
+		rules.WithClanDirectory(clans),
+		rules.WithResolver(resolver),
+		rules.WithWorkers(api.workers),
+	)
+	if err != nil {
+		return err
+	}
+
+	players := make(map[string]rules.Clan)
+	var failed int
+	for _, hunted := range r.Corporations() {
+		clan, members, err := source.GetClanMembers(ctx, hunted)
+		if err != nil {
+			if ctx.Err() != nil {
+				return ctx.Err()
+			}
+			fmt.Fprintf(os.Stderr, "roster: %v\n", err)
+			failed++
+			continue
+		}
+
+		clans.Add(*clan)
+		for _, nickname := range members {
+			players[nickname] = *clan
+			resolver.Remember(nickname, *clan)
+		}
+		fmt.Fprintf(os.Stderr, "roster: %s [%s]: %d members\n", clan.Name, clan.Tag, len(members))
+	}
+
+	if err := clans.Save(*clansFile); err != nil {
+		return err
+	}
+	if err := resolver.SaveCache(*cacheFile); err != nil {
+		return err
+	}
+	if err := writeRoster(*output, players); err != nil {
+		return err
+	}
+
+	if failed != 0 {
+		return fmt.Errorf("%d corporations are not found", failed)
+	}
+	return nil
+}
+
+func writeRoster(path string, players map[string]rules.Clan) error {
+	var buf bytes.Buffer
+	if err := rules.WriteRoster(&buf, players); err != nil {
+		return err
+	}
+	if path == "-" {
+		_, err := buf.WriteTo(os.Stdout)
+		return err
+	}
+	return os.WriteFile(path, buf.Bytes(), 0o644)
+}
@@ -0,0 +1,101 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+
+	"github.com/Feresey/haward/rules"
+)
+
+func rulesCommand(args []string) error {
+	const usage = "usage: haward rules fmt|merge [flags]"
+
+	if len(args) == 0 {
+		return errors.New(usage)
+	}
+
+	switch args[0] {
+	case "fmt":
+		return rulesFmt(args[1:])
+	case "merge":
+		return rulesMerge(args[1:])
+	default:
+		return fmt.Errorf("unknown command: %q, %s", args[0], usage)
+	}
+}
+
+// rulesFmt приводит файлы правил к каноническому виду, как go fmt.
+// Без файлов читает stdin и пишет в stdout.
+func rulesFmt(args []string) error {
+	fs := flag.NewFlagSet("rules fmt", flag.ExitOnError)
+	write := fs.Bool("w", false, "write result to the source file instead of stdout")
+	fs.Usage = func() {
+		fmt.Fprintf(fs.Output(), "Usage: haward rules fmt [-w] [files...]\n")
+		fs.PrintDefaults()
+	}
+	_ = fs.Parse(args)
+
+	if fs.NArg() == 0 {
+		return formatRules(os.Stdin, os.Stdout)
+	}
+
+	for _, path := range fs.Args() {
+		data, err := os.ReadFile(path)
+		if err != nil {
+			return err
+		}
+
+		var buf bytes.Buffer
+		if err := formatRules(bytes.NewReader(data), &buf); err != nil {
+			return fmt.Errorf("%s: %w", path, err)
+		}
+
+		if !*write {
+			if _, err := buf.WriteTo(os.Stdout); err != nil {
+				return err
+			}
+			continue
+		}
+		if bytes.Equal(data, buf.Bytes()) {
+			continue
+		}
+		if err := os.WriteFile(path, buf.Bytes(), 0o644); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+func formatRules(rd io.Reader, w io.Writer) error {
+	f, err := rules.ParseFile(rd)
+	if err != nil {
+		return err
+	}
+	return f.Format(w)
+}
+
+// rulesMerge показывает итоговые правила со всеми подключёнными файлами.
+func rulesMerge(args []string) error {
+	fs := flag.NewFlagSet("rules merge", flag.ExitOnError)
+	rulesFile := fs.String("rules", "rules.txt", "Path to the rules file")
+	asJSON := fs.Bool("json", false, "print rules as JSON")
+	_ = fs.Parse(args)
+
+	r, err := rules.LoadRules(*rulesFile)
+	if err != nil {
+		return err
+	}
+
+	if *asJSON {
+		enc := json.NewEncoder(os.Stdout)
+		enc.SetIndent("", "\t")
+		return enc.Encode(r)
+	}
+	return r.File().Format(os.Stdout)
+}
@@ -0,0 +1,79 @@
+package main
+
+import (
+	"fmt"
+	"path/filepath"
+	"strings"
+
+	"github.com/Feresey/haward/evidence"
+	"github.com/Feresey/haward/signing"
+	"go.uber.org/zap"
+)
+
+// manifestSuffix это расширение подписи рядом с отчётом: out.csv.sig
+const manifestSuffix = ".sig"
+
+// signReport подписывает отчёт и все доказательства. Пути в подписи считаются от папки отчёта,
+// так что отправлять их организаторам надо вместе, не меняя расположение.
+func (p *Parser) signReport() error {
+	// пути бывают и относительные и абсолютные вперемешку, считаем всё от абсолютных
+	root, err := filepath.Abs(filepath.Dir(p.f.outputFile))
+	if err != nil {
+		return err
+	}
+	names := []string{filepath.Base(p.f.outputFile)}
+	if p.f.evidenceDir != "" {
+		if err := checkEvidenceDir(p.f.outputFile, p.f.evidenceDir); err != nil {
+			return err
+		}
+		dir, err := filepath.Abs(p.f.evidenceDir)
+		if err != nil {
+			return err
+		}
+		files, err := evidence.Files(dir)
+		if err != nil {
+			return err
+		}
+		for _, path := range files {
+			name, err := filepath.Rel(root, path)
+			if err != nil {
+				return err
+			}
+			names = append(names, filepath.ToSlash(name))
+		}
+	}
+
+	manifest, err := signing.Sign(p.signKey, p.f.yourNickname, root, names)
+	if err != nil {
+		return err
+	}
+	path := p.f.outputFile + manifestSuffix
+	if err := manifest.Save(path); err != nil {
+		return err
+	}
+
+	public, _ := manifest.Key()
+	p.logger.Info("sign report",
+		zap.String("signature", path),
+		zap.Int("files", len(names)),
+		zap.String("key", signing.Fingerprint(public)))
+	return nil
+}
+
+// checkEvidenceDir проверяет, что доказательства лежат в папке отчёта.
+// Организатор проверяет только присланную папку, и файлы снаружи неё он искал бы у себя.
+func checkEvidenceDir(outputFile, evidenceDir string) error {
+	root, err := filepath.Abs(filepath.Dir(outputFile))
+	if err != nil {
+		return err
+	}
+	dir, err := filepath.Abs(evidenceDir)
+	if err != nil {
+		return err
+	}
+	rel, err := filepath.Rel(root, dir)
+	if err != nil || rel == ".." || strings.HasPrefix(rel, ".."+string(filepath.Separator)) {
+		return fmt.Errorf("evidence dir %q must be inside the report dir %q to sign it", evidenceDir, filepath.Dir(outputFile))
+	}
+	return nil
+}
@@ -0,0 +1,67 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/Feresey/haward/evidence"
+	"github.com/Feresey/haward/signing"
+	"github.com/stretchr/testify/require"
+	"go.uber.org/zap"
+)
+
+func TestSignReport(t *testing.T) {
+	r := require.New(t)
+	dir, err := filepath.EvalSymlinks(t.TempDir())
+	r.NoError(err)
+
+	keyPath := filepath.Join(dir, "haward.key")
+	public, err := signing.GenerateKey(keyPath)
+	r.NoError(err)
+	private, err := signing.LoadPrivateKey(keyPath)
+	r.NoError(err)
+
+	// доказательства прямо в папке отчёта, рядом с кэшем и справочником кланов
+	d, err := evidence.Open(dir)
+	r.NoError(err)
+	b := evidence.Bundle{Session: "2021.08.10 20.02.16", Match: "20:10:00.000", Line: 42, Killed: "Cat"}
+	_, err = d.Add(b)
+	r.NoError(err)
+	_, err = d.AddMatch(evidence.Match{Session: b.Session, Match: b.Match, ID: "45996460"})
+	r.NoError(err)
+	for _, name := range []string{"clans.json", "clan_cache.json", "out.csv"} {
+		r.NoError(os.WriteFile(filepath.Join(dir, name), []byte("{}"), 0o644))
+	}
+
+	// отчёт по относительному пути, доказательства по абсолютному
+	wd, err := os.Getwd()
+	r.NoError(err)
+	r.NoError(os.Chdir(dir))
+	t.Cleanup(func() { _ = os.Chdir(wd) })
+
+	p := &Parser{
+		f: flags{
+			outputFile:   "out.csv",
+			evidenceDir:  dir,
+			yourNickname: "ZiroTwo",
+		},
+		logger:  zap.NewNop(),
+		signKey: private,
+	}
+	r.NoError(p.signReport())
+
+	m, err := signing.LoadManifest("out.csv" + manifestSuffix)
+	r.NoError(err)
+	r.NoError(m.CheckSignature(public))
+
+	var names []string
+	for _, file := range m.Files {
+		names = append(names, file.Name)
+	}
+	r.ElementsMatch([]string{
+		"out.csv",
+		b.Session + "/20.10.00.000_42.json",
+		b.Session + "/20.10.00.000_45996460" + evidence.MatchSuffix,
+	}, names)
+}
@@ -0,0 +1,365 @@
+package main
+
+import (
+	"context"
+	"crypto/ed25519"
+	"encoding/csv"
+	"flag"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/Feresey/haward/evidence"
+	"github.com/Feresey/haward/ledger"
+	"github.com/Feresey/haward/parse"
+	"github.com/Feresey/haward/rules"
+	"github.com/Feresey/haward/signing"
+)
+
+// verifyCommand это проверка отчёта охотника для организаторов
+func verifyCommand(args []string) error {
+	fs := flag.NewFlagSet("verify", flag.ExitOnError)
+	pubPath := fs.String("pub", "", "Path to the public key the hunter sent beforehand. Without it the report is only checked for changes after signing")
+	rulesFile := fs.String("rules", "", "Path to the rules file to check the scores against")
+	fs.Usage = func() {
+		fmt.Fprintf(fs.Output(), "Usage: haward verify [flags] out.csv%s\n", manifestSuffix)
+		fs.PrintDefaults()
+	}
+	_ = fs.Parse(args)
+	if fs.NArg() != 1 {
+		fs.Usage()
+		return fmt.Errorf("want the signature file")
+	}
+
+	var trusted ed25519.PublicKey
+	if *pubPath != "" {
+		var err error
+		trusted, err = signing.LoadPublicKey(*pubPath)
+		if err != nil {
+			return err
+		}
+	}
+
+	res, err := verifyReport(fs.Arg(0), trusted, *rulesFile)
+	if err != nil {
+		return err
+	}
+
+	for _, problem := range res.Problems {
+		fmt.Println(problem)
+	}
+	fmt.Printf("hunter %s, key %s: %d kills, score %d\n", res.Hunter, res.Key, res.Kills, res.Score)
+	if trusted == nil {
+		fmt.Println("the key is not checked, pass -pub with the key the hunter sent")
+	}
+	if len(res.Problems) != 0 {
+		return fmt.Errorf("%d problems", len(res.Problems))
+	}
+	return nil
+}
+
+// verifyResult это что нашла проверка отчёта
+type verifyResult struct {
+	Hunter string
+	// Key отпечаток ключа, которым подписан отчёт
+	Key   string
+	Kills int
+	Score int
+	// Problems это поддельные, поправленные и невозможные записи
+	Problems []string
+}
+
+func (v *verifyResult) problem(format string, args ...interface{}) {
+	v.Problems = append(v.Problems, fmt.Sprintf(format, args...))
+}
+
+// verifyReport проверяет подпись, хэши файлов, сам отчёт против доказательств и, если есть правила, очки.
+func verifyReport(manifestPath string, trusted ed25519.PublicKey, rulesFile string) (*verifyResult, error) {
+	manifest, err := signing.LoadManifest(manifestPath)
+	if err != nil {
+		return nil, err
+	}
+	root := filepath.Dir(manifestPath)
+
+	res := &verifyResult{Hunter: manifest.Hunter}
+	if key, err := manifest.Key(); err == nil {
+		res.Key = signing.Fingerprint(key)
+	}
+	if err := manifest.CheckSignature(trusted); err != nil {
+		res.problem("signature: %v", err)
+	}
+	for _, err := range manifest.CheckFiles(root) {
+		res.problem("file: %v", err)
+	}
+
+	var report string
+	// map[session/line]
+	bundles := make(map[string]evidence.Bundle)
+	for _, file := range manifest.Files {
+		path := filepath.Join(root, filepath.FromSlash(file.Name))
+		switch {
+		case report == "" && strings.HasSuffix(file.Name, ".csv"):
+			report = path
+		case strings.HasSuffix(file.Name, evidence.MatchSuffix):
+			m, err := evidence.LoadMatch(path)
+			if err != nil {
+				res.problem("evidence: %v", err)
+				continue
+			}
+			if m.Hunter != manifest.Hunter {
+				res.problem("evidence %s: hunter is %s, the report is signed by %s", file.Name, m.Hunter, manifest.Hunter)
+			}
+		case strings.HasSuffix(file.Name, ".json"):
+			b, err := evidence.Load(path)
+			if err != nil {
+				res.problem("evidence: %v", err)
+				continue
+			}
+			if err := b.Check(); err != nil {
+				res.problem("evidence %s: %v", file.Name, err)
+			}
+			if b.Hunter != manifest.Hunter {
+				res.problem("evidence %s: hunter is %s, the report is signed by %s", file.Name, b.Hunter, manifest.Hunter)
+			}
+			bundles[killKey(b.Session, b.Line)] = b
+		}
+	}
+	if report == "" {
+		return nil, fmt.Errorf("no report in %s", manifestPath)
+	}
+
+	rows, err := readReport(report)
+	if err != nil {
+		return nil, err
+	}
+
+	var check func(b evidence.Bundle) error
+	if rulesFile != "" {
+		check, err = ruleChecker(rulesFile, bundles)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	seen := make(map[string]bool)
+	for idx, row := range rows {
+		// первая строчка заголовок
+		lineNum := idx + 2
+		key := killKey(row.Session, row.Line)
+		res.Kills++
+		res.Score += row.Score
+
+		if seen[key] {
+			res.problem("report line %d: %s is counted twice", lineNum, key)
+			continue
+		}
+		seen[key] = true
+
+		b, ok := bundles[key]
+		if !ok {
+			res.problem("report line %d: no evidence for the kill of %s", lineNum, row.Killed)
+			continue
+		}
+		if b.Killed != row.Killed || b.KilledAt != row.KilledAt || b.Score != row.Score || b.Clan != row.Clan {
+			res.problem("report line %d: does not match the evidence: %s at %s for %d, evidence says %s at %s for %d",
+				lineNum, row.Killed, row.KilledAt, row.Score, b.Killed, b.KilledAt, b.Score)
+			continue
+		}
+		if beforeMatch(b.Match, b.KilledAt) {
+			res.problem("report line %d: %s is killed at %s, before the match started at %s", lineNum, b.Killed, b.KilledAt, b.Match)
+		}
+		if check != nil {
+			if err := check(b); err != nil {
+				res.problem("report line %d: %v", lineNum, err)
+			}
+		}
+	}
+	return res, nil
+}
+
+func killKey(session string, line int) string {
+	return session + "/" + strconv.Itoa(line)
+}
+
+// reportRow это строчка отчёта, такая же как у SessionIter
+type reportRow struct {
+	Session  string
+	KilledAt string
+	Line     int
+	Killed   string
+	Clan     string
+	Score    int
+}
+
+func readReport(path string) ([]reportRow, error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return nil, fmt.Errorf("open report: %w", err)
+	}
+	defer file.Close()
+
+	r := csv.NewReader(file)
+	r.FieldsPerRecord = len(NewReportIter(nil).Header())
+	records, err := r.ReadAll()
+	if err != nil {
+		return nil, fmt.Errorf("read report: %w", err)
+	}
+
+	var rows []reportRow
+	for idx, record := range records {
+		if idx == 0 {
+			continue
+		}
+		line, err := strconv.Atoi(record[2])
+		if err != nil {
+			return nil, fmt.Errorf("report line %d: bad line_in_log: %w", idx+1, err)
+		}
+		score, err := strconv.Atoi(record[5])
+		if err != nil {
+			return nil, fmt.Errorf("report line %d: bad score: %w", idx+1, err)
+		}
+		rows = append(rows, reportRow{
+			Session:  record[0],
+			KilledAt: record[1],
+			Line:     line,
+			Killed:   record[3],
+			Clan:     record[4],
+			Score:    score,
+		})
+	}
+	return rows, nil
+}
+
+// beforeMatch проверяет, что сбитие раньше начала боя. Бой мог перевалить за полночь.
+func beforeMatch(match, killedAt string) bool {
+	start, err := time.Parse(ledger.MatchFormat, match)
+	if err != nil {
+		return false
+	}
+	at, err := time.Parse(ledger.MatchFormat, killedAt)
+	if err != nil {
+		return false
+	}
+	return at.Before(start) && start.Sub(at) < 12*time.Hour
+}
+
+// reportPlayers это кланы и профили игроков из присланных отчётов, чтобы проверять правила без запросов в API.
+type reportPlayers struct {
+	clans map[string]rules.Clan
+	// profiles записаны только у тех, за кого награда по профилю
+	profiles map[string]*rules.PlayerProfile
+}
+
+func newReportPlayers() *reportPlayers {
+	return &reportPlayers{
+		clans:    make(map[string]rules.Clan),
+		profiles: make(map[string]*rules.PlayerProfile),
+	}
+}
+
+func (p *reportPlayers) add(nickname string, clan rules.Clan, profile *rules.PlayerProfile) {
+	p.clans[nickname] = clan
+	if profile != nil {
+		p.profiles[nickname] = profile
+	}
+}
+
+// reportClan это клан цели из доказательства или журнала.
+// В старых записях тега и названия по отдельности нет, тогда Clan считается тегом.
+func reportClan(clan, tag, name string) rules.Clan {
+	if tag == "" && name == "" {
+		return rules.Clan{Tag: clan}
+	}
+	return rules.Clan{Tag: tag, Name: name}
+}
+
+// addTo дописывает в справочник теги и названия кланов из отчётов.
+// Без этого по тегу из лога не найти корпорацию, которая записана в правилах только названием.
+func (p *reportPlayers) addTo(directory *rules.ClanDirectory) {
+	for _, clan := range p.clans {
+		directory.Add(clan)
+	}
+}
+
+func (p *reportPlayers) GetPlayerClan(_ context.Context, nickname string) (*rules.Clan, error) {
+	clan, ok := p.clans[nickname]
+	if !ok {
+		return nil, fmt.Errorf("%w: %s", rules.ErrUnknownPlayer, nickname)
+	}
+	if clan.IsZero() {
+		return nil, fmt.Errorf("%w: %s", rules.ErrNoClan, nickname)
+	}
+	return &clan, nil
+}
+
+func (p *reportPlayers) GetPlayerProfile(_ context.Context, nickname string) (*rules.PlayerProfile, error) {
+	profile, ok := p.profiles[nickname]
+	if !ok {
+		return nil, fmt.Errorf("get player profile: %s, profile is not in the report", nickname)
+	}
+	return profile, nil
+}
+
+// ruleChecker возвращает проверку, что за сбитие по правилам положено столько, сколько записано.
+// Кланы и профили игроков берутся из доказательств.
+func ruleChecker(rulesFile string, bundles map[string]evidence.Bundle) (func(b evidence.Bundle) error, error) {
+	players := newReportPlayers()
+	for _, b := range bundles {
+		players.add(b.Killed, reportClan(b.Clan, b.ClanTag, b.ClanName), b.Profile)
+	}
+	directory := rules.NewClanDirectory()
+	players.addTo(directory)
+
+	r, err := rules.LoadRules(rulesFile,
+		rules.WithClanDirectory(directory),
+		rules.WithResolver(players),
+	)
+	if err != nil {
+		return nil, fmt.Errorf("parse rules: %w", err)
+	}
+
+	return func(b evidence.Bundle) error {
+		target := lastPlayer(b.KilledLines, b.Killed)
+		hunter := lastPlayer(b.HunterLines, b.Hunter)
+
+		// группы видно по строчкам из game.log, они важнее того, что записано в доказательстве
+		if target.InGroup != b.KilledInGroup || hunter.InGroup != b.HunterInGroup {
+			return fmt.Errorf("groups of %s and %s do not match the ADD_PLAYER lines", b.Hunter, b.Killed)
+		}
+		bounty, ok := r.GetBounty(context.Background(), target, b.StartedAt)
+		if !ok {
+			return fmt.Errorf("%s is not in the rules", b.Killed)
+		}
+		if bounty.Corporation && r.Clanmates(hunter, target, b.StartedAt) {
+			return fmt.Errorf("%s and %s are clanmates", b.Hunter, b.Killed)
+		}
+		award, ok := r.Award(bounty, rules.Kill{
+			Target:    target,
+			Hunter:    hunter,
+			GameMode:  b.GameMode,
+			ShotFirst: b.ShotFirst,
+			At:        b.StartedAt,
+		})
+		if !ok {
+			return fmt.Errorf("%s is allowed to be killed in this match, the kill does not count", b.Killed)
+		}
+		if award != b.Score {
+			return fmt.Errorf("%s is scored %d, the rules give %d", b.Killed, b.Score, award)
+		}
+		return nil
+	}, nil
+}
+
+// lastPlayer это игрок из последней строчки ADD_PLAYER, в ней самый свежий клан
+func lastPlayer(lines []string, nickname string) parse.Player {
+	for i := len(lines) - 1; i >= 0; i-- {
+		player, err := parse.ParsePlayerLine(lines[i])
+		if err == nil && player.Name == nickname {
+			return *player
+		}
+	}
+	return parse.Player{Name: nickname}
+}
@@ -0,0 +1,186 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/Feresey/haward/evidence"
+	"github.com/Feresey/haward/rules"
+	"github.com/Feresey/haward/signing"
+	"github.com/stretchr/testify/require"
+)
+
+func TestVerifyReport(t *testing.T) {
+	r := require.New(t)
+	dir := t.TempDir()
+
+	keyPath := filepath.Join(dir, "haward.key")
+	public, err := signing.GenerateKey(keyPath)
+	r.NoError(err)
+	private, err := signing.LoadPrivateKey(keyPath)
+	r.NoError(err)
+
+	d, err := evidence.Open(filepath.Join(dir, "evidence"))
+	r.NoError(err)
+	b := evidence.Bundle{
+		Session:  "2021.08.10 20.02.16",
+		Match:    "20:10:00.000",
+		Line:     42,
+		Hunter:   "ZiroTwo",
+		Killed:   "Cat",
+		Clan:     "NEKO",
+		ClanTag:  "NEKO",
+		ClanName: "Neko Corp",
+		KilledAt: "20:12:01.100",
+		Score:    5,
+		CombatLines: []string{
+			"20:12:01.100  CMBT   | Killed Cat\t Ship_T5|0000000111;\t killer ZiroTwo|0000002708 Weapon_X",
+		},
+		FirstLine:     42,
+		HunterLines:   []string{"20:10:01.000         | client: ADD_PLAYER 0 (ZiroTwo [xIDx], 2516405) status 4 team 1 group 4778580"},
+		HunterInGroup: true,
+		KilledLines:   []string{"20:10:01.000         | client: ADD_PLAYER 1 (Cat [NEKO], 111) status 4 team 2"},
+	}
+	_, err = d.Add(b)
+	r.NoError(err)
+	// все сбития боя лежат рядом и доказательством сбития не считаются
+	_, err = d.AddMatch(evidence.Match{Session: b.Session, Match: b.Match, ID: "45996460", Hunter: "ZiroTwo"})
+	r.NoError(err)
+
+	rulesFile := filepath.Join(dir, "rules.txt")
+	r.NoError(os.WriteFile(rulesFile, []byte("=== CORPORATIONS ===\n+5\nNeko Corp [NEKO]\n"), 0o644))
+
+	const header = "session_start,killed_at,line_in_log,killed,clan,score\n"
+	const row = "2021.08.10 20.02.16,20:12:01.100,42,Cat,NEKO,5\n"
+	sign := func(report string) string {
+		out := filepath.Join(dir, "out.csv")
+		r.NoError(os.WriteFile(out, []byte(report), 0o644))
+		m, err := signing.Sign(private, "ZiroTwo", dir, []string{
+			"out.csv",
+			"evidence/" + b.Session + "/20.10.00.000_42.json",
+			"evidence/" + b.Session + "/20.10.00.000_45996460" + evidence.MatchSuffix,
+		})
+		r.NoError(err)
+		r.NoError(m.Save(out + manifestSuffix))
+		return out + manifestSuffix
+	}
+
+	res, err := verifyReport(sign(header+row), public, rulesFile)
+	r.NoError(err)
+	r.Empty(res.Problems)
+	r.Equal(1, res.Kills)
+	r.Equal(5, res.Score)
+	r.Equal(signing.Fingerprint(public), res.Key)
+
+	// чужой ключ
+	other, err := signing.GenerateKey(filepath.Join(dir, "other.key"))
+	r.NoError(err)
+	res, err = verifyReport(filepath.Join(dir, "out.csv.sig"), other, "")
+	r.NoError(err)
+	requireProblems(t, []string{"signature: bad signature"}, res.Problems)
+
+	// поправили отчёт после подписи
+	r.NoError(os.WriteFile(filepath.Join(dir, "out.csv"), []byte(header+"2021.08.10 20.02.16,20:12:01.100,42,Cat,NEKO,50\n"), 0o644))
+	res, err = verifyReport(filepath.Join(dir, "out.csv.sig"), public, "")
+	r.NoError(err)
+	requireProblems(t, []string{"file: log does not match the evidence: out.csv", "report line 2: does not match the evidence"}, res.Problems)
+
+	// подписали поправленный отчёт: очки не сходятся с доказательством, сбитие без доказательства и дубль
+	res, err = verifyReport(sign(header+row+
+		"2021.08.10 20.02.16,20:12:01.100,42,Cat,NEKO,5\n"+
+		"2021.08.10 20.02.16,20:13:00.000,50,Dog,NEKO,5\n"), public, rulesFile)
+	r.NoError(err)
+	requireProblems(t, []string{"report line 3: 2021.08.10 20.02.16/42 is counted twice", "report line 4: no evidence for the kill of Dog"}, res.Problems)
+	r.Equal(3, res.Kills)
+
+	// правила поменялись
+	r.NoError(os.WriteFile(rulesFile, []byte("=== CORPORATIONS ===\n+3\nNeko Corp [NEKO]\n"), 0o644))
+	res, err = verifyReport(sign(header+row), public, rulesFile)
+	r.NoError(err)
+	requireProblems(t, []string{"report line 2: Cat is scored 5, the rules give 3"}, res.Problems)
+
+	// корпорация в правилах только по названию, а в логе тег: название берётся из доказательства
+	r.NoError(os.WriteFile(rulesFile, []byte("=== CORPORATIONS ===\n+5\nNeko Corp\n"), 0o644))
+	res, err = verifyReport(sign(header+row), public, rulesFile)
+	r.NoError(err)
+	r.Empty(res.Problems)
+
+	// награда за профиль проверяется по профилю из доказательства
+	r.NoError(os.WriteFile(rulesFile, []byte("=== PROFILES ===\n+5\nrating >= 5000\n"), 0o644))
+	res, err = verifyReport(sign(header+row), public, rulesFile)
+	r.NoError(err)
+	requireProblems(t, []string{"report line 2: Cat is not in the rules"}, res.Problems)
+
+	b.Profile = &rules.PlayerProfile{Nickname: "Cat", EffRating: 6000}
+	r.NoError(os.Remove(d.Path(b)))
+	_, err = d.Add(b)
+	r.NoError(err)
+	res, err = verifyReport(sign(header+row), public, rulesFile)
+	r.NoError(err)
+	r.Empty(res.Problems)
+	b.Profile = nil
+
+	// условия @allow тоже проверяются: штрафная цель первой начала стрелять, так что сбитие не считается
+	const punished = "2021.08.10 20.02.16,20:12:01.100,42,Cat,NEKO,-5\n"
+	r.NoError(os.WriteFile(rulesFile, []byte("=== PLAYERS ===\n-5\n@allow shot_first\nCat\n"), 0o644))
+	b.Score = -5
+	b.ShotFirst = true
+	r.NoError(os.Remove(d.Path(b)))
+	_, err = d.Add(b)
+	r.NoError(err)
+	res, err = verifyReport(sign(header+punished), public, rulesFile)
+	r.NoError(err)
+	requireProblems(t, []string{"report line 2: Cat is allowed to be killed in this match, the kill does not count"}, res.Problems)
+
+	// цель летала в группе, а в доказательстве это спрятали
+	r.NoError(os.WriteFile(rulesFile, []byte("=== PLAYERS ===\n-5\n@allow group\nCat\n"), 0o644))
+	b.ShotFirst = false
+	b.KilledLines = []string{"20:10:01.000         | client: ADD_PLAYER 1 (Cat [NEKO], 111) status 4 team 2 group 123"}
+	r.NoError(os.Remove(d.Path(b)))
+	_, err = d.Add(b)
+	r.NoError(err)
+	res, err = verifyReport(sign(header+punished), public, rulesFile)
+	r.NoError(err)
+	requireProblems(t, []string{"report line 2: groups of ZiroTwo and Cat do not match the ADD_PLAYER lines"}, res.Problems)
+
+	b.KilledInGroup = true
+	r.NoError(os.Remove(d.Path(b)))
+	_, err = d.Add(b)
+	r.NoError(err)
+	res, err = verifyReport(sign(header+punished), public, rulesFile)
+	r.NoError(err)
+	requireProblems(t, []string{"report line 2: Cat is allowed to be killed in this match, the kill does not count"}, res.Problems)
+}
+
+// requireProblems проверяет, что проверка нашла ровно эти проблемы, каждая начинается с want
+func requireProblems(t *testing.T, want, problems []string) {
+	t.Helper()
+	require.Len(t, problems, len(want), problems)
+	for idx := range want {
+		require.True(t, strings.HasPrefix(problems[idx], want[idx]), "want %q, got %q", want[idx], problems[idx])
+	}
+}
+
+func TestCheckEvidenceDir(t *testing.T) {
+	tests := []struct {
+		output, evidence string
+		ok               bool
+	}{
+		{output: "out.csv", evidence: "evidence", ok: true},
+		{output: "reports/out.csv", evidence: "reports/evidence", ok: true},
+		{output: "reports/out.csv", evidence: "evidence"},
+		{output: "reports/out.csv", evidence: "reports/../evidence"},
+		{output: "out.csv", evidence: "../evidence"},
+	}
+
+	for _, tt := range tests {
+		err := checkEvidenceDir(tt.output, tt.evidence)
+		if tt.ok {
+			require.NoError(t, err, "%s %s", tt.output, tt.evidence)
+		} else {
+			require.Error(t, err, "%s %s", tt.output, tt.evidence)
+		}
+	}
+}
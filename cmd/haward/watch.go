@@ -0,0 +1,337 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"os/signal"
+	"path/filepath"
+	"syscall"
+	"time"
+
+	"github.com/Feresey/haward/ledger"
+	"github.com/Feresey/haward/session"
+	"go.uber.org/zap"
+	"go.uber.org/zap/zapcore"
+)
+
+// DefaultPoll как часто watch проверяет, не дописала ли игра логи
+const DefaultPoll = time.Second
+
+func watchCommand(args []string) error {
+	var f flags
+	fs := flag.NewFlagSet("watch", flag.ExitOnError)
+	f.register(fs)
+	poll := fs.Duration("poll", DefaultPoll, "How often to check the logs for new lines")
+	alertsFile := fs.String("alerts", "", "Path to the file to append the alerts about hunted players to, one JSON per line, e.g. for an overlay")
+	if err := parseFlags(fs, args); err != nil {
+		return err
+	}
+	if *poll <= 0 {
+		return fmt.Errorf("bad poll interval: %s", *poll)
+	}
+
+	info, err := os.Stat(f.logsDir)
+	if err != nil {
+		return err
+	}
+	if !info.IsDir() {
+		return fmt.Errorf("%q: watch needs the logs directory the game writes to", f.logsDir)
+	}
+
+	logger, err := newLogger(f.debug)
+	if err != nil {
+		return err
+	}
+	p, save, err := newParser(f, logger)
+	if err != nil {
+		return err
+	}
+	defer save()
+
+	var alerts io.Writer
+	if *alertsFile != "" {
+		file, err := os.OpenFile(*alertsFile, os.O_CREATE|os.O_APPEND|os.O_WRONLY, 0o644)
+		if err != nil {
+			return fmt.Errorf("open alerts file: %w", err)
+		}
+		defer file.Close()
+		alerts = file
+	}
+
+	ctx, cancel := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer cancel()
+
+	err = p.watch(ctx, watchOutput{console: os.Stdout, alerts: alerts}, *poll)
+	if ctx.Err() != nil {
+		// остановили по Ctrl+C, всё что успели уже в журнале
+		p.reportNearMisses()
+		p.reportFailedLookups()
+		return nil
+	}
+	return err
+}
+
+// watchOutput это куда watch выводит бои и алерты
+type watchOutput struct {
+	console io.Writer
+	// alerts для других программ, может не быть
+	alerts io.Writer
+}
+
+// watch следит за логами сессии, в которую сейчас пишет игра, и выводит очки за каждый бой,
+// как только он закончился. В начале боя выводит алерт про игроков из правил.
+// Когда игра начинает новую сессию, watch переходит на неё.
+func (p *Parser) watch(ctx context.Context, out watchOutput, poll time.Duration) error {
+	total := p.ledgerTotal()
+	for {
+		sessionName, err := p.waitSession(ctx, poll)
+		if err != nil {
+			return err
+		}
+		p.logger.Info("watch session", zap.String("session", sessionName))
+
+		if err := p.followSession(ctx, out, sessionName, poll, &total); err != nil {
+			return fmt.Errorf("watch session: %s: %w", sessionName, err)
+		}
+	}
+}
+
+// latestSession возвращает последнюю сессию в папке с логами или пустую строку, если сессий нет.
+func (p *Parser) latestSession() (string, error) {
+	source, err := session.OpenSource(p.f.logsDir)
+	if err != nil {
+		return "", err
+	}
+	defer source.Close()
+
+	sessions, err := p.getSessionList(source)
+	if err != nil || len(sessions) == 0 {
+		return "", err
+	}
+	return sessions[len(sessions)-1], nil
+}
+
+// waitSession ждёт пока появится сессия, которую ещё не дочитали
+func (p *Parser) waitSession(ctx context.Context, poll time.Duration) (string, error) {
+	for {
+		sessionName, err := p.latestSession()
+		if err != nil {
+			return "", fmt.Errorf("scan sessions: %w", err)
+		}
+		if sessionName != "" && !p.checkpoints.Get(sessionName).Done {
+			return sessionName, nil
+		}
+
+		select {
+		case <-time.After(poll):
+		case <-ctx.Done():
+			return "", ctx.Err()
+		}
+	}
+}
+
+// followSession читает логи сессии, пока игра не начнёт новую.
+// Если логи пересоздали, то сессия разбирается с начала, журнал не даст посчитать сбития дважды.
+func (p *Parser) followSession(
+	ctx context.Context,
+	out watchOutput,
+	sessionName string,
+	poll time.Duration,
+	total *int,
+) error {
+	// игра пишет только в последнюю сессию
+	newer := func() bool {
+		latest, err := p.latestSession()
+		return err == nil && latest != "" && latest != sessionName
+	}
+
+	cp := p.checkpoints.Get(sessionName).Checkpoint
+	for {
+		var err error
+		cp, err = p.followLevels(ctx, out, sessionName, cp, poll, newer, total)
+		switch {
+		// логи закончились или их так и не создали, а игра уже пишет в другую сессию
+		case errors.Is(err, io.EOF), errors.Is(err, os.ErrNotExist) && newer():
+			p.logger.Info("session is over", zap.String("session", sessionName))
+			if p.ledger == nil {
+				p.checkpoints.Set(sessionName, session.State{Done: true, Checkpoint: cp})
+				return nil
+			}
+			return p.record(sessionName, &SessionReport{Checkpoint: cp}, true)
+		case errors.Is(err, session.ErrTruncated), errors.Is(err, session.ErrShortLog):
+			p.logger.Warn("logs are rewritten, parse them again", zap.String("session", sessionName), zap.Error(err))
+			cp = session.Checkpoint{}
+		default:
+			return err
+		}
+	}
+}
+
+// followLevels выводит бои сессии по мере того, как они заканчиваются.
+// Возвращает место после последнего боя и ошибку, из-за которой чтение остановилось.
+func (p *Parser) followLevels(
+	ctx context.Context,
+	out watchOutput,
+	sessionName string,
+	cp session.Checkpoint,
+	poll time.Duration,
+	newer func() bool,
+	total *int,
+) (session.Checkpoint, error) {
+	startedAt, err := session.StartedAt(sessionName)
+	if err != nil {
+		return cp, err
+	}
+	dir := filepath.Join(p.f.logsDir, sessionName)
+	source, err := session.OpenSource(p.f.logsDir)
+	if err != nil {
+		return cp, err
+	}
+	defer source.Close()
+
+	// game.log ждём сколько угодно: конец боя это начало следующего уровня
+	game := session.Follow(ctx, filepath.Join(dir, "game.log"), cp.Game.Offset, session.FollowOptions{
+		Poll: poll,
+		Stop: newer,
+	})
+	defer game.Close()
+
+	// combat.log игра может дописать позже, чем game.log. Бой в нём закончился только когда появилась
+	// строчка после конца боя или игра перешла в новую сессию, иначе последние сбития боя потеряются
+	combat := session.Follow(ctx, filepath.Join(dir, "combat.log"), cp.CombatOffset, session.FollowOptions{
+		Poll: poll,
+		Stop: newer,
+	})
+	defer combat.Close()
+
+	parser := session.NewParser(p.f.yourNickname, startedAt, combat, game, p.rules)
+	parser.Resume(cp)
+	alerts := make(chan *session.Alert)
+	parser.SendAlerts(alerts)
+
+	done := make(chan error, 1)
+	levelReports := make(chan *session.LevelReport)
+	go func() {
+		err := parser.Parse(ctx, p.logger, levelReports)
+		lvl := zapcore.WarnLevel
+		if errors.Is(err, io.EOF) {
+			lvl = zapcore.DebugLevel
+		}
+		p.logger.Check(lvl, "goroutine stopped").Write(zap.Error(err))
+		done <- err
+		close(levelReports)
+	}()
+
+	var recordErr error
+	for levelReports != nil {
+		var levelReport *session.LevelReport
+		select {
+		case alert := <-alerts:
+			if err := out.alert(alert); err != nil {
+				p.logger.Warn("write alert", zap.Error(err))
+			}
+			continue
+		case report, ok := <-levelReports:
+			if !ok {
+				levelReports = nil
+				continue
+			}
+			levelReport = report
+		}
+		if recordErr != nil {
+			continue
+		}
+		cp = levelReport.Checkpoint
+		p.collectWarnings(levelReport)
+
+		s := &SessionReport{
+			StartedAt:  startedAt,
+			Levels:     []*session.LevelReport{levelReport},
+			Checkpoint: cp,
+		}
+		recordErr = p.saveEvidence(source, sessionName, s)
+		if recordErr != nil {
+			continue
+		}
+		if p.ledger != nil {
+			recordErr = p.record(sessionName, s, false)
+			*total = p.ledgerTotal()
+		} else {
+			p.checkpoints.Set(sessionName, session.State{Checkpoint: cp})
+			for _, line := range levelReport.Score {
+				*total += line.Award
+			}
+		}
+
+		// в главном меню врагов нет, это не бой
+		if len(levelReport.Enemies) != 0 {
+			printLevel(out.console, levelReport, *total)
+		}
+	}
+
+	err = <-done
+	if recordErr != nil {
+		return cp, recordErr
+	}
+	return cp, err
+}
+
+// ledgerTotal это сумма очков в журнале за все запуски
+func (p *Parser) ledgerTotal() int {
+	if p.ledger == nil {
+		return 0
+	}
+	var total int
+	for _, kill := range p.ledger.Kills() {
+		total += kill.Score
+	}
+	return total
+}
+
+// printLevel выводит засчитанные сбития боя и сколько всего набралось очков
+func printLevel(out io.Writer, level *session.LevelReport, total int) {
+	var score int
+	for _, line := range level.Score {
+		score += line.Award
+	}
+	fmt.Fprintf(out, "%s %s %s: %+d, total %d\n",
+		level.StartedAt.Format(ledger.MatchFormat), level.MapName, level.GameMode, score, total)
+	for _, line := range level.Score {
+		fmt.Fprintf(out, "  %s %s [%s] %+d\n",
+			line.Time, line.Killed, level.Enemies[line.Killed].Clan, line.Award)
+	}
+}
+
+// alert выводит кого искать в бою, который только начался
+func (out watchOutput) alert(alert *session.Alert) error {
+	fmt.Fprintf(out.console, "%s %s %s: hunt %d, avoid %d, teammates %d\n",
+		alert.StartedAt.Format(ledger.MatchFormat), alert.MapName, alert.GameMode,
+		len(alert.Hunted), len(alert.Avoid), len(alert.Teammates))
+	for _, group := range []struct {
+		name    string
+		targets []session.Target
+	}{
+		{"hunt", alert.Hunted},
+		{"avoid", alert.Avoid},
+		{"team", alert.Teammates},
+	} {
+		for _, target := range group.targets {
+			fmt.Fprintf(out.console, "  %-5s %s [%s] %+d\n", group.name, target.Name, target.Clan, target.Award)
+		}
+	}
+
+	if out.alerts == nil {
+		return nil
+	}
+	data, err := json.Marshal(alert)
+	if err != nil {
+		return err
+	}
+	_, err = out.alerts.Write(append(data, '\n'))
+	return err
+}
@@ -0,0 +1,125 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/Feresey/haward/ledger"
+	"github.com/Feresey/haward/rules"
+	"github.com/Feresey/haward/session"
+	"github.com/stretchr/testify/require"
+	"go.uber.org/zap"
+)
+
+// syncBuffer это вывод watch, который тест читает пока watch пишет
+type syncBuffer struct {
+	mu  sync.Mutex
+	buf bytes.Buffer
+}
+
+func (b *syncBuffer) Write(p []byte) (int, error) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return b.buf.Write(p)
+}
+
+func (b *syncBuffer) String() string {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return b.buf.String()
+}
+
+func writeLog(t *testing.T, path, data string) {
+	file, err := os.OpenFile(path, os.O_CREATE|os.O_APPEND|os.O_WRONLY, 0o644)
+	require.NoError(t, err)
+	_, err = file.WriteString(data)
+	require.NoError(t, err)
+	require.NoError(t, file.Close())
+}
+
+func TestWatch(t *testing.T) {
+	r := require.New(t)
+	dir := t.TempDir()
+
+	rule, err := rules.NewRules(strings.NewReader("=== PLAYERS ===\n+5\nCat\n"), rules.WithResolver(rules.NewChainResolver()))
+	r.NoError(err)
+	l, err := ledger.Open(filepath.Join(dir, "kills.jsonl"))
+	r.NoError(err)
+
+	logsDir := filepath.Join(dir, "logs")
+	const sessionName = "2021.08.10 12.45.00"
+	r.NoError(os.MkdirAll(filepath.Join(logsDir, sessionName), 0o755))
+	game := filepath.Join(logsDir, sessionName, "game.log")
+	combat := filepath.Join(logsDir, sessionName, "combat.log")
+
+	checkpoints := filepath.Join(dir, "checkpoints.json")
+	p := &Parser{
+		f:           flags{logsDir: logsDir, yourNickname: "ZiroTwo", checkpointsFile: checkpoints},
+		logger:      zap.NewNop(),
+		rules:       rule,
+		ledger:      l,
+		checkpoints: session.NewCheckpoints(),
+	}
+	out, alerts := &syncBuffer{}, &syncBuffer{}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	done := make(chan error, 1)
+	go func() {
+		done <- p.watch(ctx, watchOutput{console: out, alerts: alerts}, time.Millisecond)
+	}()
+
+	// игра пишет бой
+	writeLog(t, game, `12:46:15.531         | ====== starting level: 'levels/area1/s1338_pandora_anomaly' KingOfTheHill client =====
+12:46:16.000         | client: ADD_PLAYER 0 (ZiroTwo [xIDx], 2516405) status 4 team 1 group 4778580
+12:46:16.000         | client: ADD_PLAYER 1 (Cat [NEKO], 111) status 4 team 2 group 0
+`)
+	writeLog(t, combat, `12:47:00.000  CMBT   | ======= Start gameplay =======
+12:48:00.000  CMBT   | Killed Cat	 Ship_T5|0000000111;	 killer ZiroTwo|0000002708 Weapon_X
+`)
+
+	// бой ещё идёт, но кого искать уже понятно
+	const alert = "12:46:15.531 levels/area1/s1338_pandora_anomaly KingOfTheHill: hunt 1, avoid 0, teammates 0\n" +
+		"  hunt  Cat [NEKO] +5\n"
+	r.Eventually(func() bool {
+		return out.String() == alert
+	}, time.Second, time.Millisecond)
+	r.Contains(alerts.String(), `"Hunted":[{"Name":"Cat"`)
+
+	// бой закончился, а combat.log игра ещё не дописала
+	writeLog(t, game, "12:50:00.000         | ====== starting level: 'levels/mainmenu/mainmenu' client =====\n")
+	time.Sleep(20 * time.Millisecond)
+	r.Equal(alert, out.String())
+	r.Zero(l.Len())
+
+	writeLog(t, combat, "12:49:30.000  CMBT   | Killed Cat\t Ship_T5|0000000111;\t killer ZiroTwo|0000002708 Weapon_X\n")
+	time.Sleep(20 * time.Millisecond)
+	r.Equal(alert, out.String())
+
+	// в combat.log пошёл следующий бой, значит этот там точно закончился
+	writeLog(t, combat, "12:51:00.000  CMBT   | ======= Start gameplay =======\n")
+	r.Eventually(func() bool {
+		return strings.Contains(out.String(), "total")
+	}, time.Second, time.Millisecond)
+	r.Equal(alert+"12:46:15.531 levels/area1/s1338_pandora_anomaly KingOfTheHill: +10, total 10\n"+
+		"  12:48:00.000 Cat [NEKO] +5\n  12:49:30.000 Cat [NEKO] +5\n", out.String())
+	r.Equal(2, l.Len())
+
+	// игру перезапустили, старая сессия закончилась
+	r.NoError(os.MkdirAll(filepath.Join(logsDir, "2021.08.10 13.00.00"), 0o755))
+	r.Eventually(func() bool {
+		c, err := session.LoadCheckpoints(checkpoints)
+		return err == nil && c.Get(sessionName).Done
+	}, time.Second, time.Millisecond)
+
+	cancel()
+	r.True(errors.Is(<-done, context.Canceled))
+	r.Equal(2, l.Len())
+}
@@ -0,0 +1,336 @@
+// Package evidence хранит доказательства сбитий, чтобы организаторам не нужны были скриншоты.
+//
+// На каждое засчитанное сбитие пишется отдельный JSON файл, который можно прочитать глазами:
+//
+//	evidence/
+//	  2021.08.10 20.02.16.123/
+//	    20.10.00.000_42.json
+//	    20.10.00.000_45996460.match.json
+//
+// В файле строчки combat.log вокруг сбития, строчки ADD_PLAYER охотника и цели из game.log,
+// откуда это всё взято и хэши логов. Если охотник прислал ещё и сами логи,
+// то по хэшам видно, что строчки взяты именно из них.
+//
+// Рядом на каждый бой лежат все его сбития, не только свои: по ним сверяются отчёты охотников из одного боя.
+package evidence
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/Feresey/haward/internal/atomicfile"
+	"github.com/Feresey/haward/rules"
+)
+
+// Bundle это доказательство одного сбития.
+type Bundle struct {
+	// Session это имя папки сессии: "2021.08.10 20.02.16.123"
+	Session string
+	// Match это время начала боя, как ledger.Kill.Match
+	Match string
+	// MatchID это номер боя на сервере, как ledger.Kill.MatchID
+	MatchID   string `json:",omitempty"`
+	StartedAt time.Time
+	MapName   string `json:",omitempty"`
+	GameMode  string `json:",omitempty"`
+	// Line это номер строчки сбития в combat.log
+	Line int
+
+	Hunter string
+	Killed string
+	// Clan это клан цели как в отчёте: тег, а если его нет - название
+	Clan string
+	// ClanTag и ClanName это тег и название клана цели по отдельности, если они известны
+	ClanTag  string `json:",omitempty"`
+	ClanName string `json:",omitempty"`
+	// Profile это профиль цели, если награда за профиль
+	Profile  *rules.PlayerProfile `json:",omitempty"`
+	KilledAt string
+	Score    int
+	// ShotFirst, KilledInGroup и HunterInGroup нужны для условий @allow из правил:
+	// цель первой начала стрелять, цель летала в группе, охотник летал в группе
+	ShotFirst     bool `json:",omitempty"`
+	KilledInGroup bool `json:",omitempty"`
+	HunterInGroup bool `json:",omitempty"`
+
+	// CombatLines это строчки combat.log вокруг сбития, первая из них под номером FirstLine
+	CombatLines []string
+	FirstLine   int
+	// HunterLines и KilledLines это строчки ADD_PLAYER охотника и цели из game.log
+	HunterLines []string
+	KilledLines []string
+
+	// Files это логи сессии на момент, когда писалось доказательство
+	Files []File
+	// CreatedAt когда доказательство записано
+	CreatedAt time.Time
+}
+
+// Key определяет сбитие так же, как ledger.Kill.Key.
+func (b Bundle) Key() string {
+	return b.Session + "/" + b.Match + "/" + strconv.Itoa(b.Line)
+}
+
+// KillLine возвращает строчку самого сбития.
+func (b Bundle) KillLine() (string, bool) {
+	idx := b.Line - b.FirstLine
+	if idx < 0 || idx >= len(b.CombatLines) {
+		return "", false
+	}
+	return b.CombatLines[idx], true
+}
+
+// Check проверяет, что доказательство не противоречит само себе:
+// строчка сбития есть и в ней те самые охотник и цель, и оба игрока были в бою.
+func (b Bundle) Check() error {
+	line, ok := b.KillLine()
+	if !ok {
+		return fmt.Errorf("line %d is not in the combat log excerpt", b.Line)
+	}
+	if killed, killer := parseKill(line); killed != b.Killed || killer != b.Hunter {
+		return fmt.Errorf("line %d is not a kill of %s by %s: %q", b.Line, b.Killed, b.Hunter, line)
+	}
+	if !mentions(b.HunterLines, b.Hunter) {
+		return fmt.Errorf("no ADD_PLAYER lines of the hunter %s", b.Hunter)
+	}
+	if !mentions(b.KilledLines, b.Killed) {
+		return fmt.Errorf("no ADD_PLAYER lines of %s", b.Killed)
+	}
+	return nil
+}
+
+// parseKill достаёт ники из строчки сбития
+// 21:08:54.870  CMBT   | Killed NikSvir	 Ship_Race2_S_T3_Premium|0000002708;	 killer ZiroTwo|0000002012 Weapon_Railgun
+func parseKill(line string) (killed, killer string) {
+	fields := strings.Fields(line)
+	for i := 0; i+1 < len(fields); i++ {
+		switch fields[i] {
+		case "Killed":
+			killed = fields[i+1]
+		case "killer":
+			killer = strings.SplitN(fields[i+1], "|", 2)[0]
+		}
+	}
+	return killed, killer
+}
+
+func mentions(lines []string, nickname string) bool {
+	for _, line := range lines {
+		// (ZiroTwo [xIDx], 2516405) или без клана (ZiroTwo, 2516405)
+		if strings.Contains(line, "ADD_PLAYER") &&
+			(strings.Contains(line, "("+nickname+" ") || strings.Contains(line, "("+nickname+",")) {
+			return true
+		}
+	}
+	return false
+}
+
+// File это лог сессии: сколько в нём было байт и sha256 этих байт.
+// Игра может дописать лог позже, поэтому сверять надо первые Size байт.
+type File struct {
+	Name   string
+	Size   int64
+	SHA256 string
+}
+
+// HashFile считает хэш лога.
+func HashFile(name string, r io.Reader) (File, error) {
+	hash := sha256.New()
+	size, err := io.Copy(hash, r)
+	if err != nil {
+		return File{}, fmt.Errorf("hash %s: %w", name, err)
+	}
+	return File{
+		Name:   name,
+		Size:   size,
+		SHA256: hex.EncodeToString(hash.Sum(nil)),
+	}, nil
+}
+
+// ErrMismatch значит что лог не тот, из которого взято доказательство.
+var ErrMismatch = errors.New("log does not match the evidence")
+
+// CheckLog сверяет присланный лог с хэшем из доказательства.
+func (f File) CheckLog(r io.Reader) error {
+	got, err := HashFile(f.Name, io.LimitReader(r, f.Size))
+	if err != nil {
+		return err
+	}
+	if got != f {
+		return fmt.Errorf("%w: %s: want %s (%d bytes), got %s (%d bytes)",
+			ErrMismatch, f.Name, f.SHA256, f.Size, got.SHA256, got.Size)
+	}
+	return nil
+}
+
+// Dir это папка с доказательствами.
+type Dir struct {
+	root string
+	now  func() time.Time
+}
+
+// Open открывает папку с доказательствами, если её нет, то создаёт.
+func Open(root string) (*Dir, error) {
+	if err := os.MkdirAll(root, 0o755); err != nil {
+		return nil, fmt.Errorf("create evidence dir: %w", err)
+	}
+	return &Dir{
+		root: root,
+		now:  time.Now,
+	}, nil
+}
+
+// Path это куда пишется доказательство. Двоеточия в именах файлов винда не любит.
+// Номер строчки в combat.log у каждого сбития сессии свой, даже если время начала боя неизвестно.
+func (d *Dir) Path(b Bundle) string {
+	name := strings.ReplaceAll(b.Match, ":", ".") + "_" + strconv.Itoa(b.Line) + ".json"
+	return filepath.Join(d.root, b.Session, name)
+}
+
+// Add записывает доказательство. Если оно уже есть, то остаётся первое: в нём хэши логов,
+// какими они были когда сбитие засчитали. ok == false значит что доказательство уже было.
+func (d *Dir) Add(b Bundle) (ok bool, err error) {
+	b.CreatedAt = d.now()
+	return d.add(d.Path(b), b)
+}
+
+func (d *Dir) add(path string, v interface{}) (ok bool, err error) {
+	if _, err := os.Stat(path); err == nil {
+		return false, nil
+	} else if !errors.Is(err, os.ErrNotExist) {
+		return false, err
+	}
+
+	data, err := json.MarshalIndent(v, "", "\t")
+	if err != nil {
+		return false, err
+	}
+
+	if err := os.MkdirAll(filepath.Dir(path), 0o755); err != nil {
+		return false, fmt.Errorf("create session dir: %w", err)
+	}
+	if err := atomicfile.WriteFile(path, append(data, '\n')); err != nil {
+		return false, err
+	}
+	return true, nil
+}
+
+// Load читает доказательство из файла.
+func Load(path string) (Bundle, error) {
+	var b Bundle
+	err := load(path, &b)
+	return b, err
+}
+
+func load(path string, v interface{}) error {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return fmt.Errorf("read evidence: %w", err)
+	}
+	if err := json.Unmarshal(data, v); err != nil {
+		return fmt.Errorf("decode evidence: %q: %w", path, err)
+	}
+	return nil
+}
+
+// MatchSuffix это окончание файла со всеми сбитиями боя, чтобы не путать его с доказательством сбития
+const MatchSuffix = ".match.json"
+
+// Match это все сбития одного боя, которые видно в combat.log охотника, кто бы кого ни сбил.
+// Охотники из одного боя видят одни и те же сбития, так что по ним можно проверить друг друга.
+type Match struct {
+	Session string
+	Match   string
+	// ID это номер боя на сервере, общий для всех игроков боя
+	ID        string
+	StartedAt time.Time
+	MapName   string `json:",omitempty"`
+	GameMode  string `json:",omitempty"`
+	Hunter    string
+
+	Kills []Sighting
+
+	Files     []File
+	CreatedAt time.Time
+}
+
+// Sighting это сбитие в combat.log
+type Sighting struct {
+	Line   int
+	Time   string
+	Killed string
+	Killer string
+}
+
+// MatchPath это куда пишутся сбития боя. Время начала боя может быть неизвестно,
+// тогда у всех боёв сессии оно одинаковое, поэтому в имени есть ещё и номер боя.
+func (d *Dir) MatchPath(m Match) string {
+	name := strings.ReplaceAll(m.Match, ":", ".") + "_" + m.ID + MatchSuffix
+	return filepath.Join(d.root, m.Session, name)
+}
+
+// AddMatch записывает сбития боя. Как и с доказательствами, остаётся первая запись.
+// Бой без номера не с чем сверять, его записать нельзя.
+func (d *Dir) AddMatch(m Match) (ok bool, err error) {
+	if m.ID == "" {
+		return false, fmt.Errorf("match %s/%s has no id", m.Session, m.Match)
+	}
+	m.CreatedAt = d.now()
+	return d.add(d.MatchPath(m), m)
+}
+
+// LoadMatch читает сбития боя из файла.
+func LoadMatch(path string) (Match, error) {
+	var m Match
+	err := load(path, &m)
+	return m, err
+}
+
+var (
+	// bundleName это имя доказательства: "20.10.00.000_42.json"
+	bundleName = regexp.MustCompile(`^\d\d\.\d\d\.\d\d\.\d{3}_\d+\.json$`)
+	// matchName это имя сбитий боя: "20.10.00.000_45996460.match.json"
+	matchName = regexp.MustCompile(`^\d\d\.\d\d\.\d\d\.\d{3}_[^_/]+` + regexp.QuoteMeta(MatchSuffix) + `$`)
+)
+
+// Files перечисляет доказательства и сбития боёв в папке root, пути начинаются с root.
+// Остальные файлы, например кэш кланов, если доказательства лежат рядом с ним, сюда не попадают.
+// Если папки нет, то и файлов нет.
+func Files(root string) ([]string, error) {
+	var res []string
+	err := filepath.WalkDir(root, func(path string, entry fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+		if entry.IsDir() {
+			return nil
+		}
+		rel, err := filepath.Rel(root, path)
+		if err != nil {
+			return err
+		}
+		// файлы лежат только в папках сессий
+		if filepath.Dir(filepath.Dir(rel)) != "." || filepath.Dir(rel) == "." {
+			return nil
+		}
+		if name := entry.Name(); bundleName.MatchString(name) || matchName.MatchString(name) {
+			res = append(res, path)
+		}
+		return nil
+	})
+	if errors.Is(err, os.ErrNotExist) {
+		return nil, nil
+	}
+	return res, err
+}
@@ -0,0 +1,167 @@
+package evidence
+
+import (
+	"errors"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestDir(t *testing.T) {
+	r := require.New(t)
+	root := filepath.Join(t.TempDir(), "evidence")
+	now := time.Date(2021, 8, 10, 21, 0, 0, 0, time.UTC)
+
+	d, err := Open(root)
+	r.NoError(err)
+	d.now = func() time.Time { return now }
+
+	const combatLog = "line 1\nline 2\n"
+	file, err := HashFile("combat.log", strings.NewReader(combatLog))
+	r.NoError(err)
+	r.Equal(int64(len(combatLog)), file.Size)
+
+	b := Bundle{
+		Session: "2021.08.10 20.02.16.123",
+		Match:   "20:10:00.000",
+		Line:    42,
+		Hunter:  "ZiroTwo",
+		Killed:  "Cat",
+		Score:   5,
+		CombatLines: []string{
+			"20:12:00.000  CMBT   | Damage        ZiroTwo|0000002708 ->        Cat|0000000111  91.25 (h:0.00 s:91.25) Weapon_X KINETIC",
+			"20:12:01.100  CMBT   | Killed Cat\t Ship_T5|0000000111;\t killer ZiroTwo|0000002708 Weapon_X",
+		},
+		FirstLine:   41,
+		HunterLines: []string{"20:10:01.000         | client: ADD_PLAYER 0 (ZiroTwo [xIDx], 2516405) status 4 team 1 group 4778580"},
+		KilledLines: []string{"20:10:01.000         | client: ADD_PLAYER 1 (Cat, 111) status 4 team 2"},
+		Files:       []File{file},
+	}
+	r.NoError(b.Check())
+
+	ok, err := d.Add(b)
+	r.NoError(err)
+	r.True(ok)
+	r.Equal(filepath.Join(root, "2021.08.10 20.02.16.123", "20.10.00.000_42.json"), d.Path(b))
+
+	// второй раз остаётся первое доказательство
+	b.Score = 100
+	ok, err = d.Add(b)
+	r.NoError(err)
+	r.False(ok)
+
+	got, err := Load(d.Path(b))
+	r.NoError(err)
+	r.Equal(5, got.Score)
+	r.True(now.Equal(got.CreatedAt))
+	r.Equal(b.Key(), got.Key())
+
+	// лог дописали, но начало то же
+	r.NoError(got.Files[0].CheckLog(strings.NewReader(combatLog + "line 3\n")))
+	err = got.Files[0].CheckLog(strings.NewReader("line 1\nline X\n"))
+	r.True(errors.Is(err, ErrMismatch), err)
+}
+
+func TestBundleCheck(t *testing.T) {
+	r := require.New(t)
+	b := Bundle{
+		Line:        10,
+		FirstLine:   10,
+		Hunter:      "ZiroTwo",
+		Killed:      "Cat",
+		CombatLines: []string{"20:12:01.100  CMBT   | Killed Catherine\t Ship_T5|0000000111;\t killer ZiroTwo|0000002708 Weapon_X"},
+		HunterLines: []string{"20:10:01.000         | client: ADD_PLAYER 0 (ZiroTwo [xIDx], 2516405) status 4 team 1"},
+		KilledLines: []string{"20:10:01.000         | client: ADD_PLAYER 1 (Cat [NEKO], 111) status 4 team 2"},
+	}
+	r.Error(b.Check())
+
+	b.CombatLines[0] = "20:12:01.100  CMBT   | Killed Cat\t Ship_T5|0000000111;\t killer ZiroTwo|0000002708 Weapon_X"
+	r.NoError(b.Check())
+
+	b.Line = 11
+	r.Error(b.Check())
+	b.Line = 10
+
+	b.KilledLines = nil
+	r.Error(b.Check())
+}
+
+func TestDirMatch(t *testing.T) {
+	r := require.New(t)
+	root := filepath.Join(t.TempDir(), "evidence")
+	now := time.Date(2021, 8, 10, 21, 0, 0, 0, time.UTC)
+
+	d, err := Open(root)
+	r.NoError(err)
+	d.now = func() time.Time { return now }
+
+	m := Match{
+		Session: "2021.08.10 20.02.16.123",
+		Match:   "20:10:00.000",
+		ID:      "45996460",
+		Hunter:  "ZiroTwo",
+		Kills: []Sighting{
+			{Line: 41, Time: "20:12:00.000", Killed: "Dog", Killer: "Cat"},
+			{Line: 42, Time: "20:12:01.100", Killed: "Cat", Killer: "ZiroTwo"},
+		},
+	}
+	ok, err := d.AddMatch(m)
+	r.NoError(err)
+	r.True(ok)
+	r.Equal(filepath.Join(root, "2021.08.10 20.02.16.123", "20.10.00.000_45996460"+MatchSuffix), d.MatchPath(m))
+
+	// бой уже записан
+	m.Kills = nil
+	ok, err = d.AddMatch(m)
+	r.NoError(err)
+	r.False(ok)
+
+	got, err := LoadMatch(d.MatchPath(m))
+	r.NoError(err)
+	r.Equal("45996460", got.ID)
+	r.Len(got.Kills, 2)
+	r.True(now.Equal(got.CreatedAt))
+
+	// время начала боя неизвестно, но другой бой этой сессии не теряется
+	other := Match{Session: m.Session, Match: "00:00:00.000", ID: "45996461"}
+	m.Match = "00:00:00.000"
+	ok, err = d.AddMatch(m)
+	r.NoError(err)
+	r.True(ok)
+	ok, err = d.AddMatch(other)
+	r.NoError(err)
+	r.True(ok)
+
+	_, err = d.AddMatch(Match{Session: m.Session, Match: m.Match})
+	r.Error(err)
+}
+
+func TestFiles(t *testing.T) {
+	r := require.New(t)
+	root := t.TempDir()
+
+	d, err := Open(root)
+	r.NoError(err)
+	b := Bundle{Session: "2021.08.10 20.02.16.123", Match: "20:10:00.000", Line: 42}
+	_, err = d.Add(b)
+	r.NoError(err)
+	m := Match{Session: b.Session, Match: b.Match, ID: "45996460"}
+	_, err = d.AddMatch(m)
+	r.NoError(err)
+
+	// чужие файлы рядом
+	r.NoError(os.WriteFile(filepath.Join(root, "clans.json"), []byte("[]"), 0o644))
+	r.NoError(os.WriteFile(filepath.Join(root, b.Session, "notes.json"), []byte("{}"), 0o644))
+
+	files, err := Files(root)
+	r.NoError(err)
+	r.ElementsMatch([]string{d.Path(b), d.MatchPath(m)}, files)
+
+	files, err = Files(filepath.Join(root, "missing"))
+	r.NoError(err)
+	r.Empty(files)
+}
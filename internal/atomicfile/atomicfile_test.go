@@ -0,0 +1,29 @@
+package atomicfile
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestWriteFile(t *testing.T) {
+	r := require.New(t)
+	dir := t.TempDir()
+	path := filepath.Join(dir, "file.json")
+
+	r.NoError(WriteFile(path, []byte("old")))
+	r.NoError(WriteFile(path, []byte("new")))
+
+	data, err := os.ReadFile(path)
+	r.NoError(err)
+	r.Equal("new", string(data))
+
+	// временных файлов не остаётся
+	entries, err := os.ReadDir(dir)
+	r.NoError(err)
+	r.Len(entries, 1)
+
+	r.Error(WriteFile(filepath.Join(dir, "missing", "file.json"), []byte("data")))
+}
@@ -0,0 +1,386 @@
+// Package leaderboard сводит сбития всех охотников в одну таблицу для организаторов.
+//
+// Раньше каждый присылал свой CSV, а организатор склеивал их руками в экселе.
+// Здесь то же самое: дубли выкидываются, очки пересчитываются по текущим правилам,
+// применяются ограничения, и считаются итоги по охотникам и по командам.
+//
+// Если несколько охотников были в одном бою, то их сбития сверяются по логам друг друга:
+// в combat.log видно все сбития боя, а не только свои.
+package leaderboard
+
+import (
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/Feresey/haward/ledger"
+)
+
+// Caps это ограничения, чтобы нельзя было набить очков на одном и том же игроке.
+// Ограничения считаются за день, день берётся из имени сессии. Штрафы не ограничиваются.
+type Caps struct {
+	// PerTarget сколько раз за день охотнику засчитывается одна и та же цель, 0 без ограничений
+	PerTarget int
+	// PerDay сколько очков за день может набрать охотник, 0 без ограничений
+	PerDay int
+}
+
+// Scorer пересчитывает очки за сбитие по текущим правилам. ok=false если цели в правилах больше нет.
+type Scorer func(kill ledger.Kill) (score int, ok bool)
+
+// Options это настройки сведения.
+type Options struct {
+	Caps Caps
+	// Teams map[hunter]team. Охотники без команды есть только в итогах по охотникам
+	Teams map[string]string
+	// Score если nil, то остаются очки из отчётов
+	Score Scorer
+	// Witnesses это бои, которые прислали охотники, со всеми сбитиями
+	Witnesses []Witness
+}
+
+// Witness это бой глазами одного охотника: все сбития из его combat.log, кто бы кого ни сбил.
+type Witness struct {
+	MatchID string
+	Hunter  string
+	Kills   []Sighting
+}
+
+// Sighting это сбитие в логе
+type Sighting struct {
+	Killed string
+	Killer string
+}
+
+// Почему за сбитие засчитано не всё
+const (
+	NoteDuplicate  = "duplicate"
+	NoteNotInRules = "not in the rules"
+	NoteTargetCap  = "target cap"
+	NoteDayCap     = "day cap"
+	// NoteClaimed сбитие есть у нескольких охотников, то есть кто-то прислал чужой отчёт.
+	// Такое не засчитывается никому, пусть организаторы разбираются.
+	NoteClaimed = "claimed by"
+	// NoteKilledBy в логе другого охотника из того же боя эту цель сбил кто-то другой
+	NoteKilledBy = "killed by"
+)
+
+// Entry это одно присланное сбитие и что за него в итоге засчитано.
+type Entry struct {
+	ledger.Kill
+	Team string
+	// Counted сколько очков засчитано после правил и ограничений
+	Counted int
+	// Note почему засчитано не столько, сколько в отчёте. Пусто если всё честно
+	Note string
+	// ConfirmedBy охотники из того же боя, в логах которых это сбитие тоже есть
+	ConfirmedBy []string
+	// DisputedBy охотники из того же боя, в логах которых этого сбития нет.
+	// Такое сбитие всё равно засчитывается: охотник мог выйти из боя раньше и не увидеть его.
+	// Но если в их логах цель сбил кто-то другой, то сбитие не засчитывается (NoteKilledBy)
+	DisputedBy []string
+}
+
+// Standing это строчка таблицы.
+type Standing struct {
+	Name string
+	// Hunters сколько охотников в команде, у охотника всегда 1
+	Hunters int
+	// Kills сколько сбитий засчитано
+	Kills int
+	Score int
+	// Confirmed и Disputed сколько сбитий подтвердили и не подтвердили логи других охотников
+	Confirmed int
+	Disputed  int
+}
+
+// Board это сводная таблица.
+type Board struct {
+	// Entries все сбития по охотникам, сессиям и строчкам
+	Entries []Entry
+	Hunters []Standing
+	Teams   []Standing
+}
+
+// Build сводит сбития. Сбитие определяется сессией и строчкой в combat.log:
+// в отчёте нет времени боя, поэтому ключ журнала тут не подходит.
+// Если известен номер боя, то ещё и боем, целью и временем сбития: так одно сбитие узнаётся,
+// даже если сессию переименовали. А после вылета игры в тот же бой заходят из новой сессии,
+// и сбития оттуда тоже засчитываются.
+func Build(kills []ledger.Kill, opts Options) *Board {
+	entries := make([]Entry, 0, len(kills))
+	for _, kill := range kills {
+		entries = append(entries, Entry{
+			Kill: kill,
+			Team: opts.Teams[kill.Hunter],
+		})
+	}
+	sort.SliceStable(entries, func(i, j int) bool {
+		a, b := entries[i], entries[j]
+		if a.Hunter != b.Hunter {
+			return a.Hunter < b.Hunter
+		}
+		if a.Session != b.Session {
+			return a.Session < b.Session
+		}
+		return a.Line < b.Line
+	})
+
+	// map[key][]hunter
+	claims := make(map[string][]string)
+	for _, e := range entries {
+		for _, key := range entryKeys(e.Kill) {
+			hunters := claims[key]
+			if len(hunters) == 0 || hunters[len(hunters)-1] != e.Hunter {
+				claims[key] = append(hunters, e.Hunter)
+			}
+		}
+	}
+
+	witnesses := indexWitnesses(opts.Witnesses)
+
+	var (
+		seen = make(map[string]bool)
+		// map[hunter/day/target]
+		targets = make(map[string]int)
+		// map[hunter/day]
+		days = make(map[string]int)
+	)
+	for idx := range entries {
+		e := &entries[idx]
+		keys := entryKeys(e.Kill)
+
+		if hunters := claimedBy(claims, keys); len(hunters) > 1 {
+			e.Note = NoteClaimed + " " + strings.Join(hunters, ", ")
+			continue
+		}
+		var duplicate bool
+		for _, key := range keys {
+			duplicate = duplicate || seen[key]
+			seen[key] = true
+		}
+		if duplicate {
+			e.Note = NoteDuplicate
+			continue
+		}
+
+		if e.MatchID != "" {
+			if killer := witnesses.check(e); killer != "" {
+				e.Note = NoteKilledBy + " " + killer
+				continue
+			}
+		}
+
+		score := e.Score
+		if opts.Score != nil {
+			var ok bool
+			score, ok = opts.Score(e.Kill)
+			if !ok {
+				e.Note = NoteNotInRules
+				continue
+			}
+		}
+		if score <= 0 {
+			e.Counted = score
+			continue
+		}
+
+		day := e.Hunter + "/" + sessionDay(e.Session)
+		target := day + "/" + e.Killed
+		if opts.Caps.PerTarget != 0 && targets[target] >= opts.Caps.PerTarget {
+			e.Note = NoteTargetCap
+			continue
+		}
+		targets[target]++
+
+		if opts.Caps.PerDay != 0 && days[day]+score > opts.Caps.PerDay {
+			score = opts.Caps.PerDay - days[day]
+			e.Note = NoteDayCap
+		}
+		days[day] += score
+		e.Counted = score
+	}
+
+	return &Board{
+		Entries: entries,
+		Hunters: standings(entries, func(e Entry) string { return e.Hunter }),
+		Teams:   standings(entries, func(e Entry) string { return e.Team }),
+	}
+}
+
+// claimedBy это все охотники, которые прислали сбитие с одним из ключей, по алфавиту
+func claimedBy(claims map[string][]string, keys []string) []string {
+	var res []string
+	for _, key := range keys {
+		for _, hunter := range claims[key] {
+			if !contains(res, hunter) {
+				res = append(res, hunter)
+			}
+		}
+	}
+	sort.Strings(res)
+	return res
+}
+
+func contains(list []string, s string) bool {
+	for _, v := range list {
+		if v == s {
+			return true
+		}
+	}
+	return false
+}
+
+// sightings это сбития, которые охотник видел в одном бою. map[killed/killer]сколько
+type sightings struct {
+	// left сколько ещё не подтвердили заявленные сбития
+	left map[string]int
+	// total сколько всего видно в логе
+	total map[string]int
+}
+
+// killedBy ищет, кто ещё сбивал эту цель, если hunter её в этом логе не сбивал ни разу
+func (s sightings) killedBy(killed, hunter string) string {
+	if s.total[killed+"/"+hunter] != 0 {
+		return ""
+	}
+	var killers []string
+	for kill := range s.total {
+		if strings.HasPrefix(kill, killed+"/") {
+			killers = append(killers, strings.TrimPrefix(kill, killed+"/"))
+		}
+	}
+	sort.Strings(killers)
+	return strings.Join(killers, ", ")
+}
+
+// witnesses это сбития, которые видел каждый охотник в каждом бою. map[match_id]map[hunter]
+type witnesses map[string]map[string]sightings
+
+func indexWitnesses(list []Witness) witnesses {
+	res := make(witnesses)
+	for _, w := range list {
+		hunters, ok := res[w.MatchID]
+		if !ok {
+			hunters = make(map[string]sightings)
+			res[w.MatchID] = hunters
+		}
+		// один и тот же бой могли прислать дважды
+		if _, ok := hunters[w.Hunter]; ok {
+			continue
+		}
+		seen := sightings{
+			left:  make(map[string]int),
+			total: make(map[string]int),
+		}
+		for _, kill := range w.Kills {
+			seen.left[kill.Killed+"/"+kill.Killer]++
+			seen.total[kill.Killed+"/"+kill.Killer]++
+		}
+		hunters[w.Hunter] = seen
+	}
+	return res
+}
+
+// check ищет сбитие в логах других охотников из того же боя. Каждое увиденное сбитие подтверждает одно заявленное.
+// Если в чьём-то логе эту цель сбил кто-то другой, а охотник её не сбивал вовсе, то возвращается кто её сбил:
+// иначе за одну смерть заплатили бы двоим.
+func (w witnesses) check(e *Entry) (killedBy string) {
+	hunters := w[e.MatchID]
+	names := make([]string, 0, len(hunters))
+	for hunter := range hunters {
+		if hunter != e.Hunter {
+			names = append(names, hunter)
+		}
+	}
+	sort.Strings(names)
+
+	kill := e.Killed + "/" + e.Hunter
+	for _, hunter := range names {
+		seen := hunters[hunter]
+		if seen.left[kill] > 0 {
+			seen.left[kill]--
+			e.ConfirmedBy = append(e.ConfirmedBy, hunter)
+			continue
+		}
+		e.DisputedBy = append(e.DisputedBy, hunter)
+		if killedBy == "" {
+			killedBy = seen.killedBy(e.Killed, e.Hunter)
+		}
+	}
+	return killedBy
+}
+
+// entryKeys это ключи, по которым узнаётся одно и то же сбитие: сессия и строчка в логе,
+// а если известен номер боя, то ещё бой, цель и время сбития
+func entryKeys(kill ledger.Kill) []string {
+	keys := []string{kill.Session + "/" + strconv.Itoa(kill.Line)}
+	if kill.MatchID != "" && kill.KilledAt != "" {
+		keys = append(keys, "match "+kill.MatchID+"/"+kill.Killed+"/"+kill.KilledAt)
+	}
+	return keys
+}
+
+// sessionDay это дата из имени сессии: "2021.08.10 20.02.16.123" -> "2021.08.10"
+func sessionDay(session string) string {
+	if idx := strings.IndexByte(session, ' '); idx != -1 {
+		return session[:idx]
+	}
+	return session
+}
+
+// standings считает итоги по группам, пустое имя группы пропускается.
+// Места по очкам, при равенстве по алфавиту.
+func standings(entries []Entry, group func(e Entry) string) []Standing {
+	var (
+		res   []Standing
+		index = make(map[string]int)
+		// map[group][hunter]
+		hunters = make(map[string]map[string]bool)
+	)
+	for _, e := range entries {
+		name := group(e)
+		if name == "" {
+			continue
+		}
+		idx, ok := index[name]
+		if !ok {
+			idx = len(res)
+			index[name] = idx
+			res = append(res, Standing{Name: name})
+			hunters[name] = make(map[string]bool)
+		}
+		if !hunters[name][e.Hunter] {
+			hunters[name][e.Hunter] = true
+			res[idx].Hunters++
+		}
+		if e.Counted != 0 {
+			res[idx].Kills++
+			res[idx].Score += e.Counted
+		}
+		switch {
+		case len(e.DisputedBy) != 0:
+			res[idx].Disputed++
+		case len(e.ConfirmedBy) != 0:
+			res[idx].Confirmed++
+		}
+	}
+	sort.SliceStable(res, func(i, j int) bool {
+		if res[i].Score != res[j].Score {
+			return res[i].Score > res[j].Score
+		}
+		return res[i].Name < res[j].Name
+	})
+	return res
+}
+
+// HunterEntries возвращает сбития одного охотника.
+func (b *Board) HunterEntries(hunter string) []Entry {
+	var res []Entry
+	for _, e := range b.Entries {
+		if e.Hunter == hunter {
+			res = append(res, e)
+		}
+	}
+	return res
+}
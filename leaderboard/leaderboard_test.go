@@ -0,0 +1,186 @@
+package leaderboard
+
+import (
+	"testing"
+
+	"github.com/Feresey/haward/ledger"
+	"github.com/stretchr/testify/require"
+)
+
+func TestBuild(t *testing.T) {
+	r := require.New(t)
+
+	const (
+		day1 = "2021.10.12 12.36.09.316"
+		day2 = "2021.10.13 18.00.00.000"
+	)
+	kills := []ledger.Kill{
+		{Session: day1, Line: 10, Hunter: "ZiroTwo", Killed: "Cat", Score: 5},
+		// тот же отчёт прислали ещё и журналом
+		{Session: day1, Match: "12:40:00.000", Line: 10, Hunter: "ZiroTwo", Killed: "Cat", Score: 5},
+		{Session: day1, Line: 20, Hunter: "ZiroTwo", Killed: "Cat", Score: 5},
+		{Session: day1, Line: 30, Hunter: "ZiroTwo", Killed: "Cat", Score: 5},
+		{Session: day1, Line: 40, Hunter: "ZiroTwo", Killed: "Dog", Score: 8},
+		{Session: day1, Line: 50, Hunter: "ZiroTwo", Killed: "Friend", Score: -10},
+		{Session: day1, Line: 60, Hunter: "ZiroTwo", Killed: "Gone", Score: 3},
+		// на следующий день ограничения заново
+		{Session: day2, Line: 5, Hunter: "ZiroTwo", Killed: "Cat", Score: 5},
+
+		{Session: "2021.10.12 13.00.00.000", Line: 7, Hunter: "lOpa", Killed: "Dog", Score: 8},
+		// lOpa прислал чужой отчёт
+		{Session: day1, Line: 40, Hunter: "lOpa", Killed: "Dog", Score: 8},
+		{Session: "2021.10.12 14.00.00.000", Line: 1, Hunter: "Mettle", Killed: "Cat", Score: 5},
+	}
+
+	// по новым правилам за Dog дают 6, а Gone из правил убрали
+	score := func(kill ledger.Kill) (int, bool) {
+		switch kill.Killed {
+		case "Dog":
+			return 6, true
+		case "Gone":
+			return 0, false
+		}
+		return kill.Score, true
+	}
+
+	b := Build(kills, Options{
+		Caps:  Caps{PerTarget: 2, PerDay: 15},
+		Teams: map[string]string{"ZiroTwo": "red", "lOpa": "red", "Mettle": "blue"},
+		Score: score,
+	})
+	r.Len(b.Entries, len(kills))
+
+	type result struct {
+		Line    int
+		Counted int
+		Note    string
+	}
+	var got []result
+	for _, e := range b.HunterEntries("ZiroTwo") {
+		got = append(got, result{e.Line, e.Counted, e.Note})
+	}
+	r.Equal([]result{
+		{10, 5, ""},
+		{10, 0, NoteDuplicate},
+		{20, 5, ""},
+		{30, 0, NoteTargetCap},
+		{40, 0, NoteClaimed + " ZiroTwo, lOpa"},
+		{50, -10, ""},
+		{60, 0, NoteNotInRules},
+		{5, 5, ""},
+	}, got)
+
+	r.Equal([]Standing{
+		{Name: "lOpa", Hunters: 1, Kills: 1, Score: 6},
+		{Name: "Mettle", Hunters: 1, Kills: 1, Score: 5},
+		{Name: "ZiroTwo", Hunters: 1, Kills: 4, Score: 5},
+	}, b.Hunters)
+	r.Equal([]Standing{
+		{Name: "red", Hunters: 2, Kills: 5, Score: 11},
+		{Name: "blue", Hunters: 1, Kills: 1, Score: 5},
+	}, b.Teams)
+}
+
+func TestBuildDayCap(t *testing.T) {
+	r := require.New(t)
+
+	const day = "2021.10.12 12.36.09.316"
+	kills := []ledger.Kill{
+		{Session: day, Line: 1, Hunter: "ZiroTwo", Killed: "Cat", Score: 8},
+		{Session: day, Line: 2, Hunter: "ZiroTwo", Killed: "Dog", Score: 8},
+		{Session: day, Line: 3, Hunter: "ZiroTwo", Killed: "Fox", Score: 8},
+		{Session: day, Line: 4, Hunter: "ZiroTwo", Killed: "Friend", Score: -10},
+	}
+	b := Build(kills, Options{Caps: Caps{PerDay: 10}})
+
+	var counted []int
+	for _, e := range b.Entries {
+		counted = append(counted, e.Counted)
+	}
+	r.Equal([]int{8, 2, 0, -10}, counted)
+	r.Equal(NoteDayCap, b.Entries[1].Note)
+	r.Equal(NoteDayCap, b.Entries[2].Note)
+	r.Empty(b.Teams)
+	r.Equal([]Standing{{Name: "ZiroTwo", Hunters: 1, Kills: 3, Score: 0}}, b.Hunters)
+}
+
+func TestBuildWitnesses(t *testing.T) {
+	r := require.New(t)
+
+	const (
+		session = "2021.10.12 12.36.09.316"
+		other   = "2021.10.12 19.00.00.000"
+		// игра вылетела, и охотник зашёл в тот же бой уже из новой сессии
+		reconnect = "2021.10.12 12.50.00.000"
+	)
+	kills := []ledger.Kill{
+		{Session: session, Line: 1, MatchID: "100", Hunter: "ZiroTwo", Killed: "Cat", KilledAt: "12:41:00.000", Score: 5},
+		{Session: session, Line: 2, MatchID: "100", Hunter: "ZiroTwo", Killed: "Cat", KilledAt: "12:43:00.000", Score: 5},
+		{Session: session, Line: 3, MatchID: "100", Hunter: "ZiroTwo", Killed: "Dog", KilledAt: "12:44:00.000", Score: 5},
+		// тот же бой, но из другой сессии: сессию переименовали и прислали ещё раз
+		{Session: other, Line: 1, MatchID: "100", Hunter: "ZiroTwo", Killed: "Cat", KilledAt: "12:41:00.000", Score: 5},
+		{Session: reconnect, Line: 4, MatchID: "100", Hunter: "ZiroTwo", Killed: "Cat", KilledAt: "12:52:00.000", Score: 5},
+		{Session: "2021.10.12 12.40.00.000", Line: 7, MatchID: "100", Hunter: "Mettle", Killed: "Fox", KilledAt: "12:45:00.000", Score: 5},
+		// lOpa записал себе сбитие ZiroTwo, часы у него идут по-своему
+		{Session: "2021.10.12 12.38.00.000", Line: 9, MatchID: "100", Hunter: "lOpa", Killed: "Dog", KilledAt: "12:44:03.000", Score: 5},
+		// в этом бою больше никого не было
+		{Session: session, Line: 50, MatchID: "200", Hunter: "ZiroTwo", Killed: "Cat", KilledAt: "13:00:00.000", Score: 5},
+	}
+	witnesses := []Witness{
+		{MatchID: "100", Hunter: "ZiroTwo", Kills: []Sighting{
+			{Killed: "Cat", Killer: "ZiroTwo"},
+			{Killed: "Cat", Killer: "ZiroTwo"},
+			{Killed: "Dog", Killer: "ZiroTwo"},
+			{Killed: "Fox", Killer: "Mettle"},
+		}},
+		{MatchID: "100", Hunter: "lOpa", Kills: []Sighting{
+			{Killed: "Cat", Killer: "ZiroTwo"},
+			{Killed: "Dog", Killer: "ZiroTwo"},
+		}},
+		{MatchID: "100", Hunter: "Mettle", Kills: []Sighting{
+			{Killed: "Cat", Killer: "ZiroTwo"},
+			{Killed: "Cat", Killer: "ZiroTwo"},
+			{Killed: "Fox", Killer: "Mettle"},
+		}},
+		{MatchID: "200", Hunter: "ZiroTwo", Kills: []Sighting{
+			{Killed: "Cat", Killer: "ZiroTwo"},
+		}},
+	}
+
+	b := Build(kills, Options{Witnesses: witnesses})
+
+	type result struct {
+		Line        int
+		Counted     int
+		Note        string
+		ConfirmedBy []string
+		DisputedBy  []string
+	}
+	var got []result
+	for _, e := range b.HunterEntries("ZiroTwo") {
+		got = append(got, result{e.Line, e.Counted, e.Note, e.ConfirmedBy, e.DisputedBy})
+	}
+	r.Equal([]result{
+		{Line: 1, Counted: 5, ConfirmedBy: []string{"Mettle", "lOpa"}},
+		{Line: 2, Counted: 5, ConfirmedBy: []string{"Mettle"}, DisputedBy: []string{"lOpa"}},
+		{Line: 3, Counted: 5, ConfirmedBy: []string{"lOpa"}, DisputedBy: []string{"Mettle"}},
+		{Line: 50, Counted: 5},
+		{Line: 4, Counted: 5, DisputedBy: []string{"Mettle", "lOpa"}},
+		{Line: 1, Note: NoteDuplicate},
+	}, got)
+
+	fox := b.HunterEntries("Mettle")[0]
+	r.Equal([]string{"ZiroTwo"}, fox.ConfirmedBy)
+	r.Equal([]string{"lOpa"}, fox.DisputedBy)
+
+	// в логе ZiroTwo видно, что Dog сбил он, так что lOpa за эту смерть не платят
+	dog := b.HunterEntries("lOpa")[0]
+	r.Equal(NoteKilledBy+" ZiroTwo", dog.Note)
+	r.Zero(dog.Counted)
+
+	r.Equal([]Standing{
+		{Name: "ZiroTwo", Hunters: 1, Kills: 5, Score: 25, Confirmed: 1, Disputed: 3},
+		{Name: "Mettle", Hunters: 1, Kills: 1, Score: 5, Disputed: 1},
+		{Name: "lOpa", Hunters: 1, Disputed: 1},
+	}, b.Hunters)
+}
@@ -0,0 +1,223 @@
+// Package ledger хранит все засчитанные сбития между запусками, потому что логи игра удаляет.
+//
+// Журнал это JSONL файл, одна строчка - одно сбитие. Строчки только дописываются.
+// Сбитие определяется сессией, боем и строчкой в combat.log, так что повторный разбор
+// тех же логов ничего не задвоит.
+package ledger
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"sort"
+	"strconv"
+	"time"
+
+	"github.com/Feresey/haward/rules"
+)
+
+// MatchFormat это формат Kill.Match: время начала боя, как в логах
+const MatchFormat = "15:04:05.000"
+
+// Kill это одно сбитие, за которое дали очки или штраф.
+type Kill struct {
+	// Session это имя папки сессии: "2021.08.10 20.02.16.123"
+	Session string
+	// Match это время начала боя в формате MatchFormat
+	Match string
+	// Line это номер строчки в combat.log
+	Line int
+	// MatchID это номер боя на сервере, он один у всех игроков боя. По нему сверяются отчёты разных охотников
+	MatchID string `json:",omitempty"`
+
+	Hunter string
+	Killed string
+	// Clan это клан цели как в отчёте: тег, а если его нет - название
+	Clan string
+	// ClanTag и ClanName это тег и название клана цели по отдельности, если они известны
+	ClanTag  string `json:",omitempty"`
+	ClanName string `json:",omitempty"`
+	// Profile это профиль цели, если награда за профиль
+	Profile  *rules.PlayerProfile `json:",omitempty"`
+	KilledAt string
+	Score    int
+
+	MapName  string `json:",omitempty"`
+	GameMode string `json:",omitempty"`
+	// RecordedAt когда сбитие попало в журнал
+	RecordedAt time.Time
+}
+
+// Key определяет сбитие. Два сбития с одним ключом это одно и то же сбитие.
+func (k Kill) Key() string {
+	return k.Session + "/" + k.Match + "/" + strconv.Itoa(k.Line)
+}
+
+// Ledger это журнал сбитий.
+type Ledger struct {
+	path  string
+	kills []Kill
+	// map[key]index
+	keys map[string]int
+	now  func() time.Time
+}
+
+// Open читает журнал. Если файла ещё нет, то журнал пустой.
+// Если прошлый запуск упал посреди записи, то недописанная последняя строчка выкидывается.
+func Open(path string) (*Ledger, error) {
+	l := newLedger(path)
+
+	data, err := os.ReadFile(path)
+	if err != nil {
+		if errors.Is(err, os.ErrNotExist) {
+			return l, nil
+		}
+		return nil, fmt.Errorf("read ledger: %w", err)
+	}
+
+	torn, err := l.decode(data)
+	if err != nil {
+		return nil, err
+	}
+	if torn != -1 {
+		return l, l.truncate(int64(torn))
+	}
+	return l, nil
+}
+
+// Read читает чужой журнал, например присланный охотником, и ничего в файле не чинит.
+// Недописанная последняя строчка пропускается.
+func Read(path string) ([]Kill, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("read ledger: %w", err)
+	}
+	l := newLedger(path)
+	if _, err := l.decode(data); err != nil {
+		return nil, fmt.Errorf("%q: %w", path, err)
+	}
+	return l.Kills(), nil
+}
+
+func newLedger(path string) *Ledger {
+	return &Ledger{
+		path: path,
+		keys: make(map[string]int),
+		now:  time.Now,
+	}
+}
+
+// decode разбирает строчки журнала. torn это где начинается недописанная строчка, или -1.
+func (l *Ledger) decode(data []byte) (torn int, err error) {
+	var offset int
+	for lineNum := 1; offset < len(data); lineNum++ {
+		end := bytes.IndexByte(data[offset:], '\n')
+		if end == -1 {
+			// строчка без перевода строки это недописанная запись
+			return offset, nil
+		}
+		line := data[offset : offset+end]
+		offset += end + 1
+
+		if len(bytes.TrimSpace(line)) == 0 {
+			continue
+		}
+		var kill Kill
+		if err := json.Unmarshal(line, &kill); err != nil {
+			return 0, fmt.Errorf("decode ledger: line %d: %w", lineNum, err)
+		}
+		l.add(kill)
+	}
+	return -1, nil
+}
+
+func (l *Ledger) truncate(size int64) error {
+	if err := os.Truncate(l.path, size); err != nil {
+		return fmt.Errorf("repair ledger: %w", err)
+	}
+	return nil
+}
+
+func (l *Ledger) add(kill Kill) {
+	key := kill.Key()
+	if _, ok := l.keys[key]; ok {
+		return
+	}
+	l.keys[key] = len(l.kills)
+	l.kills = append(l.kills, kill)
+}
+
+// Add дописывает в журнал сбития, которых там ещё нет. Возвращает сколько дописано.
+func (l *Ledger) Add(kills ...Kill) (int, error) {
+	var buf bytes.Buffer
+	enc := json.NewEncoder(&buf)
+
+	var added []Kill
+	seen := make(map[string]bool)
+	for _, kill := range kills {
+		key := kill.Key()
+		if _, ok := l.keys[key]; ok || seen[key] {
+			continue
+		}
+		seen[key] = true
+
+		if kill.RecordedAt.IsZero() {
+			kill.RecordedAt = l.now()
+		}
+		if err := enc.Encode(kill); err != nil {
+			return 0, fmt.Errorf("encode kill: %w", err)
+		}
+		added = append(added, kill)
+	}
+	if len(added) == 0 {
+		return 0, nil
+	}
+
+	if err := appendFile(l.path, buf.Bytes()); err != nil {
+		return 0, err
+	}
+	for _, kill := range added {
+		l.add(kill)
+	}
+	return len(added), nil
+}
+
+func appendFile(path string, data []byte) error {
+	file, err := os.OpenFile(path, os.O_CREATE|os.O_APPEND|os.O_WRONLY, 0o644)
+	if err != nil {
+		return fmt.Errorf("open ledger: %w", err)
+	}
+	if _, err := file.Write(data); err != nil {
+		file.Close()
+		return fmt.Errorf("write ledger: %w", err)
+	}
+	if err := file.Sync(); err != nil {
+		file.Close()
+		return fmt.Errorf("sync ledger: %w", err)
+	}
+	return file.Close()
+}
+
+// Kills возвращает все сбития по порядку: по сессиям, боям и строчкам.
+func (l *Ledger) Kills() []Kill {
+	res := make([]Kill, len(l.kills))
+	copy(res, l.kills)
+	sort.SliceStable(res, func(i, j int) bool {
+		a, b := res[i], res[j]
+		if a.Session != b.Session {
+			return a.Session < b.Session
+		}
+		if a.Match != b.Match {
+			return a.Match < b.Match
+		}
+		return a.Line < b.Line
+	})
+	return res
+}
+
+// Len возвращает сколько сбитий в журнале.
+func (l *Ledger) Len() int {
+	return len(l.kills)
+}
@@ -0,0 +1,115 @@
+package ledger
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestLedger(t *testing.T) {
+	r := require.New(t)
+	path := filepath.Join(t.TempDir(), "kills.jsonl")
+	now := time.Date(2021, 8, 10, 21, 0, 0, 0, time.UTC)
+
+	l, err := Open(path)
+	r.NoError(err)
+	l.now = func() time.Time { return now }
+
+	kills := []Kill{
+		{Session: "2021.08.10 20.02.16.123", Match: "20:10:00.000", Line: 42, Killed: "Cat", Score: 5},
+		{Session: "2021.08.10 20.02.16.123", Match: "20:10:00.000", Line: 7, Killed: "Dog", Score: -10},
+		// тот же бой разобрали дважды
+		{Session: "2021.08.10 20.02.16.123", Match: "20:10:00.000", Line: 42, Killed: "Cat", Score: 5},
+	}
+	added, err := l.Add(kills...)
+	r.NoError(err)
+	r.Equal(2, added)
+
+	// повторный запуск ничего не дописывает
+	added, err = l.Add(kills...)
+	r.NoError(err)
+	r.Zero(added)
+
+	l, err = Open(path)
+	r.NoError(err)
+	r.Equal(2, l.Len())
+
+	got := l.Kills()
+	r.Equal("Dog", got[0].Killed)
+	r.Equal("Cat", got[1].Killed)
+	r.True(now.Equal(got[1].RecordedAt))
+
+	added, err = l.Add(Kill{Session: "2021.08.11 19.00.00.5", Match: "19:05:00.000", Line: 1, Killed: "Fox", Score: 3})
+	r.NoError(err)
+	r.Equal(1, added)
+	r.Equal(3, l.Len())
+}
+
+func TestLedgerTornWrite(t *testing.T) {
+	r := require.New(t)
+	path := filepath.Join(t.TempDir(), "kills.jsonl")
+
+	l, err := Open(path)
+	r.NoError(err)
+	_, err = l.Add(Kill{Session: "s", Match: "m", Line: 1, Killed: "Cat"})
+	r.NoError(err)
+
+	// запуск упал посреди записи
+	file, err := os.OpenFile(path, os.O_APPEND|os.O_WRONLY, 0o644)
+	r.NoError(err)
+	_, err = file.WriteString(`{"Session":"s","Match":"m","Li`)
+	r.NoError(err)
+	r.NoError(file.Close())
+
+	l, err = Open(path)
+	r.NoError(err)
+	r.Equal(1, l.Len())
+
+	_, err = l.Add(Kill{Session: "s", Match: "m", Line: 2, Killed: "Dog"})
+	r.NoError(err)
+
+	l, err = Open(path)
+	r.NoError(err)
+	r.Equal(2, l.Len())
+
+	r.NoError(os.WriteFile(path, []byte("garbage\n"), 0o644))
+	_, err = Open(path)
+	r.Error(err)
+}
+
+func TestRead(t *testing.T) {
+	r := require.New(t)
+	path := filepath.Join(t.TempDir(), "kills.jsonl")
+
+	l, err := Open(path)
+	r.NoError(err)
+	_, err = l.Add(
+		Kill{Session: "s", Match: "m", Line: 2, Killed: "Dog"},
+		Kill{Session: "s", Match: "m", Line: 1, Killed: "Cat"},
+	)
+	r.NoError(err)
+
+	file, err := os.OpenFile(path, os.O_APPEND|os.O_WRONLY, 0o644)
+	r.NoError(err)
+	_, err = file.WriteString(`{"Session":"s","Match":"m","Li`)
+	r.NoError(err)
+	r.NoError(file.Close())
+	before, err := os.ReadFile(path)
+	r.NoError(err)
+
+	kills, err := Read(path)
+	r.NoError(err)
+	r.Len(kills, 2)
+	r.Equal("Cat", kills[0].Killed)
+
+	// присланный файл не трогается
+	after, err := os.ReadFile(path)
+	r.NoError(err)
+	r.Equal(before, after)
+
+	_, err = Read(filepath.Join(t.TempDir(), "missing.jsonl"))
+	r.Error(err)
+}
@@ -1,7 +1,6 @@
 package parse
 
 import (
-	"bufio"
 	"regexp"
 	"strings"
 	"time"
@@ -17,6 +16,15 @@ const (
 
 var killedRe = regexp.MustCompile(`^(?P<time>\S+)\s+CMBT\s+\|\s+Killed\s+(?P<killed_name>\S+)\s+\S+\|\d+\;\s+killer\s+(?P<killer_name>\S+)\|\d+\s+(?P<kill_with>\S*)\s*$`)
 
+const (
+	fieldDamageAttacker = iota + 1
+	fieldDamageTarget
+	allDamageFields
+)
+
+// 21:08:50.110  CMBT   | Damage        NikSvir|0000002708 ->        ZiroTwo|0000002012  91.25 (h:0.00 s:91.25) Weapon_Railgun_Sniper_T4_Rel KINETIC
+var damageRe = regexp.MustCompile(`^\S+\s+CMBT\s+\|\s+Damage\s+(?P<attacker>\S+)\|\d+\s+->\s+(?P<target>\S+)\|\d+\s`)
+
 type DeathRecord struct {
 	LineNum  int
 	Original string
@@ -25,18 +33,49 @@ type DeathRecord struct {
 	Killed   string
 	Killer   string
 	KillWith string
+	// ShotFirst - убитый первым начал стрелять в убийцу на этом уровне
+	ShotFirst bool
 
 	Award int
+
+	// Context это строчки лога вокруг сбития, вместе с ним самим. ContextLine номер первой из них.
+	Context     []string
+	ContextLine int
+}
+
+// ContextLines сколько строчек до и после сбития попадает в DeathRecord.Context
+const ContextLines = 5
+
+// LineScanner читает лог по строчкам, например bufio.Scanner.
+type LineScanner interface {
+	Scan() bool
+	Text() string
+}
+
+// KillObserver это LineScanner, которому интересны все сбития боя, а не только ваши.
+// Например чтобы сверить сбития с логами других игроков того же боя.
+type KillObserver interface {
+	LineScanner
+	// ObserveKill вызывается на каждое сбитие до конца боя. В record заполнены только строчка, время и ники
+	ObserveKill(record DeathRecord)
 }
 
 // ParseCombatLog достаёт из лога информацию об убийствах до определённого времени (коцна боя по идее)
 func ParseCombatLog(
-	scanner *bufio.Scanner,
+	scanner LineScanner,
 	yourNickname string,
 	until time.Time,
-	checkAward func(string) (int, bool),
+	checkAward func(DeathRecord) (int, bool),
 ) (awards, punishments []DeathRecord, err error) {
 	killerLine := "killer " + yourNickname
+	observer, _ := scanner.(KillObserver)
+
+	fire := newFireOrder(yourNickname)
+
+	// все сбития по порядку, чтобы дописывать им строчки после
+	var records []DeathRecord
+	// recent последние строчки вместе с текущей
+	recent := make([]string, 0, ContextLines+1)
 
 	for lineNum := 1; scanner.Scan(); lineNum++ {
 		line := scanner.Text()
@@ -44,7 +83,20 @@ func ParseCombatLog(
 		if checkAfter(line, until) {
 			break
 		}
-		if !strings.Contains(line, killerLine) {
+
+		for i := range records {
+			record := &records[i]
+			if lineNum-record.LineNum <= ContextLines {
+				record.Context = append(record.Context, line)
+			}
+		}
+		if len(recent) == ContextLines+1 {
+			recent = append(recent[:0], recent[1:]...)
+		}
+		recent = append(recent, line)
+
+		fire.check(line)
+		if !strings.Contains(line, killerLine) && (observer == nil || !strings.Contains(line, "Killed")) {
 			continue
 		}
 
@@ -62,27 +114,71 @@ func ParseCombatLog(
 			Killer:   fields[fieldKillerName],
 			KillWith: fields[fieldKillWith],
 		}
+		if observer != nil {
+			observer.ObserveKill(record)
+		}
 
 		if record.Killer != yourNickname {
 			continue
 		}
+		record.Context = append([]string(nil), recent...)
+		record.ContextLine = lineNum - len(recent) + 1
+		record.ShotFirst = fire.shotFirst[record.Killed]
 
-		award, ok := checkAward(record.Killed)
+		award, ok := checkAward(record)
 		if !ok {
 			continue
 		}
 
 		record.Award = award
-		if award > 0 {
+		records = append(records, record)
+	}
+
+	for _, record := range records {
+		if record.Award > 0 {
 			awards = append(awards, record)
 		} else {
 			punishments = append(punishments, record)
 		}
 	}
-
 	return awards, punishments, err
 }
 
+// fireOrder запоминает кто в кого начал стрелять первым
+type fireOrder struct {
+	yourNickname string
+	// в кого стреляли вы
+	attacked map[string]bool
+	// кто выстрелил в вас раньше чем вы в него
+	shotFirst map[string]bool
+}
+
+func newFireOrder(yourNickname string) *fireOrder {
+	return &fireOrder{
+		yourNickname: yourNickname,
+		attacked:     make(map[string]bool),
+		shotFirst:    make(map[string]bool),
+	}
+}
+
+func (f *fireOrder) check(line string) {
+	if !strings.Contains(line, "Damage") {
+		return
+	}
+	fields := damageRe.FindStringSubmatch(line)
+	if len(fields) != allDamageFields {
+		return
+	}
+
+	attacker, target := fields[fieldDamageAttacker], fields[fieldDamageTarget]
+	switch {
+	case attacker == f.yourNickname:
+		f.attacked[target] = true
+	case target == f.yourNickname && !f.attacked[attacker]:
+		f.shotFirst[attacker] = true
+	}
+}
+
 func checkAfter(line string, until time.Time) bool {
 	idx := strings.Index(line, " ")
 	if idx == -1 {
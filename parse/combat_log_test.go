@@ -3,6 +3,7 @@ package parse
 import (
 	"bufio"
 	"os"
+	"strings"
 	"testing"
 	"time"
 
@@ -33,7 +34,8 @@ func TestParseCombatLog(t *testing.T) {
 			bufio.NewScanner(file),
 			"ZiroTwo",
 			time.Date(2021, time.October, 19, 22, 0, 0, 0, time.Local),
-			func(s string) (int, bool) {
+			func(record DeathRecord) (int, bool) {
+				s := record.Killed
 				if s == "NikSvir" {
 					return 10, true
 				}
@@ -105,7 +107,8 @@ func TestParseCombatLog(t *testing.T) {
 			bufio.NewScanner(file),
 			"ZiroTwo",
 			time.Date(2021, time.October, 19, 23, 0, 0, 0, time.Local),
-			func(s string) (int, bool) {
+			func(record DeathRecord) (int, bool) {
+				s := record.Killed
 				if s == "PromptoArgentum" {
 					return 10, true
 				}
@@ -120,3 +123,98 @@ func TestParseCombatLog(t *testing.T) {
 		r.Len(punishments, 3)
 	})
 }
+
+func TestShotFirst(t *testing.T) {
+	const log = `21:08:50.110  CMBT   | Damage        NikSvir|0000002708 ->        ZiroTwo|0000002012  91.25 (h:0.00 s:91.25) Weapon_Railgun_Sniper_T4_Rel KINETIC
+21:08:51.110  CMBT   | Damage        ZiroTwo|0000002012 ->        NikSvir|0000002708  91.25 (h:0.00 s:91.25) Weapon_Railgun_Sniper_T4_Rel KINETIC
+21:08:52.110  CMBT   | Damage        ZiroTwo|0000002012 ->        HoWHoW|0000003396  91.25 (h:0.00 s:91.25) Weapon_Railgun_Sniper_T4_Rel KINETIC
+21:08:53.110  CMBT   | Damage        HoWHoW|0000003396 ->        ZiroTwo|0000002012  91.25 (h:0.00 s:91.25) Weapon_Railgun_Sniper_T4_Rel KINETIC
+21:08:54.870  CMBT   | Killed NikSvir	 Ship_Race2_S_T3_Premium|0000002708;	 killer ZiroTwo|0000002012 Weapon_Railgun_Sniper_T4_Rel
+21:08:55.870  CMBT   | Killed HoWHoW	 Ship_Race1_M_T5_Faction2|0000003396;	 killer ZiroTwo|0000002012 Weapon_Railgun_Sniper_T4_Rel
+`
+	r := require.New(t)
+
+	awards, _, err := ParseCombatLog(
+		bufio.NewScanner(strings.NewReader(log)),
+		"ZiroTwo",
+		time.Date(0, time.January, 1, 22, 0, 0, 0, time.UTC),
+		func(record DeathRecord) (int, bool) {
+			return 1, true
+		})
+	r.NoError(err)
+	r.Len(awards, 2)
+
+	r.Equal("NikSvir", awards[0].Killed)
+	r.True(awards[0].ShotFirst)
+	r.Equal("HoWHoW", awards[1].Killed)
+	r.False(awards[1].ShotFirst)
+}
+
+func TestParseCombatLogContext(t *testing.T) {
+	r := require.New(t)
+	var lines []string
+	for i := 0; i < 10; i++ {
+		lines = append(lines, "20:00:00.000  CMBT   | Damage        Cat|0000000111 ->        ZiroTwo|0000002708  1.00 (h:0.00 s:1.00) Weapon_Y KINETIC")
+	}
+	lines[1] = "20:00:01.000  CMBT   | Killed Dog\t Ship_T5|0000000222;\t killer ZiroTwo|0000002708 Weapon_X"
+	lines[7] = "20:00:07.000  CMBT   | Killed Cat\t Ship_T5|0000000111;\t killer ZiroTwo|0000002708 Weapon_X"
+
+	awards, punishments, err := ParseCombatLog(
+		bufio.NewScanner(strings.NewReader(strings.Join(lines, "\n"))),
+		"ZiroTwo",
+		time.Date(0, 1, 1, 21, 0, 0, 0, time.UTC),
+		func(record DeathRecord) (int, bool) {
+			if record.Killed == "Dog" {
+				return -10, true
+			}
+			return 5, true
+		})
+	r.NoError(err)
+	r.Len(awards, 1)
+	r.Len(punishments, 1)
+
+	// в начале лога строчек до сбития меньше
+	r.Equal(1, punishments[0].ContextLine)
+	r.Equal(lines[:7], punishments[0].Context)
+	// а в конце меньше строчек после
+	r.Equal(3, awards[0].ContextLine)
+	r.Equal(lines[2:], awards[0].Context)
+	r.True(awards[0].ShotFirst)
+}
+
+// killObserver запоминает все сбития боя
+type killObserver struct {
+	*bufio.Scanner
+	kills []DeathRecord
+}
+
+func (o *killObserver) ObserveKill(record DeathRecord) {
+	o.kills = append(o.kills, record)
+}
+
+func TestParseCombatLogObserver(t *testing.T) {
+	r := require.New(t)
+	const log = `20:00:00.000  CMBT   | Damage        Cat|0000000111 ->        ZiroTwo|0000002708  1.00 (h:0.00 s:1.00) Weapon_Y KINETIC
+20:00:01.000  CMBT   | Killed Dog	 Ship_T5|0000000222;	 killer Cat|0000000111 Weapon_X
+20:00:02.000  CMBT   | Killed Cat	 Ship_T5|0000000111;	 killer ZiroTwo|0000002708 Weapon_X
+20:30:00.000  CMBT   | Killed Fox	 Ship_T5|0000000333;	 killer Cat|0000000111 Weapon_X
+`
+	observer := &killObserver{Scanner: bufio.NewScanner(strings.NewReader(log))}
+	awards, _, err := ParseCombatLog(
+		observer,
+		"ZiroTwo",
+		time.Date(0, 1, 1, 20, 10, 0, 0, time.UTC),
+		func(record DeathRecord) (int, bool) {
+			return 5, true
+		})
+	r.NoError(err)
+	r.Len(awards, 1)
+
+	// сбития после конца боя не считаются
+	r.Len(observer.kills, 2)
+	r.Equal("Dog", observer.kills[0].Killed)
+	r.Equal("Cat", observer.kills[0].Killer)
+	r.Equal(2, observer.kills[0].LineNum)
+	r.Equal("ZiroTwo", observer.kills[1].Killer)
+	r.Empty(observer.kills[1].Context)
+}
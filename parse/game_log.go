@@ -13,8 +13,36 @@ import (
 type GameLogIter struct {
 	rd           *bufio.Reader
 	yourNickname string
+	// offset сколько байт прочитано целыми строчками
+	offset int64
+	// partial это недописанная строчка, остаток допишут позже
+	partial string
 
 	levelStarting bool
+	// строчка о старте следующего уровня читается вместе с концом предыдущего
+	nextMapName    string
+	nextGameMode   string
+	nextLevelStart time.Time
+	nextMatchID    string
+	// matchID это номер боя из строчки о подключении к серверу, она пишется до старта уровня
+	matchID string
+
+	// onRoster вызывается когда в уровень зашли новые игроки
+	onRoster      func(*GameLogLevel)
+	rosterChanged bool
+}
+
+// GameLogState это место в game.log, с которого можно продолжить чтение в следующий раз.
+type GameLogState struct {
+	// Offset с какого байта продолжать
+	Offset        int64
+	LevelStarting bool
+	NextMapName   string `json:",omitempty"`
+	NextGameMode  string `json:",omitempty"`
+	// NextLevelStart время без даты, как в логах
+	NextLevelStart time.Time
+	NextMatchID    string `json:",omitempty"`
+	MatchID        string `json:",omitempty"`
 }
 
 func NewGameLogIter(yourNickname string, r io.Reader) *GameLogIter {
@@ -24,6 +52,38 @@ func NewGameLogIter(yourNickname string, r io.Reader) *GameLogIter {
 	}
 }
 
+// OnRoster задаёт что делать, когда в уровень зашли новые игроки и всё, что игра успела записать, прочитано.
+// Так про игроков можно узнать в начале боя, а не когда он закончится.
+// fn вызывается из ScanNextLevel с уровнем, который ещё не дочитан, сохранять его нельзя.
+func (it *GameLogIter) OnRoster(fn func(lvl *GameLogLevel)) {
+	it.onRoster = fn
+}
+
+// State возвращает где итератор остановился.
+func (it *GameLogIter) State() GameLogState {
+	return GameLogState{
+		Offset:         it.offset,
+		LevelStarting:  it.levelStarting,
+		NextMapName:    it.nextMapName,
+		NextGameMode:   it.nextGameMode,
+		NextLevelStart: it.nextLevelStart,
+		NextMatchID:    it.nextMatchID,
+		MatchID:        it.matchID,
+	}
+}
+
+// Resume продолжает чтение с места, которое раньше вернул State.
+// Читать reader итератора надо уже с state.Offset.
+func (it *GameLogIter) Resume(state GameLogState) {
+	it.offset = state.Offset
+	it.levelStarting = state.LevelStarting
+	it.nextMapName = state.NextMapName
+	it.nextGameMode = state.NextGameMode
+	it.nextLevelStart = state.NextLevelStart
+	it.nextMatchID = state.NextMatchID
+	it.matchID = state.MatchID
+}
+
 type Player struct {
 	Name    string
 	ID      uint64
@@ -32,12 +92,20 @@ type Player struct {
 }
 
 type GameLogLevel struct {
-	MapName  string
+	MapName string
+	// GameMode это режим игры: KingOfTheHill, Control, ClanShip и тд
+	GameMode string
+	// MatchID это номер боя на сервере, он один и тот же у всех игроков боя. В ангаре его нет
+	MatchID  string
 	YourTeam int
 	// Players is map[team_id]Player
 	Players map[int][]Player
+	// PlayerLines это строчки ADD_PLAYER из лога, map[ник][]строчка
+	PlayerLines map[string][]string
 
-	LevelEnd time.Time
+	// LevelStart и LevelEnd это время без даты, как в логах
+	LevelStart time.Time
+	LevelEnd   time.Time
 }
 
 func (g *GameLogLevel) GetEnemies() map[string]Player {
@@ -54,17 +122,38 @@ func (g *GameLogLevel) GetEnemies() map[string]Player {
 	return res
 }
 
+// GetPlayer ищет игрока уровня по нику
+func (g *GameLogLevel) GetPlayer(name string) (Player, bool) {
+	for _, players := range g.Players {
+		for _, p := range players {
+			if p.Name == name {
+				return p, true
+			}
+		}
+	}
+	return Player{}, false
+}
+
 // 12:51:09.342         | ====== starting level: 'levels/area1/s1338_pandora_anomaly' KingOfTheHill client =====
 const (
 	startingLevelContains = `====== starting level:`
 )
 
+// 12:51:08.919         | MasterServerSession: connect to dedicated server, session 45996460, at addr 23.111.211.203|35010
+const (
+	connectServerContains = `MasterServerSession: connect to dedicated server, session `
+)
+
 func (it *GameLogIter) ScanNextLevel() (*GameLogLevel, error) {
-	var lvl GameLogLevel
+	lvl := GameLogLevel{
+		MapName:    it.nextMapName,
+		GameMode:   it.nextGameMode,
+		MatchID:    it.nextMatchID,
+		LevelStart: it.nextLevelStart,
+	}
 
 	for {
-		lineBytes, _, err := it.rd.ReadLine()
-		line := string(lineBytes)
+		line, err := it.readLine()
 		if err != nil {
 			if errors.Is(err, io.EOF) {
 				return &lvl, err
@@ -72,6 +161,11 @@ func (it *GameLogIter) ScanNextLevel() (*GameLogLevel, error) {
 			return nil, err
 		}
 
+		if matchID, ok := parseMatchID(line); ok {
+			it.matchID = matchID
+			continue
+		}
+
 		startingMessage := strings.Contains(line, startingLevelContains)
 
 		// если нет сообщения о старте уровня
@@ -79,25 +173,90 @@ func (it *GameLogIter) ScanNextLevel() (*GameLogLevel, error) {
 			if err := it.processLogLine(&lvl, line); err != nil {
 				return nil, err
 			}
+			// дальше читать нечего, пока игра не допишет
+			if it.rosterChanged && it.rd.Buffered() == 0 {
+				it.rosterChanged = false
+				if it.onRoster != nil {
+					it.onRoster(&lvl)
+				}
+			}
 			continue
 		}
 
+		mapName, gameMode := parseStartingLevel(line)
+		startedAt, err := time.Parse(timeFormat, line[:strings.Index(line, " ")])
+		if err != nil {
+			return nil, fmt.Errorf("parse start time: %q: %w", line, err)
+		}
+
 		// если логи до этой строчки принадлежали другому уровню
 		if it.levelStarting {
 			// то уровень завершился и сейчас старт нового
-			finishedAt, err := time.Parse(timeFormat, line[:strings.Index(line, " ")])
-			if err != nil {
-				return nil, fmt.Errorf("parse stop time: %q: %w", line, err)
-			}
-			lvl.LevelEnd = finishedAt
+			lvl.LevelEnd = startedAt
+			it.rosterChanged = false
+			it.nextMapName, it.nextGameMode, it.nextLevelStart = mapName, gameMode, startedAt
+			it.nextMatchID, it.matchID = it.matchID, ""
 			return &lvl, nil
 		} else { // если логи выше не принадлежали уровню
 			// то теперь началось описание уровня
 			it.levelStarting = true
+			lvl.MapName, lvl.GameMode, lvl.LevelStart = mapName, gameMode, startedAt
+			lvl.MatchID, it.matchID = it.matchID, ""
 		}
 	}
 }
 
+// readLine читает строчку без перевода строки.
+// Недописанная строчка в конце файла не читается: её ещё пишут, дочитаем в следующий раз.
+func (it *GameLogIter) readLine() (string, error) {
+	line, err := it.rd.ReadString('\n')
+	if err != nil {
+		it.partial += line
+		return "", err
+	}
+	line, it.partial = it.partial+line, ""
+	it.offset += int64(len(line))
+	return strings.TrimRight(line, "\r\n"), nil
+}
+
+// parseStartingLevel достаёт карту и режим из строчки о старте уровня
+// ====== starting level: 'levels/area1/s1338_pandora_anomaly' KingOfTheHill client =====
+func parseStartingLevel(line string) (mapName, gameMode string) {
+	line = line[strings.Index(line, startingLevelContains)+len(startingLevelContains):]
+
+	nameBegin := strings.Index(line, "'")
+	nameEnd := strings.LastIndex(line, "'")
+	if nameBegin == -1 || nameEnd <= nameBegin {
+		return "", ""
+	}
+
+	mapName = line[nameBegin+1 : nameEnd]
+
+	fields := strings.Fields(line[nameEnd+1:])
+	if len(fields) != 0 && !strings.HasPrefix(fields[0], "=") && fields[0] != "client" {
+		gameMode = fields[0]
+	}
+
+	return mapName, gameMode
+}
+
+// parseMatchID достаёт номер боя из строчки о подключении к серверу
+func parseMatchID(line string) (string, bool) {
+	idx := strings.Index(line, connectServerContains)
+	if idx == -1 {
+		return "", false
+	}
+	id := line[idx+len(connectServerContains):]
+	if end := strings.IndexByte(id, ','); end != -1 {
+		id = id[:end]
+	}
+	id = strings.TrimSpace(id)
+	if _, err := strconv.ParseUint(id, 10, 64); err != nil {
+		return "", false
+	}
+	return id, true
+}
+
 func (it *GameLogIter) processLogLine(lvl *GameLogLevel, line string) error {
 	// 17:27:50.022         | client: ADD_PLAYER 9 (BNV [CSA], 1308282) status 4 team 2 group 4778580
 	const (
@@ -133,7 +292,12 @@ func (it *GameLogIter) processLogLine(lvl *GameLogLevel, line string) error {
 		return nil
 	}
 
-	status, team, group, err := it.parsePlayerFields(strings.Fields(addPlayer[playerEnd+1:]))
+	if lvl.PlayerLines == nil {
+		lvl.PlayerLines = make(map[string][]string)
+	}
+	lvl.PlayerLines[player.Name] = append(lvl.PlayerLines[player.Name], line)
+
+	status, team, group, err := parsePlayerFields(strings.Fields(addPlayer[playerEnd+1:]))
 	if err != nil {
 		return err
 	}
@@ -152,9 +316,36 @@ func (it *GameLogIter) processLogLine(lvl *GameLogLevel, line string) error {
 		lvl.Players = make(map[int][]Player)
 	}
 	lvl.Players[team] = append(lvl.Players[team], *player)
+	it.rosterChanged = true
 	return nil
 }
 
+// ParsePlayerLine достаёт игрока из строчки ADD_PLAYER, например чтобы проверить доказательство.
+// Команда и статус игрока тут не нужны.
+func ParsePlayerLine(line string) (*Player, error) {
+	split := strings.Split(line, "ADD_PLAYER")
+	if len(split) != 2 {
+		return nil, fmt.Errorf("not an ADD_PLAYER line: %q", line)
+	}
+
+	playerStart := strings.Index(split[1], "(")
+	playerEnd := strings.LastIndex(split[1], ")")
+	if playerStart == -1 || playerEnd < playerStart {
+		return nil, fmt.Errorf("could not parse player: %q", line)
+	}
+	player, err := parsePlayer(split[1][playerStart : playerEnd+1])
+	if err != nil {
+		return nil, err
+	}
+
+	_, _, group, err := parsePlayerFields(strings.Fields(split[1][playerEnd+1:]))
+	if err != nil {
+		return nil, fmt.Errorf("could not parse player fields: %q: %w", line, err)
+	}
+	player.InGroup = group != 0
+	return player, nil
+}
+
 // (BNV [CSA], 1308282)
 func parsePlayer(player string) (*Player, error) {
 	fields := strings.Fields(strings.TrimSuffix(strings.TrimPrefix(player, "("), ")"))
@@ -195,7 +386,7 @@ func parsePlayer(player string) (*Player, error) {
 	}, nil
 }
 
-func (it *GameLogIter) parsePlayerFields(fields []string) (status, team, group int, err error) {
+func parsePlayerFields(fields []string) (status, team, group int, err error) {
 	// status 4 team 2 group 4778580
 	const (
 		addPLayerPrefix    = `ADD_PLAYER`
@@ -205,7 +396,7 @@ func (it *GameLogIter) parsePlayerFields(fields []string) (status, team, group i
 		playerGroupKey     = "group"
 	)
 
-	for i := 0; i < len(fields); i += 2 {
+	for i := 0; i+1 < len(fields); i += 2 {
 		switch fields[i] {
 		case playerStatusKey:
 			status, err = strconv.Atoi(fields[i+1])
@@ -1,10 +1,13 @@
 package parse
 
 import (
+	"bytes"
 	"errors"
 	"io"
 	"os"
+	"sort"
 	"testing"
+	"time"
 
 	"github.com/stretchr/testify/require"
 )
@@ -92,6 +95,9 @@ func TestParseLogLine(t *testing.T) {
 			want: &GameLogLevel{
 				YourTeam: 1,
 				Players:  make(map[int][]Player),
+				PlayerLines: map[string][]string{
+					"ZiroTwo": {"12:51:10.311         | client: ADD_PLAYER 0 (ZiroTwo [xIDx], 2516405) status 2 team 1 group 4778580"},
+				},
 			},
 		},
 		{
@@ -106,6 +112,9 @@ func TestParseLogLine(t *testing.T) {
 						InGroup: true,
 					}},
 				},
+				PlayerLines: map[string][]string{
+					"ZiroTwo": {"12:51:10.315         | client: ADD_PLAYER 0 (ZiroTwo [xIDx], 2516405) status 4 team 1 group 4778580"},
+				},
 			},
 		},
 		{
@@ -119,6 +128,9 @@ func TestParseLogLine(t *testing.T) {
 						InGroup: false,
 					}},
 				},
+				PlayerLines: map[string][]string{
+					"Gob": {"12:51:10.311         | client: ADD_PLAYER 1 (Gob [FlyAR], 3767922) status 4 team 2"},
+				},
 			},
 		},
 		{
@@ -132,6 +144,9 @@ func TestParseLogLine(t *testing.T) {
 						InGroup: true,
 					}},
 				},
+				PlayerLines: map[string][]string{
+					"Dimon856": {"12:51:10.312         | client: ADD_PLAYER 2 (Dimon856 [xIDx], 284392) status 4 team 1 group 4778580"},
+				},
 			},
 		},
 		{
@@ -145,6 +160,9 @@ func TestParseLogLine(t *testing.T) {
 						InGroup: true,
 					}},
 				},
+				PlayerLines: map[string][]string{
+					"Walle00one": {"12:51:10.312         | client: ADD_PLAYER 3 (Walle00one [FlyAR], 3748346) status 4 team 2 group 4778574"},
+				},
 			},
 		},
 	}
@@ -169,7 +187,45 @@ func TestParseLogLine(t *testing.T) {
 	}
 }
 
+func TestParseStartingLevel(t *testing.T) {
+	tests := []struct {
+		data     string
+		mapName  string
+		gameMode string
+	}{
+		{
+			data:    "12:45:25.995         | ====== starting level: 'levels/mainmenu/mainmenu'  ======",
+			mapName: "levels/mainmenu/mainmenu",
+		},
+		{
+			data:     "12:51:09.342         | ====== starting level: 'levels/area1/s1338_pandora_anomaly' KingOfTheHill client ======",
+			mapName:  "levels/area1/s1338_pandora_anomaly",
+			gameMode: "KingOfTheHill",
+		},
+		{
+			data:     "12:51:09.342         | ====== starting level: 'levels/dreadnoughtbattle/maps/dreadnoughtbattle_map_04' ClanShip client 50332251 ======",
+			mapName:  "levels/dreadnoughtbattle/maps/dreadnoughtbattle_map_04",
+			gameMode: "ClanShip",
+		},
+	}
+
+	for _, test := range tests {
+		tt := test
+		t.Run(tt.mapName, func(t *testing.T) {
+			mapName, gameMode := parseStartingLevel(tt.data)
+			require.Equal(t, tt.mapName, mapName)
+			require.Equal(t, tt.gameMode, gameMode)
+		})
+	}
+}
+
 func TestParseGameLog(t *testing.T) {
+	clock := func(s string) time.Time {
+		res, err := time.Parse(timeFormat, s)
+		require.NoError(t, err)
+		return res
+	}
+
 	t.Run("empty", func(t *testing.T) {
 		r := require.New(t)
 		file, err := os.Open("testdata/game_empty.log")
@@ -183,7 +239,10 @@ func TestParseGameLog(t *testing.T) {
 
 		r.True(level.LevelEnd.IsZero())
 		r.Equal(
-			&GameLogLevel{},
+			&GameLogLevel{
+				MapName:    "levels/mainmenu/mainmenu",
+				LevelStart: clock("12:45:25.995"),
+			},
 			level,
 		)
 	})
@@ -200,7 +259,11 @@ func TestParseGameLog(t *testing.T) {
 		r.NoError(err)
 		r.False(level.LevelEnd.IsZero())
 		r.Equal(
-			&GameLogLevel{LevelEnd: level.LevelEnd},
+			&GameLogLevel{
+				MapName:    "levels/mainmenu/mainmenu",
+				LevelStart: clock("12:45:25.995"),
+				LevelEnd:   clock("12:46:15.531"),
+			},
 			level,
 		)
 
@@ -209,8 +272,10 @@ func TestParseGameLog(t *testing.T) {
 		r.False(level.LevelEnd.IsZero())
 		r.Equal(
 			&GameLogLevel{
-				LevelEnd: level.LevelEnd,
-				YourTeam: 1,
+				MapName:    "levels/mainmenu/mm_federation",
+				LevelStart: clock("12:46:15.531"),
+				LevelEnd:   clock("12:51:09.342"),
+				YourTeam:   1,
 				Players: map[int][]Player{
 					1: {
 						{
@@ -241,6 +306,12 @@ func TestParseGameLog(t *testing.T) {
 						},
 					},
 				},
+				PlayerLines: map[string][]string{
+					"ZiroTwo":    {"12:51:09.248         | client: ADD_PLAYER 0 (ZiroTwo [xIDx], 2516405) status 4 team 1 group 4778580"},
+					"Gob":        {"12:51:09.248         | client: ADD_PLAYER 1 (Gob [], 3767922) status 4 team 2 group 4778574"},
+					"Dimon856":   {"12:51:09.248         | client: ADD_PLAYER 2 (Dimon856 [xIDx], 284392) status 4 team 1 group 4778580"},
+					"Walle00one": {"12:51:09.248         | client: ADD_PLAYER 3 (Walle00one [], 3748346) status 4 team 2 group 4778574"},
+				},
 			},
 			level,
 		)
@@ -249,7 +320,12 @@ func TestParseGameLog(t *testing.T) {
 		r.EqualError(err, io.EOF.Error())
 		r.True(level.LevelEnd.IsZero())
 		r.Equal(
-			&GameLogLevel{LevelEnd: level.LevelEnd},
+			&GameLogLevel{
+				MapName:    "levels/area1/s1338_pandora_anomaly",
+				GameMode:   "KingOfTheHill",
+				MatchID:    "45996460",
+				LevelStart: clock("12:51:09.342"),
+			},
 			level,
 		)
 	})
@@ -276,8 +352,15 @@ func TestParseGameLog(t *testing.T) {
 			8,
 		}
 
+		matchIDs := make(map[string]bool)
 		for count := 0; ; count++ {
 			level, err := gameLog.ScanNextLevel()
+			if level != nil && level.MatchID != "" {
+				// в ангаре номера боя нет
+				r.NotEmpty(level.GameMode, level.MapName)
+				r.False(matchIDs[level.MatchID], level.MatchID)
+				matchIDs[level.MatchID] = true
+			}
 			if errors.Is(err, io.EOF) {
 				break
 			}
@@ -286,5 +369,126 @@ func TestParseGameLog(t *testing.T) {
 			r.False(level.LevelEnd.IsZero())
 			r.Len(level.GetEnemies(), counts[count])
 		}
+		r.Len(matchIDs, 25)
 	})
 }
+
+func TestParseMatchID(t *testing.T) {
+	r := require.New(t)
+
+	id, ok := parseMatchID("12:51:08.919         | MasterServerSession: connect to dedicated server, session 45996460, at addr 23.111.211.203|35010")
+	r.True(ok)
+	r.Equal("45996460", id)
+
+	_, ok = parseMatchID("12:45:27.196         | MasterServer_RequestServerVersion")
+	r.False(ok)
+	_, ok = parseMatchID("12:51:08.919         | MasterServerSession: connect to dedicated server, session ???, at addr 23.111.211.203|35010")
+	r.False(ok)
+}
+
+func TestGameLogResume(t *testing.T) {
+	r := require.New(t)
+	data, err := os.ReadFile("testdata/game_one.log")
+	r.NoError(err)
+
+	gameLog := NewGameLogIter("ZiroTwo", bytes.NewReader(data))
+	_, err = gameLog.ScanNextLevel()
+	r.NoError(err)
+	state := gameLog.State()
+
+	want, err := gameLog.ScanNextLevel()
+	r.NoError(err)
+
+	// следующий запуск начинает с того же места
+	resumed := NewGameLogIter("ZiroTwo", bytes.NewReader(data[state.Offset:]))
+	resumed.Resume(state)
+	got, err := resumed.ScanNextLevel()
+	r.NoError(err)
+	r.Equal(want, got)
+	r.Equal(gameLog.State(), resumed.State())
+
+	// номер боя прочитан вместе с прошлым уровнем
+	state = gameLog.State()
+	r.Equal("45996460", state.NextMatchID)
+	resumed = NewGameLogIter("ZiroTwo", bytes.NewReader(data[state.Offset:]))
+	resumed.Resume(state)
+	got, err = resumed.ScanNextLevel()
+	r.ErrorIs(err, io.EOF)
+	r.Equal("45996460", got.MatchID)
+
+	// недописанная строчка в конце не засчитывается
+	for {
+		_, err := gameLog.ScanNextLevel()
+		if errors.Is(err, io.EOF) {
+			break
+		}
+		r.NoError(err)
+	}
+	r.Equal(int64(bytes.LastIndexByte(data, '\n')+1), gameLog.State().Offset)
+}
+
+// chunkReader отдаёт лог кусками, как будто игра дописывает его по мере боя
+type chunkReader struct {
+	chunks []string
+}
+
+func (c *chunkReader) Read(p []byte) (int, error) {
+	if len(c.chunks) == 0 {
+		return 0, io.EOF
+	}
+	n := copy(p, c.chunks[0])
+	c.chunks[0] = c.chunks[0][n:]
+	if c.chunks[0] == "" {
+		c.chunks = c.chunks[1:]
+	}
+	return n, nil
+}
+
+func TestGameLogOnRoster(t *testing.T) {
+	r := require.New(t)
+	rd := &chunkReader{chunks: []string{
+		`12:51:09.342         | ====== starting level: 'levels/area1/s1338_pandora_anomaly' KingOfTheHill client ======
+12:51:10.315         | client: ADD_PLAYER 0 (ZiroTwo [xIDx], 2516405) status 4 team 1 group 4778580
+12:51:10.316         | client: ADD_PLAYER 1 (Cat [NEKO], 111) status 4 team 2
+`,
+		`12:51:12.553         | client: ADD_PLAYER 2 (Dog [NEKO], 222) status 6 team 2
+12:51:13.553         | client: ADD_PLAYER 2 (Dog [NEKO], 222) status 4 team 2
+12:51:13.600         | client: got init message (and 1st snapshot). ping 3
+`,
+		`12:51:14.000         | client: got init message (and 1st snapshot). ping 3
+`,
+		`12:59:41.171         | ====== starting level: 'levels/mainmenu/mm_federation'  ======
+`,
+	}}
+
+	var rosters [][]string
+	gameLog := NewGameLogIter("ZiroTwo", rd)
+	gameLog.OnRoster(func(lvl *GameLogLevel) {
+		var names []string
+		for name := range lvl.GetEnemies() {
+			names = append(names, name)
+		}
+		sort.Strings(names)
+		rosters = append(rosters, names)
+	})
+
+	lvl, err := gameLog.ScanNextLevel()
+	r.NoError(err)
+	r.Len(lvl.GetEnemies(), 2)
+	// новые игроки только в первых двух кусках
+	r.Equal([][]string{{"Cat"}, {"Cat", "Dog"}}, rosters)
+}
+
+func TestParsePlayerLine(t *testing.T) {
+	r := require.New(t)
+	player, err := ParsePlayerLine("12:51:10.311         | client: ADD_PLAYER 1 (Gob [FlyAR], 3767922) status 4 team 2")
+	r.NoError(err)
+	r.Equal(&Player{Name: "Gob", ClanTag: "FlyAR", ID: 3767922}, player)
+
+	player, err = ParsePlayerLine("17:27:50.022         | client: ADD_PLAYER 9 (BNV [CSA], 1308282) status 4 team 2 group 4778580")
+	r.NoError(err)
+	r.Equal(&Player{Name: "BNV", ClanTag: "CSA", ID: 1308282, InGroup: true}, player)
+
+	_, err = ParsePlayerLine("12:51:10.311         | client: got init message")
+	r.Error(err)
+}
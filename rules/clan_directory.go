@@ -0,0 +1,117 @@
+package rules
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"sort"
+	"sync"
+
+	"github.com/Feresey/haward/internal/atomicfile"
+)
+
+// ClanDirectory связывает теги кланов с полными названиями.
+// В логах у игроков есть только тег, а в правилах корпорацию могут записать только названием.
+type ClanDirectory struct {
+	mu sync.RWMutex
+	// map[tag]name
+	names map[string]string
+	// map[name]tag
+	tags map[string]string
+}
+
+func NewClanDirectory() *ClanDirectory {
+	return &ClanDirectory{
+		names: make(map[string]string),
+		tags:  make(map[string]string),
+	}
+}
+
+// LoadClanDirectory читает справочник из файла. Если файла ещё нет, то справочник пустой.
+func LoadClanDirectory(path string) (*ClanDirectory, error) {
+	d := NewClanDirectory()
+
+	data, err := os.ReadFile(path)
+	if err != nil {
+		if errors.Is(err, os.ErrNotExist) {
+			return d, nil
+		}
+		return nil, fmt.Errorf("read clan directory: %w", err)
+	}
+
+	var clans []Clan
+	if err := json.Unmarshal(data, &clans); err != nil {
+		return nil, fmt.Errorf("decode clan directory: %q: %w", path, err)
+	}
+	for _, clan := range clans {
+		d.Add(clan)
+	}
+
+	return d, nil
+}
+
+// Save записывает справочник в файл.
+func (d *ClanDirectory) Save(path string) error {
+	data, err := json.MarshalIndent(d, "", "\t")
+	if err != nil {
+		return err
+	}
+
+	return atomicfile.WriteFile(path, data)
+}
+
+// Add запоминает связь тега и названия. Возвращает true если справочник изменился.
+func (d *ClanDirectory) Add(clan Clan) bool {
+	if clan.Name == "" || clan.Tag == "" {
+		return false
+	}
+
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	if d.names[clan.Tag] == clan.Name && d.tags[clan.Name] == clan.Tag {
+		return false
+	}
+
+	// тег мог перейти к другой корпорации, а корпорация могла сменить тег
+	if oldName, ok := d.names[clan.Tag]; ok {
+		delete(d.tags, oldName)
+	}
+	if oldTag, ok := d.tags[clan.Name]; ok {
+		delete(d.names, oldTag)
+	}
+
+	d.names[clan.Tag] = clan.Name
+	d.tags[clan.Name] = clan.Tag
+	return true
+}
+
+// Name возвращает полное название клана по тегу.
+func (d *ClanDirectory) Name(tag string) (string, bool) {
+	d.mu.RLock()
+	defer d.mu.RUnlock()
+	name, ok := d.names[tag]
+	return name, ok
+}
+
+// Tag возвращает тег клана по полному названию.
+func (d *ClanDirectory) Tag(name string) (string, bool) {
+	d.mu.RLock()
+	defer d.mu.RUnlock()
+	tag, ok := d.tags[name]
+	return tag, ok
+}
+
+func (d *ClanDirectory) MarshalJSON() ([]byte, error) {
+	d.mu.RLock()
+	defer d.mu.RUnlock()
+
+	clans := make([]Clan, 0, len(d.names))
+	for tag, name := range d.names {
+		clans = append(clans, Clan{Name: name, Tag: tag})
+	}
+	sort.Slice(clans, func(i, j int) bool {
+		return clans[i].Tag < clans[j].Tag
+	})
+	return json.Marshal(clans)
+}
@@ -0,0 +1,66 @@
+package rules
+
+import (
+	"context"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/Feresey/haward/parse"
+	"github.com/stretchr/testify/require"
+)
+
+func TestClanDirectory(t *testing.T) {
+	r := require.New(t)
+
+	d := NewClanDirectory()
+	r.True(d.Add(Clan{Name: "Nekopara", Tag: "NEKO"}))
+	r.False(d.Add(Clan{Name: "Nekopara", Tag: "NEKO"}))
+	r.False(d.Add(Clan{Name: "Nekopara"}))
+
+	name, ok := d.Name("NEKO")
+	r.True(ok)
+	r.Equal("Nekopara", name)
+
+	// корпорация сменила тег
+	r.True(d.Add(Clan{Name: "Nekopara", Tag: "NYA"}))
+	_, ok = d.Name("NEKO")
+	r.False(ok)
+	tag, ok := d.Tag("Nekopara")
+	r.True(ok)
+	r.Equal("NYA", tag)
+
+	path := filepath.Join(t.TempDir(), "clans.json")
+	r.NoError(d.Save(path))
+
+	loaded, err := LoadClanDirectory(path)
+	r.NoError(err)
+	r.Equal(d, loaded)
+
+	empty, err := LoadClanDirectory(filepath.Join(t.TempDir(), "missing.json"))
+	r.NoError(err)
+	r.Equal(NewClanDirectory(), empty)
+}
+
+func TestClanAwardByDirectory(t *testing.T) {
+	const rulesTxt = `
+=== CORPORATIONS ===
++10
+Nekopara
+Fright Night [FINS]
+`
+	d := NewClanDirectory()
+	d.Add(Clan{Name: "Nekopara", Tag: "NEKO"})
+	d.Add(Clan{Name: "Fright Night", Tag: "FINS"})
+
+	rules, err := NewRules(strings.NewReader(rulesTxt), WithClanDirectory(d))
+	require.NoError(t, err)
+
+	award, ok := rules.GetAward(context.Background(), Kill{Target: parse.Player{Name: "Cat", ClanTag: "NEKO"}})
+	require.True(t, ok)
+	require.Equal(t, 10, award)
+
+	award, ok = rules.GetAward(context.Background(), Kill{Target: parse.Player{Name: "Fish", ClanTag: "FINS"}})
+	require.True(t, ok)
+	require.Equal(t, 10, award)
+}
@@ -0,0 +1,171 @@
+package rules
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/Feresey/haward/internal/atomicfile"
+)
+
+// ClanHistory помнит в каких кланах игроки состояли в разное время.
+// Игроки различаются по ID, потому что ник можно сменить.
+type ClanHistory struct {
+	mu sync.RWMutex
+	// map[player_id]периоды по возрастанию времени, не пересекаются
+	players map[uint64][]Membership
+}
+
+// Membership это период, в течение которого игрока видели в одном клане.
+// Пустой клан значит что игрок ни в каком клане не состоял.
+type Membership struct {
+	Clan Clan
+	From time.Time
+	To   time.Time
+}
+
+func NewClanHistory() *ClanHistory {
+	return &ClanHistory{
+		players: make(map[uint64][]Membership),
+	}
+}
+
+// LoadClanHistory читает историю из файла. Если файла ещё нет, то история пустая.
+func LoadClanHistory(path string) (*ClanHistory, error) {
+	h := NewClanHistory()
+
+	data, err := os.ReadFile(path)
+	if err != nil {
+		if errors.Is(err, os.ErrNotExist) {
+			return h, nil
+		}
+		return nil, fmt.Errorf("read clan history: %w", err)
+	}
+
+	if err := json.Unmarshal(data, &h.players); err != nil {
+		return nil, fmt.Errorf("decode clan history: %q: %w", path, err)
+	}
+	for id, ms := range h.players {
+		sort.Slice(ms, func(i, j int) bool {
+			return ms[i].From.Before(ms[j].From)
+		})
+		h.players[id] = ms
+	}
+
+	return h, nil
+}
+
+// Save записывает историю в файл.
+func (h *ClanHistory) Save(path string) error {
+	data, err := json.MarshalIndent(h, "", "\t")
+	if err != nil {
+		return err
+	}
+	return atomicfile.WriteFile(path, data)
+}
+
+func (h *ClanHistory) MarshalJSON() ([]byte, error) {
+	h.mu.RLock()
+	defer h.mu.RUnlock()
+	return json.Marshal(h.players)
+}
+
+// Observe запоминает что в момент at игрок был в клане clan.
+// Соседние наблюдения одного и того же клана склеиваются в один период.
+func (h *ClanHistory) Observe(id uint64, at time.Time, clan Clan) {
+	if id == 0 || at.IsZero() {
+		return
+	}
+
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	ms := h.players[id]
+	// первый период, который начался позже at
+	idx := sort.Search(len(ms), func(i int) bool {
+		return ms[i].From.After(at)
+	})
+
+	if idx > 0 {
+		prev := &ms[idx-1]
+		if sameClan(prev.Clan, clan) {
+			prev.Clan = mergeClan(prev.Clan, clan)
+			if at.After(prev.To) {
+				prev.To = at
+			}
+			// период дотянулся до следующего с тем же кланом
+			if idx < len(ms) && sameClan(ms[idx].Clan, clan) {
+				prev.To = ms[idx].To
+				ms = append(ms[:idx], ms[idx+1:]...)
+			}
+			h.players[id] = ms
+			return
+		}
+
+		// игрок ненадолго сменил клан посреди известного периода
+		if at.Before(prev.To) {
+			tail := Membership{Clan: prev.Clan, From: at.Add(time.Nanosecond), To: prev.To}
+			prev.To = at
+			ms = insertMembership(ms, idx, tail)
+		}
+	}
+
+	if idx < len(ms) && sameClan(ms[idx].Clan, clan) {
+		ms[idx].Clan = mergeClan(ms[idx].Clan, clan)
+		ms[idx].From = at
+		h.players[id] = ms
+		return
+	}
+
+	h.players[id] = insertMembership(ms, idx, Membership{Clan: clan, From: at, To: at})
+}
+
+// At возвращает клан игрока в момент at: последний, в котором его видели до этого момента.
+// Если до этого момента игрока не видели, то ok == false.
+func (h *ClanHistory) At(id uint64, at time.Time) (clan Clan, ok bool) {
+	h.mu.RLock()
+	defer h.mu.RUnlock()
+
+	ms := h.players[id]
+	idx := sort.Search(len(ms), func(i int) bool {
+		return ms[i].From.After(at)
+	})
+	if idx == 0 {
+		return Clan{}, false
+	}
+	return ms[idx-1].Clan, true
+}
+
+// Memberships возвращает всю известную историю игрока.
+func (h *ClanHistory) Memberships(id uint64) []Membership {
+	h.mu.RLock()
+	defer h.mu.RUnlock()
+	return append([]Membership(nil), h.players[id]...)
+}
+
+func insertMembership(ms []Membership, idx int, m Membership) []Membership {
+	ms = append(ms, Membership{})
+	copy(ms[idx+1:], ms[idx:])
+	ms[idx] = m
+	return ms
+}
+
+// sameClan сравнивает кланы по тегу, а если тегов нет, то по названию.
+// В логах у клана есть только тег, а API знает и название.
+func sameClan(a, b Clan) bool {
+	if a.Tag != "" || b.Tag != "" {
+		return a.Tag == b.Tag
+	}
+	return a.Name == b.Name
+}
+
+func mergeClan(a, b Clan) Clan {
+	if a.Name == "" {
+		a.Name = b.Name
+	}
+	return a
+}
@@ -0,0 +1,180 @@
+package rules
+
+import (
+	"context"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/Feresey/haward/parse"
+	"github.com/stretchr/testify/require"
+)
+
+func TestClanHistory(t *testing.T) {
+	r := require.New(t)
+
+	day := func(d int) time.Time {
+		return time.Date(2021, time.October, d, 12, 0, 0, 0, time.UTC)
+	}
+
+	neko := Clan{Tag: "NEKO"}
+	fins := Clan{Name: "Fright Night", Tag: "FINS"}
+
+	h := NewClanHistory()
+	h.Observe(1, day(10), neko)
+	h.Observe(1, day(12), neko)
+	h.Observe(1, day(15), fins)
+	h.Observe(1, day(11), Clan{Name: "Nekopara", Tag: "NEKO"})
+	// бот или время неизвестно
+	h.Observe(0, day(10), neko)
+	h.Observe(2, time.Time{}, neko)
+
+	r.Equal([]Membership{
+		{Clan: Clan{Name: "Nekopara", Tag: "NEKO"}, From: day(10), To: day(12)},
+		{Clan: fins, From: day(15), To: day(15)},
+	}, h.Memberships(1))
+	r.Empty(h.Memberships(0))
+	r.Empty(h.Memberships(2))
+
+	_, ok := h.At(1, day(9))
+	r.False(ok)
+
+	clan, ok := h.At(1, day(13))
+	r.True(ok)
+	r.Equal("NEKO", clan.Tag)
+
+	clan, ok = h.At(1, day(20))
+	r.True(ok)
+	r.Equal("FINS", clan.Tag)
+
+	// вышел из клана посреди известного периода
+	h.Observe(1, day(11), Clan{})
+	clan, ok = h.At(1, day(11))
+	r.True(ok)
+	r.True(clan.IsZero())
+	clan, _ = h.At(1, day(11).Add(time.Hour))
+	r.Equal("NEKO", clan.Tag)
+	clan, _ = h.At(1, day(10))
+	r.Equal("NEKO", clan.Tag)
+
+	path := filepath.Join(t.TempDir(), "history.json")
+	r.NoError(h.Save(path))
+
+	loaded, err := LoadClanHistory(path)
+	r.NoError(err)
+	r.Equal(h.Memberships(1), loaded.Memberships(1))
+
+	empty, err := LoadClanHistory(filepath.Join(t.TempDir(), "missing.json"))
+	r.NoError(err)
+	r.Empty(empty.Memberships(1))
+}
+
+func TestRulesClanAtMatchTime(t *testing.T) {
+	r := require.New(t)
+
+	const rulesTxt = `
+=== CORPORATIONS ===
++10
+Nekopara
+`
+	// сейчас Cat уже в другом клане
+	static, err := NewStaticResolver(strings.NewReader("Cat,FINS,Fright Night\n"))
+	r.NoError(err)
+
+	rules, err := NewRules(strings.NewReader(rulesTxt), WithResolver(static))
+	r.NoError(err)
+	rules.directory.Add(Clan{Name: "Nekopara", Tag: "NEKO"})
+
+	match := time.Date(2021, time.October, 10, 12, 0, 0, 0, time.UTC)
+	cat := parse.Player{Name: "Cat", ID: 42}
+	hunter := parse.Player{Name: "ZiroTwo", ID: 1, ClanTag: "xIDx"}
+
+	// в бою у Cat был тег NEKO
+	rules.ObserveClans([]parse.Player{{Name: "Cat", ID: 42, ClanTag: "NEKO"}, hunter}, match)
+
+	award, ok := rules.GetAward(context.Background(), Kill{Target: cat, Hunter: hunter, At: match})
+	r.True(ok)
+	r.Equal(10, award)
+
+	// без времени боя знаем только текущий клан
+	_, ok = rules.GetAward(context.Background(), Kill{Target: cat, Hunter: hunter})
+	r.False(ok)
+
+	// соклановцев не считаем
+	later := match.Add(time.Hour)
+	rules.ObserveClans([]parse.Player{{Name: "Cat", ID: 42, ClanTag: "xIDx"}}, later)
+	r.True(rules.Clanmates(hunter, cat, later))
+	r.False(rules.Clanmates(hunter, cat, match))
+	_, ok = rules.GetAward(context.Background(), Kill{Target: cat, Hunter: hunter, At: later})
+	r.False(ok)
+
+	// без тега в бою клан неизвестен, спрашивается текущий, а FINS в правилах нет
+	rules.ObserveClans([]parse.Player{{Name: "Cat", ID: 42}}, later.Add(time.Hour))
+	_, ok = rules.GetAward(context.Background(), Kill{Target: cat, Hunter: hunter, At: later.Add(time.Hour)})
+	r.False(ok)
+}
+
+func TestRulesClanWithoutTag(t *testing.T) {
+	r := require.New(t)
+
+	const rulesTxt = `
+=== CORPORATIONS ===
++10
+Nekopara
+`
+	// у корпорации нет тега, в логах её игроки без тега
+	static, err := NewStaticResolver(strings.NewReader("Cat,,Nekopara\n"))
+	r.NoError(err)
+
+	rules, err := NewRules(strings.NewReader(rulesTxt), WithResolver(static))
+	r.NoError(err)
+
+	match := time.Date(2021, time.October, 10, 12, 0, 0, 0, time.UTC)
+	cat := parse.Player{Name: "Cat", ID: 42}
+	rules.ObserveClans([]parse.Player{cat}, match)
+
+	bounty, ok := rules.GetBounty(context.Background(), cat, match)
+	r.True(ok)
+	r.Equal(Bounty{Score: 10, Corporation: true}, bounty)
+}
+
+func TestRulesClanmates(t *testing.T) {
+	r := require.New(t)
+
+	const rulesTxt = `
+=== PLAYERS ===
+-20
+@allow none
+Traitor
+===
++15
+Wanted
+
+=== CORPORATIONS ===
++10
+[xIDx]
+`
+	rules, err := NewRules(strings.NewReader(rulesTxt))
+	r.NoError(err)
+
+	match := time.Date(2021, time.October, 10, 12, 0, 0, 0, time.UTC)
+	hunter := parse.Player{Name: "ZiroTwo", ID: 1, ClanTag: "xIDx"}
+	cat := parse.Player{Name: "Cat", ID: 2, ClanTag: "xIDx"}
+	traitor := parse.Player{Name: "Traitor", ID: 3, ClanTag: "xIDx"}
+	wanted := parse.Player{Name: "Wanted", ID: 4, ClanTag: "xIDx"}
+	rules.ObserveClans([]parse.Player{hunter, cat, traitor, wanted}, match)
+
+	// за свою корпорацию не платят
+	_, ok := rules.GetAward(context.Background(), Kill{Target: cat, Hunter: hunter, At: match})
+	r.False(ok)
+
+	// а штраф и награда за ник действуют и на соклановцев
+	award, ok := rules.GetAward(context.Background(), Kill{Target: traitor, Hunter: hunter, At: match})
+	r.True(ok)
+	r.Equal(-20, award)
+
+	award, ok = rules.GetAward(context.Background(), Kill{Target: wanted, Hunter: hunter, At: match})
+	r.True(ok)
+	r.Equal(15, award)
+}
@@ -1,10 +1,20 @@
 package rules
 
 import (
+	"context"
 	"encoding/json"
+	"errors"
 	"fmt"
+	"io"
 	"net/http"
+	"net/url"
+	"os"
+	"sort"
+	"strings"
+	"sync"
+	"time"
 
+	"github.com/Feresey/haward/internal/atomicfile"
 	"go.uber.org/ratelimit"
 )
 
@@ -12,82 +22,706 @@ type Clan struct {
 	Name, Tag string
 }
 
+// ClanResolver узнаёт в каком клане состоит игрок.
+type ClanResolver interface {
+	GetPlayerClan(ctx context.Context, nickname string) (*Clan, error)
+}
+
+var (
+	// ErrUnknownPlayer значит что резолвер ничего не знает об игроке.
+	ErrUnknownPlayer = errors.New("unknown player")
+	// ErrNoClan значит что игрок известен, но ни в какой корпорации не состоит.
+	ErrNoClan = errors.New("player has no clan")
+)
+
+// IsZero говорит что клана нет.
+func (c Clan) IsZero() bool {
+	return c.Name == "" && c.Tag == ""
+}
+
+// ChainResolver спрашивает резолверы по очереди, пока кто-нибудь не ответит.
+type ChainResolver []ClanResolver
+
+func NewChainResolver(resolvers ...ClanResolver) ChainResolver {
+	return ChainResolver(resolvers)
+}
+
+func (c ChainResolver) GetPlayerClan(ctx context.Context, nickname string) (*Clan, error) {
+	err := fmt.Errorf("%w: %s", ErrUnknownPlayer, nickname)
+	for _, resolver := range c {
+		var clan *Clan
+		clan, err = resolver.GetPlayerClan(ctx, nickname)
+		if err == nil {
+			return clan, nil
+		}
+		// "без клана" это тоже ответ. А если отменили, то остальных спрашивать тоже незачем
+		if errors.Is(err, ErrNoClan) || ctx.Err() != nil {
+			return nil, err
+		}
+	}
+	return nil, err
+}
+
+// GetPlayerProfile спрашивает профиль у тех резолверов, которые умеют его узнавать.
+func (c ChainResolver) GetPlayerProfile(ctx context.Context, nickname string) (*PlayerProfile, error) {
+	err := fmt.Errorf("%w: %s", ErrUnknownPlayer, nickname)
+	for _, resolver := range c {
+		profiles, ok := resolver.(ProfileResolver)
+		if !ok {
+			continue
+		}
+		var profile *PlayerProfile
+		profile, err = profiles.GetPlayerProfile(ctx, nickname)
+		if err == nil || ctx.Err() != nil {
+			return profile, err
+		}
+	}
+	return nil, err
+}
+
+// ClanResult это ответ резолвера про одного игрока.
+type ClanResult struct {
+	Clan *Clan
+	Err  error
+}
+
+// ResolveClans узнаёт кланы игроков в workers потоков. Повторы в nicknames спрашиваются один раз.
+// Если контекст отменили, то для тех, кого не успели спросить, будет ошибка контекста.
+func ResolveClans(ctx context.Context, resolver ClanResolver, nicknames []string, workers int) map[string]ClanResult {
+	if workers < 1 {
+		workers = 1
+	}
+
+	var (
+		mu   sync.Mutex
+		wg   sync.WaitGroup
+		res  = make(map[string]ClanResult, len(nicknames))
+		jobs = make(chan string)
+	)
+	for i := 0; i < workers && i < len(nicknames); i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for nickname := range jobs {
+				clan, err := resolver.GetPlayerClan(ctx, nickname)
+				mu.Lock()
+				res[nickname] = ClanResult{Clan: clan, Err: err}
+				mu.Unlock()
+			}
+		}()
+	}
+
+	seen := make(map[string]bool, len(nicknames))
+feed:
+	for _, nickname := range nicknames {
+		if seen[nickname] {
+			continue
+		}
+		// select выбирает случайно, а отменённый контекст должен побеждать
+		if ctx.Err() != nil {
+			break
+		}
+		select {
+		case jobs <- nickname:
+			seen[nickname] = true
+		case <-ctx.Done():
+			break feed
+		}
+	}
+	close(jobs)
+	wg.Wait()
+
+	for _, nickname := range nicknames {
+		if _, ok := res[nickname]; !ok {
+			res[nickname] = ClanResult{Err: ctx.Err()}
+		}
+	}
+	return res
+}
+
+// StatusError значит что API ответило не 200.
+type StatusError struct {
+	StatusCode int
+	Status     string
+}
+
+func (e *StatusError) Error() string {
+	return "unexpected status: " + e.Status
+}
+
+// Temporary говорит есть ли смысл повторить запрос.
+func (e *StatusError) Temporary() bool {
+	return e.StatusCode == http.StatusTooManyRequests || e.StatusCode >= http.StatusInternalServerError
+}
+
+// APIError это ошибка, которую API вернуло в теле ответа.
+type APIError struct {
+	Code int
+	Text string
+}
+
+func (e *APIError) Error() string {
+	return fmt.Sprintf("api error %d: %s", e.Code, e.Text)
+}
+
+const (
+	// DefaultAPIURL это публичное API Star Conflict
+	DefaultAPIURL = "http://gmt.star-conflict.com/pubapi/v1"
+	// DefaultCacheTTL столько живёт клан игрока в кэше, если не сказано иное
+	DefaultCacheTTL = 3 * 24 * time.Hour
+	// DefaultErrorTTL столько помним, что про игрока спросить не удалось
+	DefaultErrorTTL = 15 * time.Minute
+	// DefaultTimeout столько ждём ответа на один запрос к API
+	DefaultTimeout = 10 * time.Second
+	// DefaultRetries столько раз повторяем запрос, если API не ответило
+	DefaultRetries = 3
+	// DefaultBackoff пауза перед первым повтором, дальше она удваивается
+	DefaultBackoff = 500 * time.Millisecond
+	// DefaultRate столько запросов в секунду разрешаем себе слать в API
+	DefaultRate = 30
+	// DefaultBurst столько запросов можно отправить разом после простоя
+	DefaultBurst = 10
+	// DefaultWorkers столько игроков узнаём одновременно.
+	// Запросы к API всё равно идут через общий ограничитель.
+	DefaultWorkers = 8
+
+	maxBackoff = 10 * time.Second
+)
+
+// PlayerClanResolver узнаёт кланы игроков через API игры и запоминает ответы.
+// Можно звать из нескольких горутин: одинаковые запросы склеиваются в один.
 type PlayerClanResolver struct {
+	mu sync.Mutex
 	// map[player_name]clan
-	cache map[string]Clan
+	cache map[string]cacheEntry
+	// запросы, которые ещё идут. map[player_name]call
+	inflight map[string]*clanCall
+	ttl      time.Duration
+	errorTTL time.Duration
+	now      func() time.Time
+
+	baseURL string
+	rl      ratelimit.Limiter
+	cli     *http.Client
+	timeout time.Duration
+	retries int
+	backoff time.Duration
+	sleep   func(context.Context, time.Duration) error
 
-	rl  ratelimit.Limiter
-	cli *http.Client
+	statsMu sync.Mutex
+	stats   APIStats
+}
+
+// APIStats это сколько раз резолвер ходил в API и сколько раз обошёлся кэшем.
+type APIStats struct {
+	// Requests запросов к API, вместе с повторами
+	Requests int
+	// Failed из них закончились ошибкой. "Игрок не найден" ошибкой не считается.
+	Failed      int
+	CacheHits   int
+	CacheMisses int
+	// Latency суммарное время ответа API, без ожидания в ограничителе
+	Latency    time.Duration
+	MaxLatency time.Duration
+}
+
+// HitRatio доля ответов из кэша.
+func (s APIStats) HitRatio() float64 {
+	total := s.CacheHits + s.CacheMisses
+	if total == 0 {
+		return 0
+	}
+	return float64(s.CacheHits) / float64(total)
+}
+
+// AvgLatency среднее время ответа API.
+func (s APIStats) AvgLatency() time.Duration {
+	if s.Requests == 0 {
+		return 0
+	}
+	return s.Latency / time.Duration(s.Requests)
+}
+
+type cacheEntry struct {
+	Clan Clan
+	// Err это ошибка, с которой не удалось узнать клан. Такие записи живут недолго.
+	Err string `json:",omitempty"`
+	// NotFound значит что API не знает такого игрока
+	NotFound bool `json:",omitempty"`
+	// Profile всё, что API рассказало об игроке. В старых записях его нет.
+	Profile   *PlayerProfile `json:",omitempty"`
+	FetchedAt time.Time
+	ExpiresAt time.Time
+}
+
+func (e cacheEntry) failed() bool {
+	return e.Err != "" || e.NotFound
+}
+
+// result превращает запись кэша в ответ резолвера
+func (e cacheEntry) result(nickname string) (*Clan, error) {
+	switch {
+	case e.NotFound:
+		return nil, fmt.Errorf("%w: %s", ErrUnknownPlayer, nickname)
+	case e.Err != "":
+		return nil, fmt.Errorf("get player clan from api: %s, %s (cached)", nickname, e.Err)
+	case e.Clan.IsZero():
+		return nil, fmt.Errorf("%w: %s", ErrNoClan, nickname)
+	}
+	clan := e.Clan
+	return &clan, nil
+}
+
+func (e cacheEntry) expired(now time.Time) bool {
+	return !now.Before(e.ExpiresAt)
+}
+
+// clanCall это запрос к API, ответ которого ждут все, кто спросил того же игрока
+type clanCall struct {
+	done  chan struct{}
+	entry cacheEntry
+	err   error
 }
 
 func NewPlayerResolver() *PlayerClanResolver {
 	return &PlayerClanResolver{
-		cache: make(map[string]Clan),
-		rl:    ratelimit.New(30), // 0.3 second
-		cli:   http.DefaultClient,
+		cache:    make(map[string]cacheEntry),
+		inflight: make(map[string]*clanCall),
+		ttl:      DefaultCacheTTL,
+		errorTTL: DefaultErrorTTL,
+		now:      time.Now,
+
+		baseURL: DefaultAPIURL,
+		rl:      ratelimit.New(DefaultRate, ratelimit.WithSlack(DefaultBurst)),
+		cli:     http.DefaultClient,
+		timeout: DefaultTimeout,
+		retries: DefaultRetries,
+		backoff: DefaultBackoff,
+		sleep:   sleepContext,
 	}
 }
 
-func (p *PlayerClanResolver) GetPlayerClan(nickname string) (*Clan, error) {
-	cached, ok := p.cache[nickname]
-	if ok {
-		return &cached, nil
+// SetBaseURL задаёт адрес API, например зеркало или заглушку для тестов.
+func (p *PlayerClanResolver) SetBaseURL(baseURL string) {
+	p.baseURL = strings.TrimRight(baseURL, "/")
+}
+
+// SetTTL задаёт сколько будут жить новые записи в кэше.
+func (p *PlayerClanResolver) SetTTL(ttl time.Duration) {
+	p.ttl = ttl
+}
+
+// SetErrorTTL задаёт сколько помнить неудачные запросы, чтобы не спрашивать одно и то же каждый бой.
+func (p *PlayerClanResolver) SetErrorTTL(ttl time.Duration) {
+	p.errorTTL = ttl
+}
+
+// SetTimeout задаёт сколько ждать ответа на один запрос. 0 - ждать сколько угодно.
+func (p *PlayerClanResolver) SetTimeout(timeout time.Duration) {
+	p.timeout = timeout
+}
+
+// SetRetries задаёт сколько раз повторять неудачный запрос и паузу перед первым повтором.
+func (p *PlayerClanResolver) SetRetries(retries int, backoff time.Duration) {
+	p.retries = retries
+	p.backoff = backoff
+}
+
+// SetRateLimit задаёт сколько запросов в секунду можно слать в API
+// и сколько из них можно отправить разом, если до этого запросов не было.
+// rate 0 - не ограничивать.
+func (p *PlayerClanResolver) SetRateLimit(rate, burst int) {
+	if rate <= 0 {
+		p.rl = ratelimit.NewUnlimited()
+		return
+	}
+	p.rl = ratelimit.New(rate, ratelimit.WithSlack(burst))
+}
+
+// Stats возвращает статистику запросов к API с момента создания резолвера.
+func (p *PlayerClanResolver) Stats() APIStats {
+	p.statsMu.Lock()
+	defer p.statsMu.Unlock()
+	return p.stats
+}
+
+func (p *PlayerClanResolver) countLookup(hit bool) {
+	p.statsMu.Lock()
+	defer p.statsMu.Unlock()
+	if hit {
+		p.stats.CacheHits++
+	} else {
+		p.stats.CacheMisses++
+	}
+}
+
+func (p *PlayerClanResolver) countRequest(latency time.Duration, err error) {
+	p.statsMu.Lock()
+	defer p.statsMu.Unlock()
+	p.stats.Requests++
+	if err != nil && !errors.Is(err, errNotFound) {
+		p.stats.Failed++
+	}
+	p.stats.Latency += latency
+	if latency > p.stats.MaxLatency {
+		p.stats.MaxLatency = latency
 	}
-	clan, err := p.getFromAPI(nickname)
+}
+
+func (p *PlayerClanResolver) GetPlayerClan(ctx context.Context, nickname string) (*Clan, error) {
+	entry, err := p.lookup(ctx, nickname, false)
 	if err != nil {
-		return nil, fmt.Errorf("get player clan from api: %s, %w", nickname, err)
+		return nil, err
 	}
+	return entry.result(nickname)
+}
 
-	p.cache[nickname] = *clan
-	return clan, nil
+// GetPlayerProfile узнаёт всё, что API знает об игроке. Профиль кэшируется вместе с кланом.
+func (p *PlayerClanResolver) GetPlayerProfile(ctx context.Context, nickname string) (*PlayerProfile, error) {
+	entry, err := p.lookup(ctx, nickname, true)
+	if err != nil {
+		return nil, err
+	}
+	if _, err := entry.result(nickname); err != nil && !errors.Is(err, ErrNoClan) {
+		return nil, err
+	}
+	// API недоступно, а в протухшей записи профиля не было
+	if entry.Profile == nil {
+		return nil, fmt.Errorf("get player profile: %s, profile is not cached", nickname)
+	}
+	profile := *entry.Profile
+	return &profile, nil
 }
 
-func (p *PlayerClanResolver) getFromAPI(nickname string) (*Clan, error) {
-	req, err := http.NewRequest(
+// lookup отдаёт запись кэша, при необходимости спросив API.
+// wantProfile значит что запись без профиля не годится.
+func (p *PlayerClanResolver) lookup(ctx context.Context, nickname string, wantProfile bool) (cacheEntry, error) {
+	for {
+		p.mu.Lock()
+		cached, ok := p.cache[nickname]
+		if ok && !cached.expired(p.now()) && (!wantProfile || cached.Profile != nil || cached.failed()) {
+			p.mu.Unlock()
+			p.countLookup(true)
+			return cached, nil
+		}
+
+		call, running := p.inflight[nickname]
+		if !running {
+			call = &clanCall{done: make(chan struct{})}
+			p.inflight[nickname] = call
+			p.mu.Unlock()
+			p.countLookup(false)
+
+			p.resolve(ctx, nickname, call)
+			return call.entry, call.err
+		}
+		p.mu.Unlock()
+
+		// того же игрока уже спрашивают, ждём ответа
+		select {
+		case <-call.done:
+		case <-ctx.Done():
+			return cacheEntry{}, fmt.Errorf("get player clan from api: %s, %w", nickname, ctx.Err())
+		}
+
+		// отменили того, кто спрашивал, а нас нет - спросим сами
+		if isContextError(call.err) && ctx.Err() == nil {
+			continue
+		}
+		return call.entry, call.err
+	}
+}
+
+// resolve спрашивает API и отдаёт ответ всем, кто ждёт call
+func (p *PlayerClanResolver) resolve(ctx context.Context, nickname string, call *clanCall) {
+	profile, err := p.getFromAPI(ctx, nickname)
+
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	defer close(call.done)
+	delete(p.inflight, nickname)
+
+	switch {
+	case err == nil:
+		p.storeLocked(nickname, cacheEntry{Clan: profile.Clan, Profile: profile})
+	case isContextError(err) && ctx.Err() != nil:
+		// нас отменили, API тут ни при чём
+		call.err = fmt.Errorf("get player clan from api: %s, %w", nickname, err)
+		return
+	case errors.Is(err, ErrUnknownPlayer):
+		p.storeLocked(nickname, cacheEntry{NotFound: true})
+	default:
+		// протухший кэш лучше чем ничего, например когда нет интернета.
+		// Снова его не спрашиваем пока не пройдёт errorTTL, иначе без сети каждый бой ждёт повторов.
+		if cached, ok := p.cache[nickname]; ok && !cached.failed() {
+			cached.ExpiresAt = p.now().Add(p.errorTTL)
+			p.cache[nickname] = cached
+			call.entry = cached
+			return
+		}
+		p.storeLocked(nickname, cacheEntry{Err: err.Error()})
+		call.err = fmt.Errorf("get player clan from api: %s, %w", nickname, err)
+		return
+	}
+
+	call.entry = p.cache[nickname]
+}
+
+func isContextError(err error) bool {
+	return errors.Is(err, context.Canceled) || errors.Is(err, context.DeadlineExceeded)
+}
+
+func (p *PlayerClanResolver) store(nickname string, clan Clan) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	p.storeLocked(nickname, cacheEntry{Clan: clan})
+}
+
+func (p *PlayerClanResolver) storeLocked(nickname string, entry cacheEntry) {
+	ttl := p.ttl
+	if entry.failed() {
+		ttl = p.errorTTL
+	}
+
+	now := p.now()
+	entry.FetchedAt = now
+	entry.ExpiresAt = now.Add(ttl)
+	p.cache[nickname] = entry
+}
+
+// getFromAPI спрашивает профиль игрока у API.
+func (p *PlayerClanResolver) getFromAPI(ctx context.Context, nickname string) (*PlayerProfile, error) {
+	var profile PlayerProfile
+	err := p.apiCall(ctx, "userinfo.php", url.Values{"nickname": {nickname}}, &profile)
+	if errors.Is(err, errNotFound) {
+		return nil, fmt.Errorf("%w: %s, %v", ErrUnknownPlayer, nickname, err)
+	}
+	if err != nil {
+		return nil, err
+	}
+	return &profile, nil
+}
+
+// errNotFound значит что API не знает того, о чём спросили
+var errNotFound = errors.New("not found")
+
+// apiCall делает запрос к API и раскладывает data из ответа в v.
+// Запрос повторяется если API не ответило или ответило 5xx.
+func (p *PlayerClanResolver) apiCall(ctx context.Context, method string, query url.Values, v interface{}) error {
+	backoff := p.backoff
+	for attempt := 0; ; attempt++ {
+		err := p.apiRequest(ctx, method, query, v)
+		if err == nil || attempt >= p.retries || !retryable(ctx, err) {
+			return err
+		}
+
+		if err := p.sleep(ctx, backoff); err != nil {
+			return err
+		}
+		backoff *= 2
+		if backoff > maxBackoff {
+			backoff = maxBackoff
+		}
+	}
+}
+
+func (p *PlayerClanResolver) apiRequest(ctx context.Context, method string, query url.Values, v interface{}) (err error) {
+	// таймаут отсчитываем после ограничителя, иначе очередь съест время запроса
+	p.rl.Take()
+	start := time.Now()
+	defer func() { p.countRequest(time.Since(start), err) }()
+	if p.timeout > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, p.timeout)
+		defer cancel()
+	}
+
+	req, err := http.NewRequestWithContext(
+		ctx,
 		http.MethodGet,
-		fmt.Sprintf("http://gmt.star-conflict.com/pubapi/v1/userinfo.php?nickname=%s", nickname),
+		p.baseURL+"/"+method+"?"+query.Encode(),
 		nil,
 	)
 	if err != nil {
-		return nil, fmt.Errorf("create request: %w", err)
+		return fmt.Errorf("create request: %w", err)
 	}
 
-	p.rl.Take()
-
 	response, err := p.cli.Do(req)
 	if err != nil {
-		return nil, fmt.Errorf("do request: %w", err)
+		return fmt.Errorf("do request: %w", err)
 	}
 	defer response.Body.Close()
 
+	switch {
+	case response.StatusCode == http.StatusNotFound:
+		return errNotFound
+	case response.StatusCode != http.StatusOK:
+		// тело всё равно дочитываем, чтобы соединение можно было переиспользовать
+		_, _ = io.Copy(io.Discard, response.Body)
+		return &StatusError{StatusCode: response.StatusCode, Status: response.Status}
+	}
+
 	var data struct {
-		Data struct {
-			Clan struct {
-				Name string `json:"name,omitempty"`
-				Tag  string `json:"tag,omitempty"`
-			} `json:"clan,omitempty"`
-		} `json:"data"`
+		Result string          `json:"result"`
+		Code   int             `json:"code"`
+		Text   string          `json:"text"`
+		Data   json.RawMessage `json:"data"`
 	}
 
 	err = json.NewDecoder(response.Body).Decode(&data)
 	if err != nil {
-		return nil, fmt.Errorf("decode response: %w", err)
+		return fmt.Errorf("decode response: %w", err)
+	}
+
+	if data.Result != "" && data.Result != "ok" {
+		apiErr := &APIError{Code: data.Code, Text: data.Text}
+		if strings.Contains(strings.ToLower(data.Text), "not found") {
+			return fmt.Errorf("%w: %v", errNotFound, apiErr)
+		}
+		return apiErr
+	}
+	if len(data.Data) == 0 {
+		return nil
+	}
+
+	if err := json.Unmarshal(data.Data, v); err != nil {
+		return fmt.Errorf("decode response data: %w", err)
+	}
+	return nil
+}
+
+// retryable решает стоит ли повторять запрос после ошибки
+func retryable(ctx context.Context, err error) bool {
+	if ctx.Err() != nil {
+		return false
+	}
+	if errors.Is(err, errNotFound) || errors.Is(err, ErrUnknownPlayer) {
+		return false
+	}
+	var statusErr *StatusError
+	if errors.As(err, &statusErr) {
+		return statusErr.Temporary()
+	}
+	var apiErr *APIError
+	if errors.As(err, &apiErr) {
+		return false
+	}
+	// сеть, таймаут одного запроса, оборванный ответ
+	return true
+}
+
+func sleepContext(ctx context.Context, d time.Duration) error {
+	timer := time.NewTimer(d)
+	defer timer.Stop()
+
+	select {
+	case <-timer.C:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+// Invalidate выкидывает игроков из кэша, чтобы в следующий раз клан спросили у API заново.
+func (p *PlayerClanResolver) Invalidate(nicknames ...string) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	for _, nickname := range nicknames {
+		delete(p.cache, nickname)
+	}
+}
+
+// InvalidateAll очищает кэш целиком.
+func (p *PlayerClanResolver) InvalidateAll() {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	p.cache = make(map[string]cacheEntry)
+}
+
+// InvalidateFailed выкидывает из кэша неудачные запросы, чтобы их повторили в следующий раз.
+// Возвращает ники, которые выкинули.
+func (p *PlayerClanResolver) InvalidateFailed() []string {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	var res []string
+	for nickname, entry := range p.cache {
+		if entry.failed() {
+			delete(p.cache, nickname)
+			res = append(res, nickname)
+		}
 	}
+	sort.Strings(res)
+	return res
+}
 
-	return &Clan{Name: data.Data.Clan.Name, Tag: data.Data.Clan.Tag}, nil
+// CachedClan это запись кэша вместе с временем, когда её получили.
+type CachedClan struct {
+	Nickname string
+	Clan     Clan
+	// Err это почему клан узнать не удалось. Пусто если удалось.
+	Err       string
+	FetchedAt time.Time
+	ExpiresAt time.Time
 }
 
-func (p *PlayerClanResolver) AddOldNickname(oldNickname, newNickname string) error {
-	clanCached, ok := p.cache[newNickname]
-	if ok {
-		p.cache[oldNickname] = clanCached
+// Cached возвращает содержимое кэша.
+func (p *PlayerClanResolver) Cached() []CachedClan {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	res := make([]CachedClan, 0, len(p.cache))
+	for nickname, entry := range p.cache {
+		errText := entry.Err
+		if entry.NotFound {
+			errText = ErrUnknownPlayer.Error()
+		}
+		res = append(res, CachedClan{
+			Nickname:  nickname,
+			Clan:      entry.Clan,
+			Err:       errText,
+			FetchedAt: entry.FetchedAt,
+			ExpiresAt: entry.ExpiresAt,
+		})
 	}
+	return res
+}
+
+// cacheFile это формат кэша на диске
+type cacheFile struct {
+	Players map[string]cacheEntry
+}
 
-	clan, err := p.GetPlayerClan(newNickname)
+// LoadCache добавляет в кэш записи из файла. Если файла нет, то ничего не происходит.
+// Протухшие записи тоже загружаются - они пригодятся если API недоступно.
+func (p *PlayerClanResolver) LoadCache(path string) error {
+	data, err := os.ReadFile(path)
 	if err != nil {
-		return err
+		if errors.Is(err, os.ErrNotExist) {
+			return nil
+		}
+		return fmt.Errorf("read clan cache: %w", err)
 	}
 
-	p.cache[oldNickname] = *clan
+	var f cacheFile
+	if err := json.Unmarshal(data, &f); err != nil {
+		return fmt.Errorf("decode clan cache: %q: %w", path, err)
+	}
+
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	for nickname, entry := range f.Players {
+		p.cache[nickname] = entry
+	}
 	return nil
 }
+
+// SaveCache записывает кэш в файл.
+func (p *PlayerClanResolver) SaveCache(path string) error {
+	p.mu.Lock()
+	data, err := json.MarshalIndent(cacheFile{Players: p.cache}, "", "\t")
+	p.mu.Unlock()
+	if err != nil {
+		return err
+	}
+	return atomicfile.WriteFile(path, data)
+}
@@ -0,0 +1,490 @@
+package rules
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/Feresey/haward/parse"
+	"github.com/stretchr/testify/require"
+)
+
+type roundTripFunc func(*http.Request) (*http.Response, error)
+
+func (f roundTripFunc) RoundTrip(req *http.Request) (*http.Response, error) {
+	return f(req)
+}
+
+// offlineResolver это резолвер, у которого нет интернета
+func offlineResolver(now *time.Time) (*PlayerClanResolver, *int) {
+	var requests int
+
+	p := NewPlayerResolver()
+	p.now = func() time.Time { return *now }
+	// повторять запросы без сети бессмысленно
+	p.SetRetries(0, 0)
+	p.cli = &http.Client{
+		Transport: roundTripFunc(func(*http.Request) (*http.Response, error) {
+			requests++
+			return nil, errors.New("no network")
+		}),
+	}
+	return p, &requests
+}
+
+func TestResolverCacheTTL(t *testing.T) {
+	r := require.New(t)
+
+	now := time.Date(2021, time.October, 19, 12, 0, 0, 0, time.UTC)
+	p, requests := offlineResolver(&now)
+	p.SetTTL(time.Hour)
+
+	p.store("NikSvir", Clan{Name: "The Dark Invaders", Tag: "xIDx"})
+
+	clan, err := p.GetPlayerClan(context.Background(), "NikSvir")
+	r.NoError(err)
+	r.Equal(&Clan{Name: "The Dark Invaders", Tag: "xIDx"}, clan)
+	r.Zero(*requests)
+
+	// запись протухла, API недоступно - отдаём что было
+	now = now.Add(2 * time.Hour)
+	clan, err = p.GetPlayerClan(context.Background(), "NikSvir")
+	r.NoError(err)
+	r.Equal(&Clan{Name: "The Dark Invaders", Tag: "xIDx"}, clan)
+	r.Equal(1, *requests)
+
+	// и какое-то время больше не спрашиваем
+	clan, err = p.GetPlayerClan(context.Background(), "NikSvir")
+	r.NoError(err)
+	r.Equal(&Clan{Name: "The Dark Invaders", Tag: "xIDx"}, clan)
+	r.Equal(1, *requests)
+
+	now = now.Add(p.errorTTL)
+	_, err = p.GetPlayerClan(context.Background(), "NikSvir")
+	r.NoError(err)
+	r.Equal(2, *requests)
+
+	_, err = p.GetPlayerClan(context.Background(), "Unknown")
+	r.Error(err)
+
+	p.Invalidate("NikSvir")
+	_, err = p.GetPlayerClan(context.Background(), "NikSvir")
+	r.Error(err)
+}
+
+func TestResolverCacheFile(t *testing.T) {
+	r := require.New(t)
+
+	now := time.Date(2021, time.October, 19, 12, 0, 0, 0, time.UTC)
+	p, _ := offlineResolver(&now)
+	p.store("NikSvir", Clan{Name: "The Dark Invaders", Tag: "xIDx"})
+	p.store("HoWHoW", Clan{})
+
+	path := filepath.Join(t.TempDir(), "cache.json")
+	r.NoError(p.SaveCache(path))
+
+	loaded, requests := offlineResolver(&now)
+	r.NoError(loaded.LoadCache(path))
+	r.ElementsMatch(p.Cached(), loaded.Cached())
+
+	clan, err := loaded.GetPlayerClan(context.Background(), "NikSvir")
+	r.NoError(err)
+	r.Equal("xIDx", clan.Tag)
+	r.Zero(*requests)
+
+	loaded.InvalidateAll()
+	r.Empty(loaded.Cached())
+
+	r.NoError(loaded.LoadCache(filepath.Join(t.TempDir(), "missing.json")))
+}
+
+// apiResolver это резолвер, который ходит в заглушку API и не ждёт между повторами
+func apiResolver(t *testing.T, handler http.HandlerFunc) *PlayerClanResolver {
+	srv := httptest.NewServer(handler)
+	t.Cleanup(srv.Close)
+
+	p := NewPlayerResolver()
+	p.SetBaseURL(srv.URL)
+	p.sleep = func(ctx context.Context, _ time.Duration) error { return ctx.Err() }
+	return p
+}
+
+func TestResolverAPI(t *testing.T) {
+	ctx := context.Background()
+
+	t.Run("ok", func(t *testing.T) {
+		r := require.New(t)
+		p := apiResolver(t, func(w http.ResponseWriter, req *http.Request) {
+			r.Equal("/userinfo.php", req.URL.Path)
+			r.Equal("Cat & Dog", req.URL.Query().Get("nickname"))
+			fmt.Fprint(w, `{"result":"ok","code":0,"data":{"clan":{"name":"Nekopara","tag":"NEKO"}}}`)
+		})
+
+		clan, err := p.GetPlayerClan(ctx, "Cat & Dog")
+		r.NoError(err)
+		r.Equal(&Clan{Name: "Nekopara", Tag: "NEKO"}, clan)
+	})
+
+	t.Run("not found", func(t *testing.T) {
+		r := require.New(t)
+		var requests int
+		p := apiResolver(t, func(w http.ResponseWriter, req *http.Request) {
+			requests++
+			fmt.Fprint(w, `{"result":"error","code":1,"text":"User not found"}`)
+		})
+
+		_, err := p.GetPlayerClan(ctx, "Nobody")
+		r.True(errors.Is(err, ErrUnknownPlayer), err)
+		r.Equal(1, requests, "no retries for unknown players")
+	})
+
+	t.Run("404", func(t *testing.T) {
+		r := require.New(t)
+		p := apiResolver(t, http.NotFound)
+
+		_, err := p.GetPlayerClan(ctx, "Nobody")
+		r.True(errors.Is(err, ErrUnknownPlayer), err)
+	})
+
+	t.Run("api error", func(t *testing.T) {
+		r := require.New(t)
+		p := apiResolver(t, func(w http.ResponseWriter, req *http.Request) {
+			fmt.Fprint(w, `{"result":"error","code":3,"text":"Internal error"}`)
+		})
+
+		_, err := p.GetPlayerClan(ctx, "Cat")
+		var apiErr *APIError
+		r.True(errors.As(err, &apiErr), err)
+		r.Equal(3, apiErr.Code)
+	})
+
+	t.Run("retry 5xx", func(t *testing.T) {
+		r := require.New(t)
+		var requests int
+		p := apiResolver(t, func(w http.ResponseWriter, req *http.Request) {
+			requests++
+			if requests < 3 {
+				http.Error(w, "busy", http.StatusServiceUnavailable)
+				return
+			}
+			fmt.Fprint(w, `{"result":"ok","data":{"clan":{"name":"Nekopara","tag":"NEKO"}}}`)
+		})
+
+		clan, err := p.GetPlayerClan(ctx, "Cat")
+		r.NoError(err)
+		r.Equal("NEKO", clan.Tag)
+		r.Equal(3, requests)
+	})
+
+	t.Run("give up", func(t *testing.T) {
+		r := require.New(t)
+		var requests int
+		p := apiResolver(t, func(w http.ResponseWriter, req *http.Request) {
+			requests++
+			http.Error(w, "busy", http.StatusBadGateway)
+		})
+
+		_, err := p.GetPlayerClan(ctx, "Cat")
+		var statusErr *StatusError
+		r.True(errors.As(err, &statusErr), err)
+		r.Equal(http.StatusBadGateway, statusErr.StatusCode)
+		r.Equal(1+DefaultRetries, requests)
+	})
+
+	t.Run("no retry 4xx", func(t *testing.T) {
+		r := require.New(t)
+		var requests int
+		p := apiResolver(t, func(w http.ResponseWriter, req *http.Request) {
+			requests++
+			http.Error(w, "go away", http.StatusForbidden)
+		})
+
+		_, err := p.GetPlayerClan(ctx, "Cat")
+		var statusErr *StatusError
+		r.True(errors.As(err, &statusErr), err)
+		r.Equal(1, requests)
+	})
+
+	t.Run("timeout", func(t *testing.T) {
+		r := require.New(t)
+		done := make(chan struct{})
+		defer close(done)
+
+		var requests int32
+		p := apiResolver(t, func(w http.ResponseWriter, req *http.Request) {
+			atomic.AddInt32(&requests, 1)
+			select {
+			case <-req.Context().Done():
+			case <-done:
+			}
+		})
+		p.SetTimeout(10 * time.Millisecond)
+		p.SetRetries(1, 0)
+
+		_, err := p.GetPlayerClan(ctx, "Cat")
+		r.True(errors.Is(err, context.DeadlineExceeded), err)
+		// сервер может не успеть увидеть второй запрос до того, как клиент сдался
+		r.Eventually(func() bool {
+			return atomic.LoadInt32(&requests) == 2
+		}, time.Second, time.Millisecond)
+	})
+
+	t.Run("canceled", func(t *testing.T) {
+		r := require.New(t)
+		var requests int
+		p := apiResolver(t, func(w http.ResponseWriter, req *http.Request) {
+			requests++
+			http.Error(w, "busy", http.StatusServiceUnavailable)
+		})
+
+		now := time.Now()
+		p.now = func() time.Time { return now }
+		p.SetTTL(time.Minute)
+		p.store("Cat", Clan{Tag: "NEKO"})
+		now = now.Add(time.Hour)
+
+		ctx, cancel := context.WithCancel(ctx)
+		cancel()
+
+		// отменённый запрос не прикрывается протухшим кэшем
+		_, err := p.GetPlayerClan(ctx, "Cat")
+		r.True(errors.Is(err, context.Canceled), err)
+		r.Zero(requests)
+	})
+}
+
+func TestResolverConcurrent(t *testing.T) {
+	r := require.New(t)
+
+	var requests int32
+	release := make(chan struct{})
+	p := apiResolver(t, func(w http.ResponseWriter, req *http.Request) {
+		atomic.AddInt32(&requests, 1)
+		<-release
+		fmt.Fprint(w, `{"result":"ok","data":{"clan":{"name":"Nekopara","tag":"NEKO"}}}`)
+	})
+
+	const callers = 20
+	clans := make(chan *Clan, callers)
+	errs := make(chan error, callers)
+	for i := 0; i < callers; i++ {
+		go func() {
+			clan, err := p.GetPlayerClan(context.Background(), "Cat")
+			clans <- clan
+			errs <- err
+		}()
+	}
+
+	// все ждут один и тот же запрос
+	r.Eventually(func() bool {
+		return atomic.LoadInt32(&requests) == 1
+	}, time.Second, time.Millisecond)
+	close(release)
+
+	for i := 0; i < callers; i++ {
+		r.NoError(<-errs)
+		r.Equal("NEKO", (<-clans).Tag)
+	}
+	r.EqualValues(1, atomic.LoadInt32(&requests))
+	r.Len(p.Cached(), 1)
+}
+
+func TestResolveClans(t *testing.T) {
+	r := require.New(t)
+
+	var (
+		running, maxRunning int32
+		asked               sync.Map
+	)
+	resolver := resolverFunc(func(nickname string) (*Clan, error) {
+		n := atomic.AddInt32(&running, 1)
+		defer atomic.AddInt32(&running, -1)
+		for {
+			m := atomic.LoadInt32(&maxRunning)
+			if n <= m || atomic.CompareAndSwapInt32(&maxRunning, m, n) {
+				break
+			}
+		}
+		if _, loaded := asked.LoadOrStore(nickname, true); loaded {
+			t.Errorf("%s asked twice", nickname)
+		}
+		time.Sleep(5 * time.Millisecond)
+
+		if nickname == "Nobody" {
+			return nil, ErrUnknownPlayer
+		}
+		return &Clan{Tag: strings.ToUpper(nickname)}, nil
+	})
+
+	nicknames := []string{"Cat", "Dog", "Fox", "Cat", "Owl", "Nobody", "Elk", "Dog"}
+	res := ResolveClans(context.Background(), resolver, nicknames, 3)
+
+	r.Len(res, 6)
+	r.Equal("CAT", res["Cat"].Clan.Tag)
+	r.Equal("OWL", res["Owl"].Clan.Tag)
+	r.True(errors.Is(res["Nobody"].Err, ErrUnknownPlayer))
+	r.LessOrEqual(atomic.LoadInt32(&maxRunning), int32(3))
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	res = ResolveClans(ctx, resolver, []string{"Bat"}, 3)
+	r.True(errors.Is(res["Bat"].Err, context.Canceled))
+}
+
+func TestResolverNegativeCache(t *testing.T) {
+	r := require.New(t)
+
+	now := time.Date(2021, time.October, 19, 12, 0, 0, 0, time.UTC)
+	p, requests := offlineResolver(&now)
+	p.SetErrorTTL(time.Minute)
+
+	_, err := p.GetPlayerClan(context.Background(), "NikSvir")
+	r.Error(err)
+	r.Equal(1, *requests)
+
+	// ошибку помним и в сеть не ходим
+	_, err = p.GetPlayerClan(context.Background(), "NikSvir")
+	r.Error(err)
+	r.Equal(1, *requests)
+
+	cached := p.Cached()
+	r.Len(cached, 1)
+	r.Contains(cached[0].Err, "no network")
+
+	now = now.Add(2 * time.Minute)
+	_, err = p.GetPlayerClan(context.Background(), "NikSvir")
+	r.Error(err)
+	r.Equal(2, *requests)
+
+	r.Equal([]string{"NikSvir"}, p.InvalidateFailed())
+	r.Empty(p.Cached())
+}
+
+func TestResolverNoClan(t *testing.T) {
+	r := require.New(t)
+
+	var requests int
+	p := apiResolver(t, func(w http.ResponseWriter, req *http.Request) {
+		requests++
+		switch req.URL.Query().Get("nickname") {
+		case "HoWHoW":
+			fmt.Fprint(w, `{"result":"ok","data":{"clan":{"name":"","tag":""}}}`)
+		default:
+			fmt.Fprint(w, `{"result":"error","code":1,"text":"User not found"}`)
+		}
+	})
+
+	for i := 0; i < 2; i++ {
+		_, err := p.GetPlayerClan(context.Background(), "HoWHoW")
+		r.True(errors.Is(err, ErrNoClan), err)
+
+		_, err = p.GetPlayerClan(context.Background(), "Nobody")
+		r.True(errors.Is(err, ErrUnknownPlayer), err)
+	}
+	r.Equal(2, requests)
+
+	// без клана это не ошибка, а игрок которого нет - ошибка
+	r.Equal([]string{"Nobody"}, p.InvalidateFailed())
+
+	// за пустое название клана ничего не дают
+	rules, err := NewRules(strings.NewReader(""), WithResolver(p))
+	r.NoError(err)
+	rules.clanNames[""] = 100
+	_, ok := rules.GetAward(context.Background(), Kill{Target: parse.Player{Name: "HoWHoW"}})
+	r.False(ok)
+}
+
+func TestResolverProfile(t *testing.T) {
+	r := require.New(t)
+
+	var requests int
+	p := apiResolver(t, func(w http.ResponseWriter, req *http.Request) {
+		requests++
+		fmt.Fprint(w, `{"result":"ok","code":0,"data":{
+			"uid":2516405,"nickname":"ZiroTwo","effRating":5321,"karma":12,"prestigeBonus":0.25,
+			"clan":{"name":"The Dark Invaders","tag":"xIDx"},
+			"pvp":{"gamePlayed":100,"gameWin":55,"totalKill":300,"totalDeath":150,"totalAssists":20},
+			"coop":{"gamePlayed":3}
+		}}`)
+	})
+
+	// старая запись в кэше без профиля
+	p.store("ZiroTwo", Clan{Name: "The Dark Invaders", Tag: "xIDx"})
+
+	clan, err := p.GetPlayerClan(context.Background(), "ZiroTwo")
+	r.NoError(err)
+	r.Equal("xIDx", clan.Tag)
+	r.Zero(requests)
+
+	profile, err := p.GetPlayerProfile(context.Background(), "ZiroTwo")
+	r.NoError(err)
+	r.Equal(&PlayerProfile{
+		UID:           2516405,
+		Nickname:      "ZiroTwo",
+		EffRating:     5321,
+		Karma:         12,
+		PrestigeBonus: 0.25,
+		Clan:          Clan{Name: "The Dark Invaders", Tag: "xIDx"},
+		PvP:           PvPStats{GamePlayed: 100, GameWin: 55, TotalKill: 300, TotalDeath: 150, TotalAssists: 20},
+	}, profile)
+	r.Equal(1, requests)
+
+	// теперь профиль в кэше
+	_, err = p.GetPlayerProfile(context.Background(), "ZiroTwo")
+	r.NoError(err)
+	r.Equal(1, requests)
+
+	path := filepath.Join(t.TempDir(), "cache.json")
+	r.NoError(p.SaveCache(path))
+	loaded := NewPlayerResolver()
+	r.NoError(loaded.LoadCache(path))
+	cachedProfile, err := loaded.GetPlayerProfile(context.Background(), "ZiroTwo")
+	r.NoError(err)
+	r.Equal(profile, cachedProfile)
+}
+
+func TestResolverStats(t *testing.T) {
+	r := require.New(t)
+
+	p := apiResolver(t, func(w http.ResponseWriter, req *http.Request) {
+		switch req.URL.Query().Get("nickname") {
+		case "Cat":
+			fmt.Fprint(w, `{"result":"ok","data":{"clan":{"name":"Nekopara","tag":"NEKO"}}}`)
+		case "Nobody":
+			fmt.Fprint(w, `{"result":"error","code":1,"text":"User not found"}`)
+		default:
+			w.WriteHeader(http.StatusInternalServerError)
+		}
+	})
+	p.SetRetries(1, 0)
+	p.SetRateLimit(0, 0)
+
+	for i := 0; i < 3; i++ {
+		_, err := p.GetPlayerClan(context.Background(), "Cat")
+		r.NoError(err)
+	}
+	_, err := p.GetPlayerClan(context.Background(), "Nobody")
+	r.True(errors.Is(err, ErrUnknownPlayer), err)
+	_, err = p.GetPlayerClan(context.Background(), "Broken")
+	r.Error(err)
+
+	stats := p.Stats()
+	// Broken спрашивали дважды, второй раз повтором
+	r.Equal(4, stats.Requests)
+	r.Equal(2, stats.Failed)
+	r.Equal(2, stats.CacheHits)
+	r.Equal(3, stats.CacheMisses)
+	r.InDelta(0.4, stats.HitRatio(), 1e-9)
+	r.GreaterOrEqual(stats.MaxLatency, stats.AvgLatency())
+
+	r.Zero(APIStats{}.HitRatio())
+	r.Zero(APIStats{}.AvgLatency())
+}
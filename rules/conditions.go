@@ -0,0 +1,133 @@
+package rules
+
+import (
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/Feresey/haward/parse"
+)
+
+// Kill описывает обстоятельства сбития, от которых зависит награда.
+type Kill struct {
+	Target parse.Player
+	Hunter parse.Player
+	// GameMode режим игры, например KingOfTheHill или ClanShip
+	GameMode string
+	// ShotFirst - цель первой начала стрелять в охотника
+	ShotFirst bool
+	// At время боя. По нему узнаётся в каких кланах были игроки.
+	At time.Time
+}
+
+// Bounty это запись из правил, под которую попал игрок.
+type Bounty struct {
+	Score int
+	// Allow условия, при которых штрафного игрока можно сбивать бесплатно
+	Allow []Condition
+	// Corporation - награда за клан цели, а не за её ник или профиль
+	Corporation bool
+	// Profile это профиль цели, если награда за профиль. По нему награду можно проверить без API.
+	Profile *PlayerProfile
+}
+
+// Award считает награду за конкретное сбитие.
+// ok == false значит что сбитие не считается вовсе.
+func (b Bounty) Award(k Kill) (award int, ok bool) {
+	for _, cond := range b.Allow {
+		if cond.Match(k) {
+			return 0, false
+		}
+	}
+	return b.Score, true
+}
+
+// Condition это условие из директивы @allow: имя и необязательный аргумент (mode:ClanShip).
+type Condition struct {
+	Name string
+	Arg  string
+}
+
+func (c Condition) String() string {
+	if c.Arg == "" {
+		return c.Name
+	}
+	return c.Name + ":" + c.Arg
+}
+
+func (c Condition) Match(k Kill) bool {
+	check, ok := conditions[c.Name]
+	return ok && check(k, c.Arg)
+}
+
+// conditions это все известные условия.
+var conditions = map[string]func(k Kill, arg string) bool{
+	// цель летает в группе
+	"group": func(k Kill, _ string) bool {
+		return k.Target.InGroup
+	},
+	// и цель и охотник летают в группах - отряд на отряд
+	"squad": func(k Kill, _ string) bool {
+		return k.Target.InGroup && k.Hunter.InGroup
+	},
+	// цель первой начала стрелять
+	"shot_first": func(k Kill, _ string) bool {
+		return k.ShotFirst
+	},
+	// бой в определённом режиме, например mode:ClanShip для турниров
+	"mode": func(k Kill, arg string) bool {
+		return strings.EqualFold(k.GameMode, arg)
+	},
+}
+
+// conditionsNone отключает все исключения для группы
+const conditionsNone = "none"
+
+// defaultAllow это исключения для штрафов без директивы @allow.
+// Раньше повелителей бури можно было сбивать если они в группе, так и оставим.
+var defaultAllow = []Condition{{Name: "group"}}
+
+// parseAllow разбирает список условий: "group, mode:ClanShip"
+func parseAllow(s string) ([]Condition, error) {
+	var (
+		res  []Condition
+		none bool
+	)
+	for _, raw := range strings.Split(s, ",") {
+		raw = strings.TrimSpace(raw)
+		if raw == "" {
+			continue
+		}
+		if raw == conditionsNone {
+			none = true
+			continue
+		}
+		// none рядом с условием не понятно что значит
+		if strings.HasPrefix(raw, conditionsNone+" ") {
+			return nil, fmt.Errorf("%q can not be used with other conditions: %q", conditionsNone, s)
+		}
+
+		var cond Condition
+		if idx := strings.Index(raw, ":"); idx != -1 {
+			cond = Condition{Name: raw[:idx], Arg: raw[idx+1:]}
+		} else {
+			cond = Condition{Name: raw}
+		}
+
+		if _, ok := conditions[cond.Name]; !ok {
+			return nil, fmt.Errorf("unknown condition: %q", cond.Name)
+		}
+		res = append(res, cond)
+	}
+
+	if none {
+		if len(res) != 0 {
+			return nil, fmt.Errorf("%q can not be used with other conditions: %q", conditionsNone, s)
+		}
+		return []Condition{}, nil
+	}
+	if len(res) == 0 {
+		return nil, fmt.Errorf("empty conditions list: %q", s)
+	}
+	return res, nil
+}
@@ -0,0 +1,133 @@
+package rules
+
+import (
+	"context"
+	"strings"
+	"testing"
+
+	"github.com/Feresey/haward/parse"
+	"github.com/stretchr/testify/require"
+)
+
+func TestPunishmentConditions(t *testing.T) {
+	const rulesTxt = `
+=== PLAYERS ===
++10
+NikSvir
+===
+-40
+Legacy
+===
+-40
+@allow none
+Strict
+===
+-40
+@allow shot_first, mode:ClanShip
+Tournament
+===
+-40
+@allow squad
+Squad
+`
+	rules, err := NewRules(strings.NewReader(rulesTxt))
+	require.NoError(t, err)
+
+	tests := []struct {
+		name  string
+		kill  Kill
+		award int
+		ok    bool
+	}{
+		{
+			name:  "award ignores conditions",
+			kill:  Kill{Target: parse.Player{Name: "NikSvir", InGroup: true}},
+			award: 10, ok: true,
+		},
+		{
+			name:  "legacy punishment solo",
+			kill:  Kill{Target: parse.Player{Name: "Legacy"}},
+			award: -40, ok: true,
+		},
+		{
+			name: "legacy punishment in group",
+			kill: Kill{Target: parse.Player{Name: "Legacy", InGroup: true}},
+		},
+		{
+			name:  "no exemptions",
+			kill:  Kill{Target: parse.Player{Name: "Strict", InGroup: true}, ShotFirst: true},
+			award: -40, ok: true,
+		},
+		{
+			name:  "tournament in other mode",
+			kill:  Kill{Target: parse.Player{Name: "Tournament", InGroup: true}, GameMode: "Control"},
+			award: -40, ok: true,
+		},
+		{
+			name: "tournament in clan ships",
+			kill: Kill{Target: parse.Player{Name: "Tournament"}, GameMode: "ClanShip"},
+		},
+		{
+			name: "tournament shot first",
+			kill: Kill{Target: parse.Player{Name: "Tournament"}, ShotFirst: true},
+		},
+		{
+			name:  "squad against solo",
+			kill:  Kill{Target: parse.Player{Name: "Squad", InGroup: true}},
+			award: -40, ok: true,
+		},
+		{
+			name: "squad against squad",
+			kill: Kill{
+				Target: parse.Player{Name: "Squad", InGroup: true},
+				Hunter: parse.Player{Name: "ZiroTwo", InGroup: true},
+			},
+		},
+	}
+
+	for _, tt := range tests {
+		tt := tt
+		t.Run(tt.name, func(t *testing.T) {
+			award, ok := rules.GetAward(context.Background(), tt.kill)
+			require.Equal(t, tt.ok, ok)
+			require.Equal(t, tt.award, award)
+		})
+	}
+}
+
+func TestParseAllowErrors(t *testing.T) {
+	tests := []struct {
+		name string
+		data string
+	}{
+		{
+			name: "unknown condition",
+			data: "=== PLAYERS ===\n-40\n@allow sunday\nName\n",
+		},
+		{
+			name: "allow for award",
+			data: "=== PLAYERS ===\n+40\n@allow group\nName\n",
+		},
+		{
+			name: "allow for corporation",
+			data: "=== CORPORATIONS ===\n-40\n@allow group\nName\n",
+		},
+		{
+			name: "none with other conditions",
+			data: "=== PLAYERS ===\n-40\n@allow none, group\nName\n",
+		},
+		{
+			name: "none with other conditions without comma",
+			data: "=== PLAYERS ===\n-40\n@allow none group\nName\n",
+		},
+	}
+
+	for _, tt := range tests {
+		tt := tt
+		t.Run(tt.name, func(t *testing.T) {
+			_, err := NewRules(strings.NewReader(tt.data))
+			require.Error(t, err)
+			require.Contains(t, err.Error(), "line 3")
+		})
+	}
+}
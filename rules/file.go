@@ -0,0 +1,195 @@
+package rules
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"strconv"
+	"strings"
+)
+
+const (
+	chapterPlayers      = "=== PLAYERS ==="
+	chapterCorporations = "=== CORPORATIONS ==="
+	chapterProfiles     = "=== PROFILES ==="
+	scoreDelim          = "==="
+	allowDirective      = "@allow "
+	includeDirective    = "@include "
+	removePrefix        = "!"
+	commentPrefix       = "#"
+)
+
+// File это один файл правил как он написан, без подключённых файлов.
+type File struct {
+	// Includes файлы, правила из которых применяются до этого файла
+	Includes     []Entry
+	Players      Section
+	Corporations Section
+	// Profiles награды за игроков, профиль которых подходит под условия
+	Profiles Section
+	// Trailing комментарии в конце файла, которые не относятся ни к одной записи
+	Trailing []string
+}
+
+// Section это секция игроков или корпораций.
+type Section struct {
+	// Comments комментарии над заголовком секции
+	Comments []string
+	Groups   []Group
+	// Remove записи, которые надо убрать из подключённых правил
+	Remove []Entry
+}
+
+// Group это очки и записи под ними.
+type Group struct {
+	// Comments комментарии над очками
+	Comments []string
+	Score    int
+	// Allow условия для штрафов, nil значит условия по умолчанию
+	Allow []Condition
+	// Entries ники (с прошлыми никами через запятую), корпорации или условия на профиль
+	Entries []Entry
+}
+
+// Entry это одна строчка правил вместе с комментариями над ней.
+type Entry struct {
+	Comments []string
+	Text     string
+}
+
+// ParseFile разбирает файл правил.
+func ParseFile(rd io.Reader) (*File, error) {
+	scanner := bufio.NewScanner(rd)
+
+	var (
+		f         File
+		chapter   string
+		section   *Section
+		group     *Group
+		needScore = true
+		// комментарии, которые ещё не к чему прицепить
+		comments []string
+	)
+
+	takeComments := func() []string {
+		res := comments
+		comments = nil
+		return res
+	}
+
+	for lineNum := 1; scanner.Scan(); lineNum++ {
+		line := strings.TrimSpace(scanner.Text())
+		if len(line) == 0 {
+			continue
+		}
+
+		if strings.HasPrefix(line, commentPrefix) {
+			comments = append(comments, strings.TrimSpace(strings.TrimPrefix(line, commentPrefix)))
+			continue
+		}
+
+		// chapters
+		switch line {
+		case chapterPlayers:
+			section = &f.Players
+		case chapterCorporations:
+			section = &f.Corporations
+		case chapterProfiles:
+			section = &f.Profiles
+		}
+		switch line {
+		case chapterPlayers, chapterCorporations, chapterProfiles:
+			chapter = line
+			section.Comments = append(section.Comments, takeComments()...)
+			fallthrough
+		case scoreDelim:
+			needScore = true
+			group = nil
+			continue
+		}
+
+		// подключение других файлов
+		if strings.HasPrefix(line, includeDirective) {
+			f.Includes = append(f.Includes, Entry{
+				Comments: takeComments(),
+				Text:     strings.TrimSpace(strings.TrimPrefix(line, includeDirective)),
+			})
+			continue
+		}
+
+		if section == nil {
+			return nil, fmt.Errorf("line %d: %q is outside of any section", lineNum, line)
+		}
+
+		// удаление записей из подключённых правил, очки не нужны
+		if strings.HasPrefix(line, removePrefix) {
+			text := strings.TrimSpace(strings.TrimPrefix(line, removePrefix))
+			if chapter == chapterProfiles {
+				filter, err := parseProfileFilter(text)
+				if err != nil {
+					return nil, fmt.Errorf("line %d: %w", lineNum, err)
+				}
+				text = filter.String()
+			}
+			section.Remove = append(section.Remove, Entry{
+				Comments: takeComments(),
+				Text:     text,
+			})
+			continue
+		}
+
+		// score number
+		if needScore {
+			num, err := strconv.ParseInt(line, 10, 32)
+			if err != nil {
+				return nil, fmt.Errorf("line %d: parse num: %q: %w", lineNum, line, err)
+			}
+			section.Groups = append(section.Groups, Group{
+				Comments: takeComments(),
+				Score:    int(num),
+			})
+			group = &section.Groups[len(section.Groups)-1]
+			needScore = false
+			continue
+		}
+
+		// условия для группы штрафов
+		if strings.HasPrefix(line, allowDirective) {
+			if chapter != chapterPlayers || group.Score > 0 {
+				return nil, fmt.Errorf("line %d: allow conditions are only for punished players: %q", lineNum, line)
+			}
+			if len(group.Entries) != 0 {
+				return nil, fmt.Errorf("line %d: allow conditions must follow the score: %q", lineNum, line)
+			}
+			conds, err := parseAllow(strings.TrimPrefix(line, allowDirective))
+			if err != nil {
+				return nil, fmt.Errorf("line %d: parse allow: %w", lineNum, err)
+			}
+			group.Allow = conds
+			group.Comments = append(group.Comments, takeComments()...)
+			continue
+		}
+
+		// условия на профиль сразу проверяем и записываем одинаково
+		if chapter == chapterProfiles {
+			filter, err := parseProfileFilter(line)
+			if err != nil {
+				return nil, fmt.Errorf("line %d: %w", lineNum, err)
+			}
+			line = filter.String()
+		}
+
+		group.Entries = append(group.Entries, Entry{
+			Comments: takeComments(),
+			Text:     line,
+		})
+	}
+
+	if err := scanner.Err(); err != nil {
+		return nil, err
+	}
+
+	f.Trailing = takeComments()
+
+	return &f, nil
+}
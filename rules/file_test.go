@@ -0,0 +1,142 @@
+package rules
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/Feresey/haward/parse"
+	"github.com/stretchr/testify/require"
+)
+
+func TestParseFile(t *testing.T) {
+	const rulesTxt = `
+@include global.txt
+
+=== PLAYERS ===
+!Mettle
+
++5
+Jigolee
+Koven1Nordsiard, TechnerParsival1
+===
+-40
+@allow none
+PlayWithMe
+
+=== CORPORATIONS ===
+!Nekopara
++10
+Fright Night [FINS]
+`
+
+	f, err := ParseFile(strings.NewReader(rulesTxt))
+	require.NoError(t, err)
+	require.Equal(t, &File{
+		Includes: []Entry{{Text: "global.txt"}},
+		Players: Section{
+			Remove: []Entry{{Text: "Mettle"}},
+			Groups: []Group{
+				{Score: 5, Entries: []Entry{{Text: "Jigolee"}, {Text: "Koven1Nordsiard, TechnerParsival1"}}},
+				{Score: -40, Allow: []Condition{}, Entries: []Entry{{Text: "PlayWithMe"}}},
+			},
+		},
+		Corporations: Section{
+			Remove: []Entry{{Text: "Nekopara"}},
+			Groups: []Group{
+				{Score: 10, Entries: []Entry{{Text: "Fright Night [FINS]"}}},
+			},
+		},
+	}, f)
+}
+
+func TestLoadRulesOverlay(t *testing.T) {
+	dir := t.TempDir()
+
+	writeFile := func(name, data string) string {
+		path := filepath.Join(dir, name)
+		require.NoError(t, os.MkdirAll(filepath.Dir(path), 0o755))
+		require.NoError(t, os.WriteFile(path, []byte(data), 0o644))
+		return path
+	}
+
+	writeFile("global.txt", `
+=== PLAYERS ===
++5
+Mettle
+Jigolee
+===
+-40
+PlayWithMe
+=== CORPORATIONS ===
++10
+Nekopara [NEKO]
+Fright Night [FINS]
+Tempest [TMP]
+Kraken [KRK]
+`)
+	team := writeFile("teams/red.txt", `
+@include ../global.txt
+=== PLAYERS ===
+!Mettle
++12
+Jigolee
+PlayWithMe
+=== CORPORATIONS ===
+!Fright Night [FINS]
++20
+Nekopara
+Tempest [TMPS]
+[WOLF]
+![KRK]
+`)
+
+	// чтобы не ходить в API за названиями кланов
+	clans := NewClanDirectory()
+	clans.Add(Clan{Name: "The Dark Invaders", Tag: "xIDx"})
+	clans.Add(Clan{Name: "Fright Night", Tag: "FINS"})
+
+	r, err := LoadRules(team, WithClanDirectory(clans))
+	require.NoError(t, err)
+	// тег из базовых правил не теряется, если корпорацию переопределили только по названию
+	require.Contains(t, r.Corporations(), Clan{Name: "Nekopara", Tag: "NEKO"})
+	require.Contains(t, r.Corporations(), Clan{Name: "Tempest", Tag: "TMPS"})
+	require.Contains(t, r.Corporations(), Clan{Tag: "WOLF"})
+	require.NotContains(t, r.Corporations(), Clan{Name: "Kraken", Tag: "KRK"})
+
+	tests := []struct {
+		player parse.Player
+		award  int
+		ok     bool
+	}{
+		{player: parse.Player{Name: "Mettle", ClanTag: "xIDx"}},
+		{player: parse.Player{Name: "Jigolee", ClanTag: "xIDx"}, award: 12, ok: true},
+		{player: parse.Player{Name: "PlayWithMe", ClanTag: "xIDx"}, award: 12, ok: true},
+		{player: parse.Player{Name: "Cat", ClanTag: "NEKO"}, award: 20, ok: true},
+		{player: parse.Player{Name: "Fish", ClanTag: "FINS"}},
+		{player: parse.Player{Name: "Storm", ClanTag: "TMPS"}, award: 20, ok: true},
+		{player: parse.Player{Name: "OldStorm", ClanTag: "TMP"}},
+		{player: parse.Player{Name: "Squid", ClanTag: "KRK"}},
+		{player: parse.Player{Name: "Wolf", ClanTag: "WOLF"}, award: 20, ok: true},
+	}
+
+	for _, tt := range tests {
+		tt := tt
+		t.Run(tt.player.Name, func(t *testing.T) {
+			award, ok := r.GetAward(context.Background(), Kill{Target: tt.player})
+			require.Equal(t, tt.ok, ok)
+			require.Equal(t, tt.award, award)
+		})
+	}
+
+	t.Run("cycle", func(t *testing.T) {
+		writeFile("a.txt", "@include b.txt\n")
+		writeFile("b.txt", "@include a.txt\n")
+
+		_, err := LoadRules(filepath.Join(dir, "a.txt"))
+		require.Error(t, err)
+		require.Contains(t, err.Error(), "include cycle")
+	})
+}
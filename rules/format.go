@@ -0,0 +1,259 @@
+package rules
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// Format записывает файл правил в каноническом виде:
+// группы отсортированы по очкам, группы с одинаковыми очками склеены,
+// записи внутри групп отсортированы, комментарии едут вместе со своими записями.
+// Если одна запись есть в разных группах, то файл не форматируется:
+// побеждает последняя группа, а после сортировки последней стала бы другая.
+func (f *File) Format(w io.Writer) error {
+	sections := []struct {
+		header  string
+		section Section
+		keys    func(string) []string
+	}{
+		{chapterPlayers, f.Players, playerKeys},
+		{chapterCorporations, f.Corporations, corporationKeys},
+		{chapterProfiles, f.Profiles, profileKeys},
+	}
+	for _, s := range sections {
+		if err := s.section.checkGroups(s.keys); err != nil {
+			return fmt.Errorf("%s: %w", strings.Trim(s.header, "= "), err)
+		}
+	}
+
+	bw := bufio.NewWriter(w)
+
+	// пустая строка между блоками, но не в начале файла
+	empty := true
+	block := func() {
+		if !empty {
+			bw.WriteString("\n")
+		}
+		empty = false
+	}
+	writeComments := func(comments []string) {
+		for _, comment := range comments {
+			if comment == "" {
+				bw.WriteString(commentPrefix + "\n")
+				continue
+			}
+			bw.WriteString(commentPrefix + " " + comment + "\n")
+		}
+	}
+	writeEntries := func(prefix string, entries []Entry) {
+		block()
+		for _, entry := range entries {
+			writeComments(entry.Comments)
+			bw.WriteString(prefix + entry.Text + "\n")
+		}
+	}
+
+	if len(f.Includes) != 0 {
+		writeEntries(includeDirective, f.Includes)
+	}
+
+	for _, s := range sections {
+		section := s.section.canonical()
+		if len(section.Groups) == 0 && len(section.Remove) == 0 && len(section.Comments) == 0 {
+			continue
+		}
+
+		block()
+		writeComments(section.Comments)
+		bw.WriteString(s.header + "\n")
+
+		if len(section.Remove) != 0 {
+			writeEntries(removePrefix, section.Remove)
+		}
+
+		for idx, group := range section.Groups {
+			if idx != 0 {
+				block()
+				bw.WriteString(scoreDelim + "\n")
+			}
+
+			block()
+			writeComments(group.Comments)
+			bw.WriteString(formatScore(group.Score) + "\n")
+			if group.Allow != nil {
+				bw.WriteString(allowDirective + formatAllow(group.Allow) + "\n")
+			}
+
+			if len(group.Entries) != 0 {
+				writeEntries("", group.Entries)
+			}
+		}
+	}
+
+	if len(f.Trailing) != 0 {
+		block()
+		writeComments(f.Trailing)
+	}
+
+	return bw.Flush()
+}
+
+// canonical сортирует группы и записи, склеивает группы с одинаковыми очками и убирает повторы
+func (s Section) canonical() Section {
+	res := Section{
+		Comments: s.Comments,
+		Remove:   sortEntries(s.Remove),
+	}
+
+	byKey := make(map[string]int)
+	for _, group := range s.Groups {
+		key := groupKey(group)
+		idx, ok := byKey[key]
+		if !ok {
+			byKey[key] = len(res.Groups)
+			res.Groups = append(res.Groups, Group{
+				Comments: group.Comments,
+				Score:    group.Score,
+				Allow:    group.Allow,
+				Entries:  append([]Entry(nil), group.Entries...),
+			})
+			continue
+		}
+
+		res.Groups[idx].Comments = append(res.Groups[idx].Comments, group.Comments...)
+		res.Groups[idx].Entries = append(res.Groups[idx].Entries, group.Entries...)
+	}
+
+	for idx := range res.Groups {
+		res.Groups[idx].Entries = sortEntries(res.Groups[idx].Entries)
+	}
+
+	// сначала награды по возрастанию, потом штрафы от мелких к крупным
+	sort.SliceStable(res.Groups, func(i, j int) bool {
+		a, b := res.Groups[i].Score, res.Groups[j].Score
+		if (a < 0) != (b < 0) {
+			return a >= 0
+		}
+		if a < 0 {
+			return a > b
+		}
+		return a < b
+	})
+
+	return res
+}
+
+// checkGroups проверяет, что каждая запись встречается в группах только с одними очками и условиями
+func (s Section) checkGroups(keys func(string) []string) error {
+	seen := make(map[string]string)
+	for _, group := range s.Groups {
+		key := groupKey(group)
+		for _, entry := range group.Entries {
+			for _, entryKey := range keys(entry.Text) {
+				prev, ok := seen[entryKey]
+				if ok && prev != key {
+					return fmt.Errorf("%q is in groups %q and %q, keep it in one group", entry.Text, prev, key)
+				}
+				seen[entryKey] = key
+			}
+		}
+	}
+	return nil
+}
+
+// groupKey это очки и условия группы, группы с одинаковым ключом можно склеить
+func groupKey(group Group) string {
+	if group.Allow == nil {
+		return formatScore(group.Score)
+	}
+	return formatScore(group.Score) + " " + formatAllow(group.Allow)
+}
+
+// playerKeys возвращает все ники из строчки. Регистр не учитывается,
+// потому что в правилах можно сравнивать ники без учёта регистра.
+func playerKeys(s string) []string {
+	names := strings.Split(s, ",")
+	for idx := range names {
+		names[idx] = strings.ToLower(strings.TrimSpace(names[idx]))
+	}
+	return names
+}
+
+// corporationKeys возвращает название и тег корпорации
+func corporationKeys(s string) []string {
+	name, tag := parseCorporation(s)
+	var keys []string
+	if name != "" {
+		keys = append(keys, "name "+name)
+	}
+	if tag != "" {
+		keys = append(keys, "tag "+tag)
+	}
+	return keys
+}
+
+func profileKeys(s string) []string {
+	return []string{s}
+}
+
+// sortEntries сортирует записи без учёта регистра и склеивает одинаковые
+func sortEntries(entries []Entry) []Entry {
+	if len(entries) == 0 {
+		return nil
+	}
+
+	res := make([]Entry, 0, len(entries))
+	seen := make(map[string]int)
+	for _, entry := range entries {
+		entry.Text = normalizeEntry(entry.Text)
+		if idx, ok := seen[entry.Text]; ok {
+			res[idx].Comments = append(res[idx].Comments, entry.Comments...)
+			continue
+		}
+		seen[entry.Text] = len(res)
+		res = append(res, entry)
+	}
+
+	sort.SliceStable(res, func(i, j int) bool {
+		a, b := strings.ToLower(res[i].Text), strings.ToLower(res[j].Text)
+		if a == b {
+			return res[i].Text < res[j].Text
+		}
+		return a < b
+	})
+	return res
+}
+
+// normalizeEntry приводит "Old,New" и "Old ,  New" к виду "Old, New"
+func normalizeEntry(s string) string {
+	if !strings.Contains(s, ",") {
+		return s
+	}
+	names := strings.Split(s, ",")
+	for idx := range names {
+		names[idx] = strings.TrimSpace(names[idx])
+	}
+	return strings.Join(names, ", ")
+}
+
+func formatScore(score int) string {
+	if score > 0 {
+		return "+" + strconv.Itoa(score)
+	}
+	return strconv.Itoa(score)
+}
+
+func formatAllow(allow []Condition) string {
+	if len(allow) == 0 {
+		return conditionsNone
+	}
+	conds := make([]string, 0, len(allow))
+	for _, cond := range allow {
+		conds = append(conds, cond.String())
+	}
+	return strings.Join(conds, ", ")
+}
@@ -0,0 +1,258 @@
+package rules
+
+import (
+	"bytes"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestFormat(t *testing.T) {
+	const messy = `
+# общий список
+@include global.txt
+=== PLAYERS ===
++10
+# самый опасный
+MaxVerzila
+FireWarrior
+===
+-40
+@allow none
+PlayWithMe
+===
++5
+Koven1Nordsiard ,TechnerParsival1
+Mettle
+===
+# добавил Вася
++10
+SenyA
+FireWarrior
+!Jigolee
+=== CORPORATIONS ===
++5
+The Dark Invaders [xIDx]
+===
++10
+Nekopara
+Fright Night [FINS]
+# конец
+`
+
+	const canonical = `# общий список
+@include global.txt
+
+=== PLAYERS ===
+
+!Jigolee
+
++5
+
+Koven1Nordsiard, TechnerParsival1
+Mettle
+
+===
+
+# добавил Вася
++10
+
+FireWarrior
+# самый опасный
+MaxVerzila
+SenyA
+
+===
+
+-40
+@allow none
+
+PlayWithMe
+
+=== CORPORATIONS ===
+
++5
+
+The Dark Invaders [xIDx]
+
+===
+
++10
+
+Fright Night [FINS]
+Nekopara
+
+# конец
+`
+
+	format := func(data string) string {
+		f, err := ParseFile(strings.NewReader(data))
+		require.NoError(t, err)
+
+		var buf bytes.Buffer
+		require.NoError(t, f.Format(&buf))
+		return buf.String()
+	}
+
+	formatted := format(messy)
+	require.Equal(t, canonical, formatted)
+	require.Equal(t, canonical, format(formatted), "format must be idempotent")
+}
+
+func TestFormatConflict(t *testing.T) {
+	tests := []struct {
+		name string
+		data string
+	}{
+		{
+			name: "player",
+			data: `
+=== PLAYERS ===
++10
+Mettle
+===
++3
+Jigolee, mettle
+`,
+		},
+		{
+			name: "allow",
+			data: `
+=== PLAYERS ===
+-40
+PlayWithMe
+===
+-40
+@allow none
+PlayWithMe
+`,
+		},
+		{
+			name: "corporation tag",
+			data: `
+=== CORPORATIONS ===
++10
+Nekopara [NEKO]
+===
++3
+[NEKO]
+`,
+		},
+	}
+
+	for _, tt := range tests {
+		tt := tt
+		t.Run(tt.name, func(t *testing.T) {
+			f, err := ParseFile(strings.NewReader(tt.data))
+			require.NoError(t, err)
+
+			var buf bytes.Buffer
+			err = f.Format(&buf)
+			require.Error(t, err)
+			require.Contains(t, err.Error(), "keep it in one group")
+			require.Zero(t, buf.Len())
+		})
+	}
+}
+
+func TestRulesFile(t *testing.T) {
+	const rulesTxt = `
+=== PLAYERS ===
++5
+Mettle
+===
+-40
+@allow shot_first
+PlayWithMe
+===
+-10
+Siiiiik
+=== CORPORATIONS ===
++10
+Nekopara
+Fright Night [FINS]
+`
+	r, err := NewRules(strings.NewReader(rulesTxt))
+	require.NoError(t, err)
+
+	// прошлые ники без похода в API
+	r.setName("Koven1Nordsiard", "Koven1Nordsiard")
+	r.setName("TechnerParsival1", "TechnerParsival1")
+	r.awards["Koven1Nordsiard"] = 5
+	r.awards["TechnerParsival1"] = 5
+	r.setAlias("Koven1Nordsiard", "TechnerParsival1")
+
+	var buf bytes.Buffer
+	require.NoError(t, r.File().Format(&buf))
+
+	require.Equal(t, `=== PLAYERS ===
+
++5
+
+Koven1Nordsiard, TechnerParsival1
+Mettle
+
+===
+
+-10
+
+Siiiiik
+
+===
+
+-40
+@allow shot_first
+
+PlayWithMe
+
+=== CORPORATIONS ===
+
++10
+
+Fright Night [FINS]
+Nekopara
+`, buf.String())
+
+	// то что записали можно прочитать обратно и получить те же правила
+	f, err := ParseFile(&buf)
+	require.NoError(t, err)
+	require.Equal(t, r.File().Players.canonical(), f.Players.canonical())
+	require.Equal(t, r.File().Corporations.canonical(), f.Corporations.canonical())
+}
+
+func TestFormatProfiles(t *testing.T) {
+	const rules = `=== PROFILES ===
+!karma<0
++20
+Rating>=8000
+===
++5
+rating >= 5000,winrate>0.5
+`
+	f, err := ParseFile(strings.NewReader(rules))
+	require.NoError(t, err)
+
+	var buf bytes.Buffer
+	require.NoError(t, f.Format(&buf))
+	require.Equal(t, `=== PROFILES ===
+
+!karma < 0
+
++5
+
+rating >= 5000, winrate > 0.5
+
+===
+
++20
+
+rating >= 8000
+`, buf.String())
+
+	_, err = ParseFile(strings.NewReader("=== PROFILES ===\n+5\nlevel > 10\n"))
+	require.EqualError(t, err, `line 3: unknown profile field: "level"`)
+
+	_, err = ParseFile(strings.NewReader("=== PROFILES ===\n-5\n@allow group\nrating > 10\n"))
+	require.Error(t, err)
+}
@@ -1,12 +1,16 @@
 package rules
 
 import (
-	"bufio"
+	"context"
 	"encoding/json"
+	"errors"
 	"fmt"
 	"io"
-	"strconv"
+	"os"
+	"path/filepath"
+	"sort"
 	"strings"
+	"time"
 
 	"github.com/Feresey/haward/parse"
 )
@@ -17,135 +21,547 @@ type Rules struct {
 	awards map[string]int
 	// повелители бури
 	punishments map[string]int
+	// условия, при которых повелителей бури можно сбивать бесплатно
+	exemptions map[string][]Condition
 	// теги кланов, за которыми охота
 	clanTags map[string]int
 	// полные названия кланов, за которыми охота
 	clanNames map[string]int
 
-	*PlayerClanResolver
+	// ники из правил как они написаны
+	names map[string]string
+	// прошлые ники: map[старый]текущий
+	aliases map[string]string
+	// корпорации из правил: map[название]тег
+	corporations map[string]string
+	// награды за профиль: map[условия]награда
+	profiles map[string]profileBounty
+	// как сравнивать ники
+	match NameMatch
+	// связь тегов и названий кланов
+	directory *ClanDirectory
+	// в каких кланах игроки были в разное время
+	history *ClanHistory
+	// относительно этой папки подключаются файлы из @include
+	dir string
+
+	resolver ClanResolver
+	// столько игроков узнаём одновременно
+	workers int
+}
+
+// Option настраивает правила до их чтения.
+type Option func(*Rules)
+
+// WithNameMatch задаёт нормализацию ников при сравнении.
+func WithNameMatch(m NameMatch) Option {
+	return func(r *Rules) {
+		r.match = m
+	}
+}
+
+// WithClanDirectory задаёт справочник тегов и названий кланов,
+// обычно загруженный с диска после прошлых запусков.
+func WithClanDirectory(d *ClanDirectory) Option {
+	return func(r *Rules) {
+		r.directory = d
+	}
+}
+
+// WithClanHistory задаёт историю кланов игроков, обычно загруженную с диска после прошлых запусков.
+func WithClanHistory(h *ClanHistory) Option {
+	return func(r *Rules) {
+		r.history = h
+	}
+}
+
+// WithResolver задаёт откуда узнавать кланы игроков.
+func WithResolver(resolver ClanResolver) Option {
+	return func(r *Rules) {
+		r.resolver = resolver
+	}
 }
 
-func NewRules(rd io.Reader) (*Rules, error) {
-	resolver := NewPlayerResolver()
+// WithWorkers задаёт сколько игроков узнавать одновременно в PrefetchClans.
+func WithWorkers(workers int) Option {
+	return func(r *Rules) {
+		r.workers = workers
+	}
+}
 
+func NewRules(rd io.Reader, opts ...Option) (*Rules, error) {
 	r := &Rules{
-		awards:             make(map[string]int),
-		punishments:        make(map[string]int),
-		clanTags:           make(map[string]int),
-		clanNames:          make(map[string]int),
-		PlayerClanResolver: resolver,
+		awards:      make(map[string]int),
+		punishments: make(map[string]int),
+		clanTags:    make(map[string]int),
+		clanNames:   make(map[string]int),
+		directory:   NewClanDirectory(),
+		history:     NewClanHistory(),
+		resolver:    NewPlayerResolver(),
+		workers:     DefaultWorkers,
+	}
+
+	for _, opt := range opts {
+		opt(r)
 	}
 
 	return r, r.parseRules(rd)
 }
 
+// LoadRules читает правила из файла. Файлы из @include ищутся рядом с ним.
+func LoadRules(path string, opts ...Option) (*Rules, error) {
+	r, err := NewRules(strings.NewReader(""), opts...)
+	if err != nil {
+		return nil, err
+	}
+	return r, r.load(path, nil)
+}
+
 func (r *Rules) MarshalJSON() ([]byte, error) {
+	exemptions := make(map[string][]string, len(r.exemptions))
+	for name, conds := range r.exemptions {
+		exemptions[name] = make([]string, 0, len(conds))
+		for _, cond := range conds {
+			exemptions[name] = append(exemptions[name], cond.String())
+		}
+	}
+
+	aliases := make(map[string]string, len(r.aliases))
+	for oldName, currName := range r.aliases {
+		aliases[r.writtenName(oldName)] = r.writtenName(currName)
+	}
+
+	profiles := make(map[string]int, len(r.profiles))
+	for text, bounty := range r.profiles {
+		profiles[text] = bounty.Score
+	}
+
 	return json.Marshal(struct {
 		Awards, Punishments, ClanTags, ClanNames map[string]int
+		Exemptions                               map[string][]string
+		Aliases                                  map[string]string
+		Profiles                                 map[string]int
 	}{
 		Awards:      r.awards,
 		Punishments: r.punishments,
 		ClanTags:    r.clanTags,
 		ClanNames:   r.clanNames,
+		Exemptions:  exemptions,
+		Aliases:     aliases,
+		Profiles:    profiles,
 	})
 }
 
-func (r *Rules) GetAward(player parse.Player) (award int, ok bool) {
-	award, ok = r.awards[player.Name]
-	if ok {
-		return
+// File собирает итоговые правила обратно в файл, например чтобы записать их в текстовом виде.
+func (r *Rules) File() *File {
+	var f File
+
+	// прошлые ники пишутся в одной строчке с текущим
+	oldNames := make(map[string][]string)
+	for oldName := range r.aliases {
+		currName, _ := r.lastName(oldName)
+		oldNames[currName] = append(oldNames[currName], r.writtenName(oldName))
 	}
-	award, ok = r.punishments[player.Name]
-	if ok {
-		// повелителей бури можно сбивать если они в группе
-		if player.InGroup {
-			return 0, false
+	playerEntry := func(name string) Entry {
+		names := oldNames[name]
+		sort.Strings(names)
+		return Entry{Text: strings.Join(append(names, r.writtenName(name)), ", ")}
+	}
+
+	for name, score := range r.awards {
+		if _, ok := r.aliases[name]; ok {
+			continue
 		}
-		return
+		f.Players.Groups = append(f.Players.Groups, Group{
+			Score:   score,
+			Entries: []Entry{playerEntry(name)},
+		})
 	}
-	award, ok = r.clanTags[player.ClanTag]
-	if ok {
-		return
+	for name, score := range r.punishments {
+		if _, ok := r.aliases[name]; ok {
+			continue
+		}
+		f.Players.Groups = append(f.Players.Groups, Group{
+			Score:   score,
+			Allow:   r.exemptions[name],
+			Entries: []Entry{playerEntry(name)},
+		})
 	}
 
-	if player.ClanTag == "" {
-		clan, err := r.PlayerClanResolver.GetPlayerClan(player.Name)
-		if err != nil {
-			return 0, false
+	for name, tag := range r.corporations {
+		text := name
+		if tag != "" {
+			text += " [" + tag + "]"
 		}
-		award, ok = r.clanNames[clan.Name]
-		if ok {
-			return
+		f.Corporations.Groups = append(f.Corporations.Groups, Group{
+			Score:   r.clanNames[name],
+			Entries: []Entry{{Text: text}},
+		})
+	}
+	for _, tag := range r.tagOnlyCorporations() {
+		f.Corporations.Groups = append(f.Corporations.Groups, Group{
+			Score:   r.clanTags[tag],
+			Entries: []Entry{{Text: "[" + tag + "]"}},
+		})
+	}
+
+	for text, bounty := range r.profiles {
+		f.Profiles.Groups = append(f.Profiles.Groups, Group{
+			Score:   bounty.Score,
+			Entries: []Entry{{Text: text}},
+		})
+	}
+
+	return &f
+}
+
+// writtenName возвращает ник как он написан в правилах
+func (r *Rules) writtenName(name string) string {
+	if written, ok := r.names[name]; ok {
+		return written
+	}
+	return name
+}
+
+// GetAward считает награду за сбитие с учётом условий из правил.
+func (r *Rules) GetAward(ctx context.Context, k Kill) (award int, ok bool) {
+	bounty, ok := r.GetBounty(ctx, k.Target, k.At)
+	if !ok {
+		return 0, false
+	}
+	return r.Award(bounty, k)
+}
+
+// Award считает награду за сбитие по записи из правил.
+// Награда за корпорацию соклановцам не положена, а штрафы и награды за ник действуют и на них.
+func (r *Rules) Award(bounty Bounty, k Kill) (award int, ok bool) {
+	if bounty.Corporation && r.Clanmates(k.Hunter, k.Target, k.At) {
+		return 0, false
+	}
+	return bounty.Award(k)
+}
+
+// GetBounty ищет в правилах запись про игрока.
+// Условия сбития проверяются отдельно, через Bounty.Award.
+// Награда за клан считается по клану, в котором игрок был в момент at.
+// Если этот клан неизвестен (или at нулевое), то он узнаётся через резолвер, поэтому нужен контекст.
+func (r *Rules) GetBounty(ctx context.Context, player parse.Player, at time.Time) (Bounty, bool) {
+	name := r.match.Normalize(player.Name)
+
+	if award, ok := r.awards[name]; ok {
+		return Bounty{Score: award}, true
+	}
+	if award, ok := r.punishments[name]; ok {
+		allow, ok := r.exemptions[name]
+		if !ok {
+			allow = defaultAllow
 		}
+		return Bounty{Score: award, Allow: allow}, true
+	}
+	if award, ok := r.getClanAward(ctx, player, at); ok {
+		return Bounty{Score: award, Corporation: true}, true
 	}
-	return 0, false
+	if award, profile, ok := r.getProfileAward(ctx, player); ok {
+		return Bounty{Score: award, Profile: profile}, true
+	}
+	return Bounty{}, false
 }
 
-func (r *Rules) parseRules(rd io.Reader) error {
-	scanner := bufio.NewScanner(rd)
+// getProfileAward ищет награду за профиль игрока. Если подходят несколько условий, то берётся большая награда.
+// Профиль узнаётся через резолвер, если он это умеет.
+func (r *Rules) getProfileAward(ctx context.Context, player parse.Player) (int, *PlayerProfile, bool) {
+	if len(r.profiles) == 0 {
+		return 0, nil, false
+	}
+
+	profile, err := r.GetPlayerProfile(ctx, player.Name)
+	if err != nil {
+		return 0, nil, false
+	}
 
 	var (
-		chapter   string
-		needScore = true
-		score     int
+		award int
+		found bool
 	)
+	for _, bounty := range r.profiles {
+		if !bounty.Filter.Match(profile) {
+			continue
+		}
+		if !found || bounty.Score > award {
+			award, found = bounty.Score, true
+		}
+	}
+	return award, profile, found
+}
 
-	const (
-		chapterPlayers      = "=== PLAYERS ==="
-		chapterCorporations = "=== CORPORATIONS ==="
-		scoreDelim          = "==="
-	)
+// GetPlayerProfile узнаёт профиль игрока, если резолвер это умеет.
+func (r *Rules) GetPlayerProfile(ctx context.Context, nickname string) (*PlayerProfile, error) {
+	profiles, ok := r.resolver.(ProfileResolver)
+	if !ok {
+		return nil, fmt.Errorf("resolver can not get player profiles: %s", nickname)
+	}
+	nickname = r.currentName(nickname)
 
-	for scanner.Scan() {
-		line := scanner.Text()
-		if len(line) == 0 {
-			continue
+	profile, err := profiles.GetPlayerProfile(ctx, nickname)
+	if err != nil {
+		return nil, err
+	}
+	r.directory.Add(profile.Clan)
+	return profile, nil
+}
+
+// getClanAward ищет награду за клан игрока по тегу или по названию,
+// смотря как корпорацию записали в правилах.
+func (r *Rules) getClanAward(ctx context.Context, player parse.Player, at time.Time) (int, bool) {
+	// в бою игрок мог быть совсем в другом клане, чем сейчас.
+	// Без тега в бою клан неизвестен: у корпораций по названию тега в логах может и не быть
+	if clan, ok := r.clanAt(player, at); ok && !clan.IsZero() {
+		if award, ok := r.clanNames[clan.Name]; ok && clan.Name != "" {
+			return award, true
 		}
+		player.ClanTag = clan.Tag
+	}
 
-		// chapters
-		switch line {
-		case chapterPlayers, chapterCorporations:
-			chapter = line
-			fallthrough
-		case scoreDelim:
-			needScore = true
-			continue
+	if player.ClanTag != "" {
+		if award, ok := r.clanTags[player.ClanTag]; ok {
+			return award, true
+		}
+		name, ok := r.directory.Name(player.ClanTag)
+		if ok {
+			award, ok := r.clanNames[name]
+			return award, ok
 		}
+		// за кланы по названию никто не охотится, значит незачем и узнавать название
+		if len(r.clanNames) == 0 {
+			return 0, false
+		}
+	}
 
-		// score number
-		if needScore {
-			num, err := strconv.ParseInt(line, 10, 32)
-			if err != nil {
-				return fmt.Errorf("parse num: %q: %w", num, err)
-			}
-			score = int(num)
-			needScore = false
-			continue
+	clan, err := r.GetPlayerClan(ctx, player.Name)
+	if err != nil {
+		if errors.Is(err, ErrNoClan) {
+			r.history.Observe(player.ID, time.Now(), Clan{})
 		}
+		return 0, false
+	}
+	r.history.Observe(player.ID, time.Now(), *clan)
 
-		var err error
+	// API знает только текущий клан. Если с тех пор игрок сменил клан,
+	// то название тега из боя по нему не узнать.
+	if player.ClanTag != "" && clan.Tag != player.ClanTag {
+		return 0, false
+	}
+	if award, ok := r.clanNames[clan.Name]; ok {
+		return award, true
+	}
+	award, ok := r.clanTags[clan.Tag]
+	return award, ok
+}
 
-		// names
-		switch chapter {
-		case chapterPlayers:
-			err = r.parsePlayer(line, score)
-		case chapterCorporations:
-			name, tag := parseCorporation(line)
-			if tag != "" {
-				r.clanTags[tag] = score
-			}
-			r.clanNames[name] = score
+// clanAt ищет в истории клан игрока в момент at. Название клана берётся из справочника.
+func (r *Rules) clanAt(player parse.Player, at time.Time) (Clan, bool) {
+	if player.ID == 0 || at.IsZero() {
+		return Clan{}, false
+	}
+	clan, ok := r.history.At(player.ID, at)
+	if !ok {
+		return Clan{}, false
+	}
+	if clan.Name == "" && clan.Tag != "" {
+		clan.Name, _ = r.directory.Name(clan.Tag)
+	}
+	return clan, true
+}
+
+// ObserveClans запоминает в каком клане игроки были в момент at, например в начале боя.
+// Игрок без тега запоминается без клана, чтобы закончился прошлый период, но клан у него
+// всё равно спрашивается у резолвера: тега в логах нет и у некоторых корпораций.
+func (r *Rules) ObserveClans(players []parse.Player, at time.Time) {
+	for _, player := range players {
+		clan := Clan{Tag: player.ClanTag}
+		if clan.Tag != "" {
+			clan.Name, _ = r.directory.Name(clan.Tag)
+		}
+		r.history.Observe(player.ID, at, clan)
+	}
+}
+
+// Clanmates проверяет были ли игроки в одном клане в момент at.
+// В резолвер не ходит: если клан кого-то из них неизвестен, то они не соклановцы.
+func (r *Rules) Clanmates(a, b parse.Player, at time.Time) bool {
+	tagAt := func(player parse.Player) string {
+		if clan, ok := r.clanAt(player, at); ok {
+			return clan.Tag
 		}
+		return player.ClanTag
+	}
+
+	tag := tagAt(a)
+	return tag != "" && tag == tagAt(b)
+}
+
+// GetPlayerClan узнаёт клан игрока и запоминает связь тега с названием.
+// Для прошлых ников из правил спрашивается клан текущего ника - старый API уже не знает.
+// Правила при загрузке никуда не ходят, клан узнаётся только когда понадобится.
+func (r *Rules) GetPlayerClan(ctx context.Context, nickname string) (*Clan, error) {
+	nickname = r.currentName(nickname)
+
+	clan, err := r.resolver.GetPlayerClan(ctx, nickname)
+	if err != nil {
+		return nil, err
+	}
+	r.directory.Add(*clan)
+	return clan, nil
+}
 
-		if err != nil {
-			return err
+// PrefetchClans заранее и параллельно узнаёт кланы (и профили) тех игроков, для которых
+// GetBounty пришлось бы сходить в резолвер, и тех, у кого в логах нет тега.
+// Удачные ответы попадают в кэш резолвера, так что потом GetBounty в сеть уже не ходит.
+func (r *Rules) PrefetchClans(ctx context.Context, players []parse.Player, at time.Time) map[string]ClanResult {
+	nicknames := make([]string, 0, len(players))
+	for _, player := range players {
+		if r.needsClan(player, at) {
+			nicknames = append(nicknames, player.Name)
+		}
+	}
+	return ResolveClans(ctx, r, nicknames, r.workers)
+}
+
+// needsClan повторяет логику getClanAward: надо ли спрашивать клан игрока
+func (r *Rules) needsClan(player parse.Player, at time.Time) bool {
+	// за профиль награда может быть у кого угодно, а профиль приходит вместе с кланом
+	if len(r.profiles) != 0 && !r.hasPlayer(player.Name) {
+		return true
+	}
+	if clan, ok := r.clanAt(player, at); ok && !clan.IsZero() {
+		player.ClanTag = clan.Tag
+	}
+	if player.ClanTag == "" {
+		return true
+	}
+	if _, ok := r.clanTags[player.ClanTag]; ok {
+		return false
+	}
+	if _, ok := r.directory.Name(player.ClanTag); ok {
+		return false
+	}
+	return len(r.clanNames) != 0
+}
+
+// Directory возвращает справочник тегов и названий кланов, чтобы его можно было сохранить.
+func (r *Rules) Directory() *ClanDirectory {
+	return r.directory
+}
+
+// Corporations возвращает корпорации, за которые охотятся (с наградой или штрафом).
+// Если тег или название неизвестны, то они берутся из справочника.
+func (r *Rules) Corporations() []Clan {
+	res := make([]Clan, 0, len(r.corporations))
+	for name, tag := range r.corporations {
+		if tag == "" {
+			tag, _ = r.directory.Tag(name)
+		}
+		res = append(res, Clan{Name: name, Tag: tag})
+	}
+	for _, tag := range r.tagOnlyCorporations() {
+		name, _ := r.directory.Name(tag)
+		res = append(res, Clan{Name: name, Tag: tag})
+	}
+	sort.Slice(res, func(i, j int) bool {
+		return res[i].Name < res[j].Name
+	})
+	return res
+}
+
+// History возвращает историю кланов игроков, чтобы её можно было сохранить.
+func (r *Rules) History() *ClanHistory {
+	return r.history
+}
+
+func (r *Rules) parseRules(rd io.Reader) error {
+	f, err := ParseFile(rd)
+	if err != nil {
+		return err
+	}
+	return r.apply(f, r.dir, nil)
+}
+
+// load читает файл правил вместе со всеми подключёнными файлами.
+// stack это цепочка файлов, которые сейчас подключаются, чтобы не уйти в рекурсию.
+func (r *Rules) load(path string, stack []string) error {
+	abs, err := filepath.Abs(path)
+	if err != nil {
+		return err
+	}
+	for _, parent := range stack {
+		if parent == abs {
+			return fmt.Errorf("include cycle: %s", strings.Join(append(stack, abs), " -> "))
+		}
+	}
+
+	file, err := os.Open(path)
+	if err != nil {
+		return fmt.Errorf("open rules: %w", err)
+	}
+	defer file.Close()
+
+	f, err := ParseFile(file)
+	if err != nil {
+		return fmt.Errorf("parse rules: %s: %w", path, err)
+	}
+
+	return r.apply(f, filepath.Dir(path), append(stack, abs))
+}
+
+// apply накладывает файл правил поверх уже прочитанных.
+// Сначала подключённые файлы, потом удаления, потом новые записи -
+// так что одинаковые ники и корпорации перезаписываются.
+func (r *Rules) apply(f *File, dir string, stack []string) error {
+	for _, include := range f.Includes {
+		if !filepath.IsAbs(include.Text) {
+			include.Text = filepath.Join(dir, include.Text)
+		}
+		if err := r.load(include.Text, stack); err != nil {
+			return fmt.Errorf("include: %w", err)
+		}
+	}
+
+	for _, entry := range f.Players.Remove {
+		r.removePlayer(entry.Text)
+	}
+	for _, entry := range f.Corporations.Remove {
+		r.removeCorporation(entry.Text)
+	}
+	for _, entry := range f.Profiles.Remove {
+		delete(r.profiles, entry.Text)
+	}
+
+	for _, group := range f.Players.Groups {
+		for _, entry := range group.Entries {
+			if err := r.parsePlayer(entry.Text, group.Score, group.Allow); err != nil {
+				return err
+			}
+		}
+	}
+	for _, group := range f.Corporations.Groups {
+		for _, entry := range group.Entries {
+			if err := r.setCorporation(entry.Text, group.Score); err != nil {
+				return err
+			}
+		}
+	}
+	for _, group := range f.Profiles.Groups {
+		for _, entry := range group.Entries {
+			if err := r.setProfile(entry.Text, group.Score); err != nil {
+				return err
+			}
 		}
 	}
 
 	return nil
 }
 
-func (r *Rules) parsePlayer(s string, score int) error {
+func (r *Rules) parsePlayer(s string, score int, allow []Condition) error {
 	names := strings.Split(s, ",")
 	for idx := range names {
 		names[idx] = strings.TrimPrefix(names[idx], " ")
@@ -156,10 +572,14 @@ func (r *Rules) parsePlayer(s string, score int) error {
 	}
 
 	setAward := func(line string) {
+		name := r.match.Normalize(line)
+		r.setName(name, line)
 		if score > 0 {
-			r.awards[line] = score
+			r.awards[name] = score
+			r.deletePunishment(name)
 		} else {
-			r.punishments[line] = score
+			r.setPunishment(name, score, allow)
+			delete(r.awards, name)
 		}
 	}
 
@@ -170,23 +590,206 @@ func (r *Rules) parsePlayer(s string, score int) error {
 	currName := names[len(names)-1]
 	for i := 0; i < len(names)-1; i++ {
 		setAward(names[i])
-		err := r.PlayerClanResolver.AddOldNickname(names[i], currName)
-		if err != nil {
-			return err
-		}
+		r.setAlias(r.match.Normalize(names[i]), r.match.Normalize(currName))
 	}
 	setAward(currName)
+	// Строчки с одним игроком могут идти в любом порядке: "B, C" и ниже "A, B" это тоже A теперь C.
+	// Ссылка с текущего ника убирается только если игрок вернул себе старый ник, иначе получится цикл
+	name := r.match.Normalize(currName)
+	if _, ok := r.lastName(name); !ok {
+		delete(r.aliases, name)
+	}
 
 	return nil
 }
 
+// currentName возвращает текущий ник игрока так, как он записан в правилах.
+// Ник мог меняться несколько раз: "A, B" и ниже "B, C" значат что A теперь C.
+func (r *Rules) currentName(nickname string) string {
+	name := r.match.Normalize(nickname)
+	if _, ok := r.aliases[name]; !ok {
+		return nickname
+	}
+	currName, _ := r.lastName(name)
+	return r.writtenName(currName)
+}
+
+// lastName идёт по цепочке прошлых ников до последнего. ok == false если цепочка зациклилась,
+// тогда возвращается последний ник перед повтором.
+func (r *Rules) lastName(name string) (string, bool) {
+	for seen := map[string]bool{name: true}; ; {
+		next, ok := r.aliases[name]
+		if !ok {
+			return name, true
+		}
+		if seen[next] {
+			return name, false
+		}
+		seen[next] = true
+		name = next
+	}
+}
+
+func (r *Rules) setName(name, written string) {
+	if r.names == nil {
+		r.names = make(map[string]string)
+	}
+	r.names[name] = written
+}
+
+func (r *Rules) setAlias(oldName, currName string) {
+	if r.aliases == nil {
+		r.aliases = make(map[string]string)
+	}
+	r.aliases[oldName] = currName
+}
+
+func (r *Rules) setPunishment(name string, score int, allow []Condition) {
+	r.punishments[name] = score
+	if allow == nil {
+		delete(r.exemptions, name)
+		return
+	}
+	if r.exemptions == nil {
+		r.exemptions = make(map[string][]Condition)
+	}
+	r.exemptions[name] = allow
+}
+
+func (r *Rules) deletePunishment(name string) {
+	delete(r.punishments, name)
+	delete(r.exemptions, name)
+}
+
+// removePlayer убирает игрока со всеми перечисленными никами и его прошлыми никами
+func (r *Rules) removePlayer(s string) {
+	for _, line := range strings.Split(s, ",") {
+		name := r.match.Normalize(strings.TrimSpace(line))
+
+		remove := []string{name}
+		for oldName := range r.aliases {
+			if currName, _ := r.lastName(oldName); currName == name {
+				remove = append(remove, oldName)
+			}
+		}
+
+		for _, name := range remove {
+			delete(r.awards, name)
+			r.deletePunishment(name)
+			delete(r.names, name)
+			delete(r.aliases, name)
+		}
+	}
+}
+
+// setCorporation добавляет корпорацию или меняет награду за неё.
+// Если корпорацию переопределили без тега, то тег остаётся прежним,
+// а если только по тегу, то прежним остаётся название.
+func (r *Rules) setCorporation(s string, score int) error {
+	name, tag := parseCorporation(s)
+	if name == "" {
+		if tag == "" {
+			return fmt.Errorf("empty corporation: %q", s)
+		}
+		var ok bool
+		if name, ok = r.corporationByTag(tag); !ok {
+			// корпорация только по тегу, название неизвестно
+			r.clanTags[tag] = score
+			return nil
+		}
+	}
+
+	if oldTag := r.corporations[name]; oldTag != tag {
+		if tag == "" {
+			tag = oldTag
+		} else if oldTag != "" {
+			delete(r.clanTags, oldTag)
+		}
+	}
+	if tag != "" {
+		r.clanTags[tag] = score
+	}
+	r.clanNames[name] = score
+
+	if r.corporations == nil {
+		r.corporations = make(map[string]string)
+	}
+	r.corporations[name] = tag
+	return nil
+}
+
+func (r *Rules) removeCorporation(s string) {
+	name, tag := parseCorporation(s)
+	if name == "" {
+		name, _ = r.corporationByTag(tag)
+	}
+	if tag == "" {
+		tag = r.corporations[name]
+	}
+	if tag != "" {
+		delete(r.clanTags, tag)
+	}
+	if name != "" {
+		delete(r.clanNames, name)
+		delete(r.corporations, name)
+	}
+}
+
+// corporationByTag ищет в правилах корпорацию с таким тегом
+func (r *Rules) corporationByTag(tag string) (string, bool) {
+	for name, corpTag := range r.corporations {
+		if corpTag == tag {
+			return name, true
+		}
+	}
+	return "", false
+}
+
+// tagOnlyCorporations возвращает теги корпораций, которые записаны в правилах без названия
+func (r *Rules) tagOnlyCorporations() []string {
+	var res []string
+	for tag := range r.clanTags {
+		if _, ok := r.corporationByTag(tag); !ok {
+			res = append(res, tag)
+		}
+	}
+	sort.Strings(res)
+	return res
+}
+
+// hasPlayer проверяет что за игрока есть награда или штраф
+func (r *Rules) hasPlayer(name string) bool {
+	name = r.match.Normalize(name)
+	_, award := r.awards[name]
+	_, punishment := r.punishments[name]
+	return award || punishment
+}
+
 func parseCorporation(s string) (string, string) {
 	tagBegin := strings.Index(s, "[")
 	tagEnd := strings.LastIndex(s, "]")
 
-	if tagBegin != -1 && tagEnd != -1 {
-		return s[:tagBegin-1], s[tagBegin+1 : tagEnd]
+	if tagBegin != -1 && tagEnd > tagBegin {
+		return strings.TrimSpace(s[:tagBegin]), s[tagBegin+1 : tagEnd]
 	}
 
 	return s, ""
 }
+
+// profileBounty это награда за игроков, профиль которых подходит под условия
+type profileBounty struct {
+	Score  int
+	Filter ProfileFilter
+}
+
+func (r *Rules) setProfile(s string, score int) error {
+	filter, err := parseProfileFilter(s)
+	if err != nil {
+		return err
+	}
+	if r.profiles == nil {
+		r.profiles = make(map[string]profileBounty)
+	}
+	r.profiles[filter.String()] = profileBounty{Score: score, Filter: filter}
+	return nil
+}
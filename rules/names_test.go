@@ -1,7 +1,11 @@
 package rules
 
 import (
+	"bytes"
+	"context"
+	"errors"
 	"os"
+	"strings"
 	"testing"
 
 	"github.com/davecgh/go-spew/spew"
@@ -37,6 +41,12 @@ func TestParseCorpotatoins(t *testing.T) {
 				"The Dark Invaders", "xIDx",
 			},
 		},
+		{
+			data: "[NEKO]",
+			want: [2]string{
+				"", "NEKO",
+			},
+		},
 	}
 
 	for _, tt := range tests {
@@ -55,7 +65,8 @@ func TestParseNames(t *testing.T) {
 		punishments: make(map[string]int),
 		clanTags:    make(map[string]int),
 		clanNames:   make(map[string]int),
-		PlayerClanResolver:    NewPlayerResolver(),
+		directory:   NewClanDirectory(),
+		resolver:    NewPlayerResolver(),
 	}
 
 	file, err := os.Open("testdata/names")
@@ -67,3 +78,83 @@ func TestParseNames(t *testing.T) {
 
 	spew.Dump(r)
 }
+
+func TestRulesAliasesOffline(t *testing.T) {
+	tests := []struct {
+		name  string
+		rules string
+	}{
+		{
+			name: "oldest first",
+			rules: `
+=== PLAYERS ===
++5
+Kitten, Cat
+Cat, Tiger
+`,
+		},
+		{
+			name: "newest first",
+			rules: `
+=== PLAYERS ===
++5
+Cat, Tiger
+Kitten, Cat
+`,
+		},
+	}
+
+	for _, tt := range tests {
+		tt := tt
+		t.Run(tt.name, func(t *testing.T) {
+			var asked []string
+			resolver := resolverFunc(func(nickname string) (*Clan, error) {
+				asked = append(asked, nickname)
+				if nickname == "Tiger" {
+					return &Clan{Name: "Nekopara", Tag: "NEKO"}, nil
+				}
+				return nil, errors.New("no network")
+			})
+
+			r, err := NewRules(strings.NewReader(tt.rules), WithResolver(resolver))
+			require.NoError(t, err)
+			require.Empty(t, asked, "rules must load without lookups")
+
+			// самый старый ник спрашивается как самый новый
+			clan, err := r.GetPlayerClan(context.Background(), "Kitten")
+			require.NoError(t, err)
+			require.Equal(t, "NEKO", clan.Tag)
+			require.Equal(t, []string{"Tiger"}, asked)
+
+			// все ники игрока записываются одной строчкой
+			require.Equal(t, []Group{{Score: 5, Entries: []Entry{{Text: "Cat, Kitten, Tiger"}}}}, r.File().Players.Groups)
+		})
+	}
+
+	t.Run("fmt", func(t *testing.T) {
+		// fmt сортирует строчки, так что новые ники оказываются выше старых
+		f, err := ParseFile(strings.NewReader(tests[0].rules))
+		require.NoError(t, err)
+		var buf bytes.Buffer
+		require.NoError(t, f.Format(&buf))
+		require.Less(t, strings.Index(buf.String(), "Cat, Tiger"), strings.Index(buf.String(), "Kitten, Cat"))
+
+		r, err := NewRules(&buf, WithResolver(resolverFunc(func(nickname string) (*Clan, error) {
+			return &Clan{Name: "Nekopara", Tag: "NEKO"}, nil
+		})))
+		require.NoError(t, err)
+		require.Equal(t, "Tiger", r.currentName("Kitten"))
+	})
+
+	t.Run("renamed back", func(t *testing.T) {
+		r, err := NewRules(strings.NewReader(`
+=== PLAYERS ===
++5
+Cat, Tiger
+Tiger, Cat
+`))
+		require.NoError(t, err)
+		require.Equal(t, "Cat", r.currentName("Tiger"))
+		require.Equal(t, "Cat", r.currentName("Cat"))
+	})
+}
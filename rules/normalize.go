@@ -0,0 +1,153 @@
+package rules
+
+import (
+	"strings"
+	"unicode"
+)
+
+// NameMatch описывает как сравнивать ники из правил с никами из логов.
+type NameMatch struct {
+	// FoldCase - сравнивать без учёта регистра
+	FoldCase bool
+	// FoldHomoglyphs - считать одинаковыми похожие символы (0 и O, кириллицу и латиницу)
+	FoldHomoglyphs bool
+}
+
+// homoglyphs отображает похожие символы в один канонический
+var homoglyphs = map[rune]rune{
+	// цифры, которыми любят заменять буквы
+	'0': 'O',
+	'1': 'l',
+	'I': 'l',
+	'|': 'l',
+	// кириллица, которая выглядит как латиница
+	'А': 'A', 'В': 'B', 'Е': 'E', 'Ё': 'E', 'К': 'K', 'М': 'M', 'Н': 'H',
+	'О': 'O', 'Р': 'P', 'С': 'C', 'Т': 'T', 'Х': 'X', 'У': 'Y',
+	'а': 'a', 'е': 'e', 'ё': 'e', 'к': 'k', 'м': 'm', 'о': 'o',
+	'р': 'p', 'с': 'c', 'т': 't', 'у': 'y', 'х': 'x',
+}
+
+// upperHomoglyphs похожи только в верхнем регистре: I похожа на l, а i уже нет.
+// Их надо заменять до приведения к нижнему регистру, иначе Nick и Nlck станут одним ником.
+var upperHomoglyphs = map[rune]rune{
+	'I': 'l',
+}
+
+// lowerHomoglyphs это те же похожие символы, но для уже приведённых к нижнему регистру ников.
+var lowerHomoglyphs = lowerKeys(homoglyphs, upperHomoglyphs)
+
+func lowerKeys(m, skip map[rune]rune) map[rune]rune {
+	res := make(map[rune]rune, len(m))
+	for from, to := range m {
+		if _, ok := skip[from]; ok {
+			continue
+		}
+		res[unicode.ToLower(from)] = unicode.ToLower(to)
+	}
+	return res
+}
+
+// Normalize приводит ник к виду, в котором его можно сравнивать побайтово.
+func (m NameMatch) Normalize(name string) string {
+	if !m.FoldCase && !m.FoldHomoglyphs {
+		return name
+	}
+
+	// сначала регистр, иначе В и в превращаются в B и в и перестают совпадать
+	glyphs := homoglyphs
+	if m.FoldCase {
+		glyphs = lowerHomoglyphs
+	}
+	return strings.Map(func(r rune) rune {
+		if m.FoldHomoglyphs {
+			if folded, ok := upperHomoglyphs[r]; ok {
+				r = folded
+			}
+		}
+		if m.FoldCase {
+			r = unicode.ToLower(r)
+		}
+		if m.FoldHomoglyphs {
+			if folded, ok := glyphs[r]; ok {
+				r = folded
+			}
+		}
+		return r
+	}, name)
+}
+
+// fuzzyMatch это самое агрессивное сравнение, используется для подсказок
+var fuzzyMatch = NameMatch{FoldCase: true, FoldHomoglyphs: true}
+
+// Suggest ищет в правилах ник, который похож на name, но с ним не совпадает.
+// Нужен для отчёта "возможно вы имели в виду" - опечатки в правилах иначе теряются молча.
+func (r *Rules) Suggest(name string) (string, bool) {
+	if r.hasPlayer(name) {
+		return "", false
+	}
+
+	key := []rune(fuzzyMatch.Normalize(name))
+	maxDistance := suggestDistance(len(key))
+
+	var (
+		best         string
+		bestDistance = maxDistance + 1
+	)
+
+	for _, target := range r.names {
+		distance := levenshtein(key, []rune(fuzzyMatch.Normalize(target)))
+		if distance < bestDistance || distance == bestDistance && target < best {
+			best, bestDistance = target, distance
+		}
+	}
+
+	if bestDistance > maxDistance {
+		return "", false
+	}
+	return best, true
+}
+
+// suggestDistance возвращает сколько опечаток допустимо в нике такой длины.
+// В коротких никах одна опечатка уже делает из него другой ник.
+func suggestDistance(length int) int {
+	switch {
+	case length <= 3:
+		return 0
+	case length <= 6:
+		return 1
+	default:
+		return 2
+	}
+}
+
+// levenshtein считает редакционное расстояние между двумя строками
+func levenshtein(a, b []rune) int {
+	prev := make([]int, len(b)+1)
+	curr := make([]int, len(b)+1)
+	for j := range prev {
+		prev[j] = j
+	}
+
+	for i := 1; i <= len(a); i++ {
+		curr[0] = i
+		for j := 1; j <= len(b); j++ {
+			cost := 1
+			if a[i-1] == b[j-1] {
+				cost = 0
+			}
+			curr[j] = minInt(prev[j]+1, curr[j-1]+1, prev[j-1]+cost)
+		}
+		prev, curr = curr, prev
+	}
+
+	return prev[len(b)]
+}
+
+func minInt(first int, rest ...int) int {
+	for _, v := range rest {
+		if v < first {
+			first = v
+		}
+	}
+	return first
+}
@@ -0,0 +1,140 @@
+package rules
+
+import (
+	"context"
+	"strings"
+	"testing"
+
+	"github.com/Feresey/haward/parse"
+	"github.com/stretchr/testify/require"
+)
+
+func TestNormalize(t *testing.T) {
+	tests := []struct {
+		name  string
+		match NameMatch
+		a, b  string
+		equal bool
+	}{
+		{
+			name: "byte for byte",
+			a:    "lOpa", b: "lOpa",
+			equal: true,
+		},
+		{
+			name: "case without folding",
+			a:    "lOpa", b: "lopa",
+			equal: false,
+		},
+		{
+			name:  "case",
+			match: NameMatch{FoldCase: true},
+			a:     "lOpa", b: "LOPA",
+			equal: true,
+		},
+		{
+			name:  "zero and O",
+			match: NameMatch{FoldHomoglyphs: true},
+			a:     "lOpa", b: "l0pa",
+			equal: true,
+		},
+		{
+			name:  "capital i and l",
+			match: NameMatch{FoldHomoglyphs: true},
+			a:     "EyjafjaIIajokul", b: "Eyjafjallajokul",
+			equal: true,
+		},
+		{
+			name:  "cyrillic",
+			match: NameMatch{FoldHomoglyphs: true},
+			a:     "KpAcABa", b: "КрАсАВа",
+			equal: true,
+		},
+		{
+			name:  "cyrillic and case",
+			match: NameMatch{FoldCase: true, FoldHomoglyphs: true},
+			a:     "kpacaba", b: "КРАСАВА",
+			equal: true,
+		},
+		{
+			name:  "cyrillic without latin twin and case",
+			match: NameMatch{FoldCase: true, FoldHomoglyphs: true},
+			a:     "вНук", b: "ВнУК",
+			equal: true,
+		},
+		{
+			name:  "capital i and l and case",
+			match: NameMatch{FoldCase: true, FoldHomoglyphs: true},
+			a:     "EyjafjaIIajokul", b: "eyjafjallajokul",
+			equal: true,
+		},
+		{
+			name:  "small i and l are different nicks",
+			match: NameMatch{FoldCase: true, FoldHomoglyphs: true},
+			a:     "Nick", b: "Nlck",
+			equal: false,
+		},
+		{
+			name:  "small i and l without case",
+			match: NameMatch{FoldHomoglyphs: true},
+			a:     "Nick", b: "Nlck",
+			equal: false,
+		},
+	}
+
+	for _, tt := range tests {
+		tt := tt
+		t.Run(tt.name, func(t *testing.T) {
+			a, b := tt.match.Normalize(tt.a), tt.match.Normalize(tt.b)
+			require.Equal(t, tt.equal, a == b, "%q vs %q", a, b)
+		})
+	}
+}
+
+func TestGetAwardNormalized(t *testing.T) {
+	const rulesTxt = `
+=== PLAYERS ===
++8
+lOpa
+`
+	r, err := NewRules(strings.NewReader(rulesTxt), WithNameMatch(NameMatch{FoldCase: true, FoldHomoglyphs: true}))
+	require.NoError(t, err)
+
+	award, ok := r.GetAward(context.Background(), Kill{Target: parse.Player{Name: "L0PA", ClanTag: "tag"}})
+	require.True(t, ok)
+	require.Equal(t, 8, award)
+}
+
+func TestSuggest(t *testing.T) {
+	const rulesTxt = `
+=== PLAYERS ===
++8
+lOpa
+Jigolee
+BNV
+`
+	r, err := NewRules(strings.NewReader(rulesTxt))
+	require.NoError(t, err)
+
+	tests := []struct {
+		seen string
+		want string
+		ok   bool
+	}{
+		{seen: "lOpa", ok: false},
+		{seen: "l0pa", want: "lOpa", ok: true},
+		{seen: "Jigole", want: "Jigolee", ok: true},
+		{seen: "jlgoIee", want: "Jigolee", ok: true},
+		{seen: "BNW", ok: false},
+		{seen: "Somebody", ok: false},
+	}
+
+	for _, tt := range tests {
+		tt := tt
+		t.Run(tt.seen, func(t *testing.T) {
+			target, ok := r.Suggest(tt.seen)
+			require.Equal(t, tt.ok, ok)
+			require.Equal(t, tt.want, target)
+		})
+	}
+}
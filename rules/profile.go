@@ -0,0 +1,161 @@
+package rules
+
+import (
+	"context"
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+// PlayerProfile это то, что публичное API знает об игроке (userinfo.php).
+type PlayerProfile struct {
+	UID       uint64 `json:"uid"`
+	Nickname  string `json:"nickname"`
+	EffRating int    `json:"effRating"`
+	Karma     int    `json:"karma"`
+	// PrestigeBonus бонус за престиж, доля от 0 до 1
+	PrestigeBonus float64  `json:"prestigeBonus"`
+	Clan          Clan     `json:"clan"`
+	PvP           PvPStats `json:"pvp"`
+}
+
+// PvPStats это статистика игрока в PvP.
+type PvPStats struct {
+	GamePlayed       int `json:"gamePlayed"`
+	GameWin          int `json:"gameWin"`
+	TotalAssists     int `json:"totalAssists"`
+	TotalBattleTime  int `json:"totalBattleTime"`
+	TotalDeath       int `json:"totalDeath"`
+	TotalDmgDone     int `json:"totalDmgDone"`
+	TotalHealingDone int `json:"totalHealingDone"`
+	TotalKill        int `json:"totalKill"`
+	TotalVpDmgDone   int `json:"totalVpDmgDone"`
+}
+
+// ProfileResolver узнаёт профиль игрока целиком, а не только клан.
+type ProfileResolver interface {
+	GetPlayerProfile(ctx context.Context, nickname string) (*PlayerProfile, error)
+}
+
+// profileFields это всё, с чем можно сравнивать профиль в правилах.
+var profileFields = map[string]func(p *PlayerProfile) float64{
+	"rating":   func(p *PlayerProfile) float64 { return float64(p.EffRating) },
+	"karma":    func(p *PlayerProfile) float64 { return float64(p.Karma) },
+	"prestige": func(p *PlayerProfile) float64 { return p.PrestigeBonus },
+	"games":    func(p *PlayerProfile) float64 { return float64(p.PvP.GamePlayed) },
+	"wins":     func(p *PlayerProfile) float64 { return float64(p.PvP.GameWin) },
+	"kills":    func(p *PlayerProfile) float64 { return float64(p.PvP.TotalKill) },
+	"deaths":   func(p *PlayerProfile) float64 { return float64(p.PvP.TotalDeath) },
+	"winrate": func(p *PlayerProfile) float64 {
+		if p.PvP.GamePlayed == 0 {
+			return 0
+		}
+		return float64(p.PvP.GameWin) / float64(p.PvP.GamePlayed)
+	},
+	"kd": func(p *PlayerProfile) float64 {
+		if p.PvP.TotalDeath == 0 {
+			return float64(p.PvP.TotalKill)
+		}
+		return float64(p.PvP.TotalKill) / float64(p.PvP.TotalDeath)
+	},
+}
+
+// длинные операторы раньше коротких, чтобы >= не разобрался как >
+var profileOps = []string{">=", "<=", "!=", "==", ">", "<"}
+
+// ProfileFilter это условия на профиль игрока из секции PROFILES, все должны выполняться:
+// "rating >= 5000, karma < 0"
+type ProfileFilter []ProfileCondition
+
+// ProfileCondition это одно сравнение: rating >= 5000
+type ProfileCondition struct {
+	Field string
+	Op    string
+	Value float64
+}
+
+func (c ProfileCondition) String() string {
+	return c.Field + " " + c.Op + " " + strconv.FormatFloat(c.Value, 'f', -1, 64)
+}
+
+func (c ProfileCondition) Match(p *PlayerProfile) bool {
+	value := profileFields[c.Field](p)
+	switch c.Op {
+	case ">=":
+		return value >= c.Value
+	case "<=":
+		return value <= c.Value
+	case "!=":
+		return value != c.Value
+	case "==":
+		return value == c.Value
+	case ">":
+		return value > c.Value
+	case "<":
+		return value < c.Value
+	}
+	return false
+}
+
+func (f ProfileFilter) String() string {
+	conds := make([]string, 0, len(f))
+	for _, cond := range f {
+		conds = append(conds, cond.String())
+	}
+	return strings.Join(conds, ", ")
+}
+
+func (f ProfileFilter) Match(p *PlayerProfile) bool {
+	for _, cond := range f {
+		if !cond.Match(p) {
+			return false
+		}
+	}
+	return true
+}
+
+// parseProfileFilter разбирает условия на профиль: "rating >= 5000, karma < 0"
+func parseProfileFilter(s string) (ProfileFilter, error) {
+	var res ProfileFilter
+	for _, raw := range strings.Split(s, ",") {
+		raw = strings.TrimSpace(raw)
+		if raw == "" {
+			continue
+		}
+		cond, err := parseProfileCondition(raw)
+		if err != nil {
+			return nil, err
+		}
+		res = append(res, cond)
+	}
+
+	if len(res) == 0 {
+		return nil, fmt.Errorf("empty profile filter: %q", s)
+	}
+	return res, nil
+}
+
+func parseProfileCondition(s string) (ProfileCondition, error) {
+	for _, op := range profileOps {
+		idx := strings.Index(s, op)
+		if idx == -1 {
+			continue
+		}
+
+		cond := ProfileCondition{
+			Field: strings.ToLower(strings.TrimSpace(s[:idx])),
+			Op:    op,
+		}
+		if _, ok := profileFields[cond.Field]; !ok {
+			return ProfileCondition{}, fmt.Errorf("unknown profile field: %q", cond.Field)
+		}
+
+		value, err := strconv.ParseFloat(strings.TrimSpace(s[idx+len(op):]), 64)
+		if err != nil {
+			return ProfileCondition{}, fmt.Errorf("parse value: %q: %w", s, err)
+		}
+		cond.Value = value
+		return cond, nil
+	}
+	return ProfileCondition{}, fmt.Errorf("no comparison in profile condition: %q", s)
+}
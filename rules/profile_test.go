@@ -0,0 +1,158 @@
+package rules
+
+import (
+	"context"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/Feresey/haward/parse"
+	"github.com/stretchr/testify/require"
+)
+
+func TestParseProfileFilter(t *testing.T) {
+	tests := []struct {
+		in      string
+		want    string
+		wantErr bool
+	}{
+		{in: "rating>=5000", want: "rating >= 5000"},
+		{in: " Rating >= 5000 ,karma<0", want: "rating >= 5000, karma < 0"},
+		{in: "winrate > 0.6", want: "winrate > 0.6"},
+		{in: "kd != 1", want: "kd != 1"},
+		{in: "level > 10", wantErr: true},
+		{in: "rating 5000", wantErr: true},
+		{in: "rating >= lots", wantErr: true},
+		{in: " , ", wantErr: true},
+	}
+
+	for _, test := range tests {
+		tt := test
+		t.Run(tt.in, func(t *testing.T) {
+			filter, err := parseProfileFilter(tt.in)
+			if tt.wantErr {
+				require.Error(t, err)
+				return
+			}
+			require.NoError(t, err)
+			require.Equal(t, tt.want, filter.String())
+		})
+	}
+}
+
+func TestProfileFilterMatch(t *testing.T) {
+	profile := &PlayerProfile{
+		EffRating: 6000,
+		Karma:     -5,
+		PvP:       PvPStats{GamePlayed: 100, GameWin: 70, TotalKill: 300, TotalDeath: 100},
+	}
+
+	match := func(s string) bool {
+		filter, err := parseProfileFilter(s)
+		require.NoError(t, err)
+		return filter.Match(profile)
+	}
+
+	require.True(t, match("rating >= 6000"))
+	require.False(t, match("rating > 6000"))
+	require.True(t, match("rating > 5000, karma < 0"))
+	require.False(t, match("rating > 5000, karma >= 0"))
+	require.True(t, match("winrate == 0.7"))
+	require.True(t, match("kd >= 3"))
+	require.True(t, match("games == 100"))
+}
+
+// profileResolver отдаёт профили из памяти
+type profileResolver map[string]PlayerProfile
+
+func (p profileResolver) GetPlayerClan(_ context.Context, nickname string) (*Clan, error) {
+	profile, ok := p[nickname]
+	if !ok {
+		return nil, ErrUnknownPlayer
+	}
+	if profile.Clan.IsZero() {
+		return nil, ErrNoClan
+	}
+	return &profile.Clan, nil
+}
+
+func (p profileResolver) GetPlayerProfile(_ context.Context, nickname string) (*PlayerProfile, error) {
+	profile, ok := p[nickname]
+	if !ok {
+		return nil, ErrUnknownPlayer
+	}
+	return &profile, nil
+}
+
+func TestRulesProfiles(t *testing.T) {
+	r := require.New(t)
+
+	const rulesTxt = `
+=== PLAYERS ===
++1
+Mettle
+=== PROFILES ===
++5
+rating >= 5000
+===
++20
+rating>=8000
+===
+-10
+karma < 0
+`
+	resolver := profileResolver{
+		"Pro":    {EffRating: 9000},
+		"Good":   {EffRating: 5500},
+		"Toxic":  {EffRating: 100, Karma: -3},
+		"Mettle": {EffRating: 9000},
+		"Newbie": {EffRating: 100},
+	}
+
+	rules, err := NewRules(strings.NewReader(rulesTxt), WithResolver(resolver))
+	r.NoError(err)
+
+	award := func(nickname string) (int, bool) {
+		return rules.GetAward(context.Background(), Kill{Target: parse.Player{Name: nickname}})
+	}
+
+	got, ok := award("Pro")
+	r.True(ok)
+	r.Equal(20, got, "the biggest award wins")
+
+	got, ok = award("Good")
+	r.True(ok)
+	r.Equal(5, got)
+
+	got, ok = award("Toxic")
+	r.True(ok)
+	r.Equal(-10, got)
+
+	// профиль, за который дали награду, записывается, чтобы её можно было проверить без API
+	bounty, ok := rules.GetBounty(context.Background(), parse.Player{Name: "Good"}, time.Time{})
+	r.True(ok)
+	r.Equal(&PlayerProfile{EffRating: 5500}, bounty.Profile)
+	bounty, ok = rules.GetBounty(context.Background(), parse.Player{Name: "Mettle"}, time.Time{})
+	r.True(ok)
+	r.Nil(bounty.Profile)
+
+	// игрок из правил важнее профиля
+	got, ok = award("Mettle")
+	r.True(ok)
+	r.Equal(1, got)
+
+	_, ok = award("Newbie")
+	r.False(ok)
+	_, ok = award("Nobody")
+	r.False(ok)
+
+	// а если резолвер профили не узнаёт, то и наград за профиль нет
+	static, err := NewStaticResolver(strings.NewReader("Pro,,\n"))
+	r.NoError(err)
+	rules.resolver = static
+	_, err = rules.GetPlayerProfile(context.Background(), "Pro")
+	r.Error(err)
+	_, ok = award("Pro")
+	r.False(ok)
+
+}
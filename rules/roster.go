@@ -0,0 +1,199 @@
+package rules
+
+import (
+	"context"
+	"encoding/csv"
+	"errors"
+	"fmt"
+	"io"
+	"net/url"
+	"os"
+	"sort"
+	"strings"
+)
+
+// StaticResolver знает кланы игроков из заранее составленного списка и никуда не ходит.
+type StaticResolver struct {
+	// map[player_name]clan
+	players map[string]Clan
+}
+
+// NewStaticResolver читает список игроков в формате CSV:
+//
+//	nickname,clan_tag,clan_name
+//	NikSvir,xIDx,The Dark Invaders
+//
+// Строчка с заголовком необязательна.
+func NewStaticResolver(rd io.Reader) (*StaticResolver, error) {
+	r := csv.NewReader(rd)
+	r.FieldsPerRecord = 3
+	r.TrimLeadingSpace = true
+	r.Comment = '#'
+
+	s := &StaticResolver{
+		players: make(map[string]Clan),
+	}
+
+	for lineNum := 1; ; lineNum++ {
+		record, err := r.Read()
+		if err != nil {
+			if errors.Is(err, io.EOF) {
+				break
+			}
+			return nil, fmt.Errorf("read roster: %w", err)
+		}
+
+		if lineNum == 1 && strings.EqualFold(record[0], rosterHeader[0]) {
+			continue
+		}
+
+		s.players[record[0]] = Clan{Tag: record[1], Name: record[2]}
+	}
+
+	return s, nil
+}
+
+// LoadRoster читает список игроков из файла.
+func LoadRoster(path string) (*StaticResolver, error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return nil, fmt.Errorf("open roster: %w", err)
+	}
+	defer file.Close()
+
+	return NewStaticResolver(file)
+}
+
+var rosterHeader = []string{"nickname", "clan_tag", "clan_name"}
+
+func (s *StaticResolver) GetPlayerClan(_ context.Context, nickname string) (*Clan, error) {
+	clan, ok := s.players[nickname]
+	if !ok {
+		return nil, fmt.Errorf("%w: %s", ErrUnknownPlayer, nickname)
+	}
+	if clan.IsZero() {
+		return nil, fmt.Errorf("%w: %s", ErrNoClan, nickname)
+	}
+	return &clan, nil
+}
+
+// MembersResolver узнаёт кто сейчас состоит в корпорации.
+type MembersResolver interface {
+	// GetClanMembers ищет корпорацию по тегу, а если его нет, то по названию.
+	// Возвращает корпорацию с тегом и названием и ники её участников.
+	GetClanMembers(ctx context.Context, clan Clan) (*Clan, []string, error)
+}
+
+// ErrUnknownClan значит что резолвер ничего не знает о корпорации.
+var ErrUnknownClan = errors.New("unknown clan")
+
+func (s *StaticResolver) GetClanMembers(_ context.Context, clan Clan) (*Clan, []string, error) {
+	var (
+		found   *Clan
+		members []string
+	)
+	for nickname, playerClan := range s.players {
+		if playerClan.IsZero() || !clanMatches(playerClan, clan) {
+			continue
+		}
+		members = append(members, nickname)
+		if found == nil || found.Name == "" {
+			found = &Clan{Name: playerClan.Name, Tag: playerClan.Tag}
+		}
+	}
+	if found == nil {
+		return nil, nil, fmt.Errorf("%w: %s", ErrUnknownClan, clanString(clan))
+	}
+
+	sort.Strings(members)
+	return found, members, nil
+}
+
+// GetClanMembers спрашивает состав корпорации у API (clan.php).
+func (p *PlayerClanResolver) GetClanMembers(ctx context.Context, clan Clan) (*Clan, []string, error) {
+	query := clan.Tag
+	if query == "" {
+		query = clan.Name
+	}
+
+	var data struct {
+		Name    string `json:"name"`
+		Tag     string `json:"tag"`
+		Members []struct {
+			Nickname string `json:"nickname"`
+		} `json:"members"`
+	}
+	err := p.apiCall(ctx, "clan.php", url.Values{"clan": {query}}, &data)
+	if errors.Is(err, errNotFound) {
+		return nil, nil, fmt.Errorf("%w: %s, %v", ErrUnknownClan, clanString(clan), err)
+	}
+	if err != nil {
+		return nil, nil, fmt.Errorf("get clan members from api: %s, %w", clanString(clan), err)
+	}
+
+	found := &Clan{Name: data.Name, Tag: data.Tag}
+	if found.IsZero() {
+		return nil, nil, fmt.Errorf("%w: %s", ErrUnknownClan, clanString(clan))
+	}
+
+	members := make([]string, 0, len(data.Members))
+	for _, member := range data.Members {
+		members = append(members, member.Nickname)
+	}
+	sort.Strings(members)
+	return found, members, nil
+}
+
+// Remember кладёт клан игрока в кэш, например из заранее собранного состава корпорации.
+func (p *PlayerClanResolver) Remember(nickname string, clan Clan) {
+	p.store(nickname, clan)
+}
+
+// WriteRoster записывает список игроков в том же формате, в котором его читает NewStaticResolver.
+// Игроки отсортированы по корпорациям, а внутри по никам.
+func WriteRoster(w io.Writer, players map[string]Clan) error {
+	nicknames := make([]string, 0, len(players))
+	for nickname := range players {
+		nicknames = append(nicknames, nickname)
+	}
+	sort.Slice(nicknames, func(i, j int) bool {
+		a, b := players[nicknames[i]], players[nicknames[j]]
+		if a.Tag != b.Tag {
+			return a.Tag < b.Tag
+		}
+		return nicknames[i] < nicknames[j]
+	})
+
+	cw := csv.NewWriter(w)
+	if err := cw.Write(rosterHeader); err != nil {
+		return err
+	}
+	for _, nickname := range nicknames {
+		clan := players[nickname]
+		if err := cw.Write([]string{nickname, clan.Tag, clan.Name}); err != nil {
+			return err
+		}
+	}
+	cw.Flush()
+	return cw.Error()
+}
+
+// clanMatches проверяет, что клан подходит под корпорацию из правил,
+// у которой может не быть тега.
+func clanMatches(clan, query Clan) bool {
+	if query.Tag != "" {
+		return clan.Tag == query.Tag
+	}
+	return clan.Name == query.Name
+}
+
+// clanString пишет корпорацию так же, как в правилах: "Fright Night [FINS]"
+func clanString(clan Clan) string {
+	switch {
+	case clan.Name == "":
+		return "[" + clan.Tag + "]"
+	case clan.Tag == "":
+		return clan.Name
+	}
+	return clan.Name + " [" + clan.Tag + "]"
+}
@@ -0,0 +1,202 @@
+package rules
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"strings"
+	"testing"
+
+	"github.com/Feresey/haward/parse"
+	"github.com/stretchr/testify/require"
+)
+
+const testRoster = `nickname,clan_tag,clan_name
+NikSvir,xIDx,The Dark Invaders
+# без клана
+HoWHoW,,
+Cat, NEKO, Nekopara
+Kitten,NEKO,Nekopara
+`
+
+func TestStaticResolver(t *testing.T) {
+	r := require.New(t)
+
+	s, err := NewStaticResolver(strings.NewReader(testRoster))
+	r.NoError(err)
+
+	clan, err := s.GetPlayerClan(context.Background(), "NikSvir")
+	r.NoError(err)
+	r.Equal(&Clan{Name: "The Dark Invaders", Tag: "xIDx"}, clan)
+
+	_, err = s.GetPlayerClan(context.Background(), "HoWHoW")
+	r.True(errors.Is(err, ErrNoClan))
+
+	_, err = s.GetPlayerClan(context.Background(), "Nobody")
+	r.True(errors.Is(err, ErrUnknownPlayer))
+}
+
+type resolverFunc func(nickname string) (*Clan, error)
+
+func (f resolverFunc) GetPlayerClan(_ context.Context, nickname string) (*Clan, error) {
+	return f(nickname)
+}
+
+func TestChainResolver(t *testing.T) {
+	r := require.New(t)
+
+	static, err := NewStaticResolver(strings.NewReader(testRoster))
+	r.NoError(err)
+
+	var asked []string
+	fallback := resolverFunc(func(nickname string) (*Clan, error) {
+		asked = append(asked, nickname)
+		if nickname == "Dog" {
+			return &Clan{Name: "Nekopara", Tag: "NEKO"}, nil
+		}
+		return nil, errors.New("no network")
+	})
+
+	chain := NewChainResolver(static, fallback)
+
+	clan, err := chain.GetPlayerClan(context.Background(), "Cat")
+	r.NoError(err)
+	r.Equal("NEKO", clan.Tag)
+
+	clan, err = chain.GetPlayerClan(context.Background(), "Dog")
+	r.NoError(err)
+	r.Equal("NEKO", clan.Tag)
+
+	_, err = chain.GetPlayerClan(context.Background(), "Nobody")
+	r.EqualError(err, "no network")
+
+	r.Equal([]string{"Dog", "Nobody"}, asked)
+
+	_, err = NewChainResolver().GetPlayerClan(context.Background(), "Nobody")
+	r.True(errors.Is(err, ErrUnknownPlayer))
+}
+
+func TestRulesWithStaticResolver(t *testing.T) {
+	const rulesTxt = `
+=== PLAYERS ===
++5
+OldCat, Cat
+=== CORPORATIONS ===
++10
+Nekopara
+`
+	static, err := NewStaticResolver(strings.NewReader(testRoster))
+	require.NoError(t, err)
+
+	r, err := NewRules(strings.NewReader(rulesTxt), WithResolver(static))
+	require.NoError(t, err)
+
+	// у игрока нет тега в логах, клан берём из списка
+	award, ok := r.GetAward(context.Background(), Kill{Target: parse.Player{Name: "Cat"}})
+	require.True(t, ok)
+	require.Equal(t, 5, award)
+
+	award, ok = r.GetAward(context.Background(), Kill{Target: parse.Player{Name: "Kitten"}})
+	require.True(t, ok)
+	require.Equal(t, 10, award)
+
+	// старый ник спрашивается как новый
+	clan, err := r.GetPlayerClan(context.Background(), "OldCat")
+	require.NoError(t, err)
+	require.Equal(t, "Nekopara", clan.Name)
+
+	_, ok = r.GetAward(context.Background(), Kill{Target: parse.Player{Name: "HoWHoW"}})
+	require.False(t, ok)
+}
+
+func TestClanMembers(t *testing.T) {
+	ctx := context.Background()
+
+	t.Run("static", func(t *testing.T) {
+		r := require.New(t)
+		s, err := NewStaticResolver(strings.NewReader(testRoster))
+		r.NoError(err)
+
+		clan, members, err := s.GetClanMembers(ctx, Clan{Name: "Nekopara"})
+		r.NoError(err)
+		r.Equal(&Clan{Name: "Nekopara", Tag: "NEKO"}, clan)
+		r.Equal([]string{"Cat", "Kitten"}, members)
+
+		_, members, err = s.GetClanMembers(ctx, Clan{Tag: "xIDx"})
+		r.NoError(err)
+		r.Equal([]string{"NikSvir"}, members)
+
+		_, _, err = s.GetClanMembers(ctx, Clan{Name: "Nobody"})
+		r.True(errors.Is(err, ErrUnknownClan))
+	})
+
+	t.Run("api", func(t *testing.T) {
+		r := require.New(t)
+		p := apiResolver(t, func(w http.ResponseWriter, req *http.Request) {
+			r.Equal("/clan.php", req.URL.Path)
+			if req.URL.Query().Get("clan") != "NEKO" {
+				fmt.Fprint(w, `{"result":"error","code":1,"text":"Clan not found"}`)
+				return
+			}
+			fmt.Fprint(w, `{"result":"ok","code":0,"data":{"name":"Nekopara","tag":"NEKO",`+
+				`"members":[{"nickname":"Kitten"},{"nickname":"Cat"}]}}`)
+		})
+		p.SetRetries(0, 0)
+
+		clan, members, err := p.GetClanMembers(ctx, Clan{Name: "Nekopara", Tag: "NEKO"})
+		r.NoError(err)
+		r.Equal(&Clan{Name: "Nekopara", Tag: "NEKO"}, clan)
+		r.Equal([]string{"Cat", "Kitten"}, members)
+
+		_, _, err = p.GetClanMembers(ctx, Clan{Name: "Nobody"})
+		r.True(errors.Is(err, ErrUnknownClan))
+
+		// состав кладётся в кэш, и за игроком в сеть уже не идём
+		p.Remember("Cat", *clan)
+		cached, err := p.GetPlayerClan(ctx, "Cat")
+		r.NoError(err)
+		r.Equal(clan, cached)
+	})
+}
+
+func TestWriteRoster(t *testing.T) {
+	r := require.New(t)
+
+	players := map[string]Clan{
+		"Kitten":  {Name: "Nekopara", Tag: "NEKO"},
+		"NikSvir": {Name: "The Dark Invaders", Tag: "xIDx"},
+		"Cat":     {Name: "Nekopara", Tag: "NEKO"},
+	}
+
+	var buf bytes.Buffer
+	r.NoError(WriteRoster(&buf, players))
+	r.Equal(`nickname,clan_tag,clan_name
+Cat,NEKO,Nekopara
+Kitten,NEKO,Nekopara
+NikSvir,xIDx,The Dark Invaders
+`, buf.String())
+
+	s, err := NewStaticResolver(&buf)
+	r.NoError(err)
+	r.Equal(players, s.players)
+}
+
+func TestRulesCorporations(t *testing.T) {
+	const rulesTxt = `
+=== CORPORATIONS ===
++10
+Nekopara [NEKO]
+The Dark Invaders
+`
+	dir := NewClanDirectory()
+	dir.Add(Clan{Name: "The Dark Invaders", Tag: "xIDx"})
+
+	r, err := NewRules(strings.NewReader(rulesTxt), WithClanDirectory(dir), WithResolver(NewChainResolver()))
+	require.NoError(t, err)
+	require.Equal(t, []Clan{
+		{Name: "Nekopara", Tag: "NEKO"},
+		{Name: "The Dark Invaders", Tag: "xIDx"},
+	}, r.Corporations())
+}
@@ -0,0 +1,139 @@
+package session
+
+import (
+	"context"
+	"sort"
+	"time"
+
+	"github.com/Feresey/haward/parse"
+	"github.com/Feresey/haward/rules"
+)
+
+// Alert это кто из игроков в бою важен для охоты. Приходит в начале боя, пока он ещё идёт.
+// Игроки заходят в бой не сразу, так что за бой может прийти несколько алертов,
+// и в каждом все, кто известен на этот момент.
+type Alert struct {
+	// StartedAt когда начался бой, с датой. Пустое, если время начала сессии неизвестно.
+	StartedAt time.Time
+	MapName   string
+	GameMode  string
+
+	// Hunted это враги, за которых дадут очки
+	Hunted []Target `json:",omitempty"`
+	// Avoid это враги, за сбитие которых очки снимут
+	Avoid []Target `json:",omitempty"`
+	// Teammates это игроки из правил, которые в этот раз в вашей команде
+	Teammates []Target `json:",omitempty"`
+}
+
+// Target это игрок из алерта и сколько за него дадут
+type Target struct {
+	Player
+	Award int
+}
+
+// SendAlerts включает алерты в начале боя. Без них кланы игроков до конца боя не спрашиваются.
+func (p *Parser) SendAlerts(alerts chan<- *Alert) {
+	p.alerts = alerts
+}
+
+// alert проверяет всех, кто сейчас в бою, и отправляет алерт, если среди них появился кто-то важный
+func (p *Parser) alert(ctx context.Context, lvl *parse.GameLogLevel) {
+	// в ангаре не воюют
+	if lvl.GameMode == "" {
+		return
+	}
+
+	players := make(map[string]parse.Player)
+	for _, teamPlayers := range lvl.Players {
+		for _, player := range teamPlayers {
+			players[player.Name] = player
+		}
+	}
+	delete(players, p.yourNickname)
+
+	at := matchTime(p.startedAt, lvl.LevelStart)
+	all := make([]parse.Player, 0, len(players))
+	for _, player := range players {
+		all = append(all, player)
+	}
+	clans := p.rules.PrefetchClans(ctx, all, at)
+	if ctx.Err() != nil {
+		return
+	}
+	extended := p.getEnemiesExtended(players, clans, nil)
+
+	enemies := lvl.GetEnemies()
+	hunter, _ := lvl.GetPlayer(p.yourNickname)
+
+	alert := &Alert{
+		StartedAt: at,
+		MapName:   lvl.MapName,
+		GameMode:  lvl.GameMode,
+	}
+	var fresh bool
+	for nickname, player := range players {
+		bounty, ok := p.rules.GetBounty(ctx, player, at)
+		if !ok {
+			continue
+		}
+
+		target := Target{Player: extended[nickname], Award: bounty.Score}
+		if _, ok := enemies[nickname]; !ok {
+			if bounty.Score <= 0 {
+				continue
+			}
+			alert.Teammates = append(alert.Teammates, target)
+		} else {
+			// за свою же корпорацию соклановцам не платят
+			award, ok := p.rules.Award(bounty, rules.Kill{
+				Target:   player,
+				Hunter:   hunter,
+				GameMode: lvl.GameMode,
+				At:       at,
+			})
+			if !ok || award == 0 {
+				continue
+			}
+			target.Award = award
+			if award > 0 {
+				alert.Hunted = append(alert.Hunted, target)
+			} else {
+				alert.Avoid = append(alert.Avoid, target)
+			}
+		}
+
+		if !p.alerted[nickname] {
+			fresh = true
+			p.alerted[nickname] = true
+		}
+	}
+	if !fresh {
+		return
+	}
+
+	sortTargets(alert.Hunted)
+	sortTargets(alert.Avoid)
+	sortTargets(alert.Teammates)
+
+	select {
+	case p.alerts <- alert:
+	case <-ctx.Done():
+	}
+}
+
+// sortTargets ставит первыми самых дорогих (или самых штрафных)
+func sortTargets(targets []Target) {
+	abs := func(n int) int {
+		if n < 0 {
+			return -n
+		}
+		return n
+	}
+	sort.Slice(targets, func(i, j int) bool {
+		if a, b := abs(targets[i].Award), abs(targets[j].Award); a != b {
+			return a > b
+		}
+		return targets[i].Name < targets[j].Name
+	})
+}
@@ -0,0 +1,91 @@
+package session
+
+import (
+	"context"
+	"io"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/Feresey/haward/rules"
+	"github.com/stretchr/testify/require"
+	"go.uber.org/zap"
+)
+
+func TestParserAlerts(t *testing.T) {
+	r := require.New(t)
+
+	const rulesTxt = `
+=== PLAYERS ===
++5
+Cat
+Fox
+===
+-10
+Dog
+Wolf
+===
++7
+Owl
+`
+	rule, err := rules.NewRules(strings.NewReader(rulesTxt), rules.WithResolver(rules.NewChainResolver()))
+	r.NoError(err)
+
+	// игра дописывает game.log по мере того, как игроки заходят в бой
+	game, w := io.Pipe()
+	go func() {
+		for _, chunk := range []string{
+			`12:46:15.531         | ====== starting level: 'levels/area1/s1338_pandora_anomaly' KingOfTheHill client =====
+12:46:16.000         | client: ADD_PLAYER 0 (ZiroTwo [xIDx], 2516405) status 4 team 1 group 4778580
+12:46:16.000         | client: ADD_PLAYER 1 (Cat [NEKO], 111) status 4 team 2
+12:46:16.000         | client: ADD_PLAYER 2 (Dog [NEKO], 222) status 4 team 2
+12:46:16.000         | client: ADD_PLAYER 3 (Fox [xIDx], 333) status 4 team 1
+12:46:16.000         | client: ADD_PLAYER 4 (Wolf [WOLF], 444) status 4 team 2 group 1
+`,
+			// никого важного
+			"12:46:17.000         | client: ADD_PLAYER 5 (Nobody, 555) status 4 team 2\n",
+			"12:46:18.000         | client: ADD_PLAYER 6 (Owl [OWL], 666) status 4 team 2\n",
+			"12:50:00.000         | ====== starting level: 'levels/mainmenu/mainmenu' client =====\n",
+		} {
+			if _, err := w.Write([]byte(chunk)); err != nil {
+				return
+			}
+		}
+		w.Close()
+	}()
+
+	startedAt := time.Date(2021, 8, 10, 12, 45, 0, 0, time.UTC)
+	p := NewParser("ZiroTwo", startedAt, strings.NewReader(""), game, rule)
+	alerts := make(chan *Alert, 10)
+	p.SendAlerts(alerts)
+
+	res := make(chan *LevelReport, 10)
+	err = p.Parse(context.Background(), zap.NewNop(), res)
+	r.Error(err)
+	close(alerts)
+
+	var got []*Alert
+	for alert := range alerts {
+		got = append(got, alert)
+	}
+	r.Len(got, 2)
+
+	first := got[0]
+	r.Equal("KingOfTheHill", first.GameMode)
+	r.Equal(time.Date(2021, 8, 10, 12, 46, 15, 531e6, time.UTC), first.StartedAt)
+	r.Len(first.Hunted, 1)
+	r.Equal("Cat", first.Hunted[0].Name)
+	r.Equal("NEKO", first.Hunted[0].Clan)
+	r.Equal(5, first.Hunted[0].Award)
+	// Wolf в группе, его можно сбивать бесплатно
+	r.Len(first.Avoid, 1)
+	r.Equal("Dog", first.Avoid[0].Name)
+	r.Equal(-10, first.Avoid[0].Award)
+	r.Len(first.Teammates, 1)
+	r.Equal("Fox", first.Teammates[0].Name)
+
+	// второй алерт со всеми, самые дорогие первыми
+	r.Len(got[1].Hunted, 2)
+	r.Equal("Owl", got[1].Hunted[0].Name)
+	r.Equal("Cat", got[1].Hunted[1].Name)
+}
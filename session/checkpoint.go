@@ -0,0 +1,99 @@
+package session
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+
+	"github.com/Feresey/haward/internal/atomicfile"
+)
+
+// State это докуда разобрана сессия.
+type State struct {
+	// Done значит что сессия закончилась и разобрана целиком, больше её читать не надо
+	Done bool `json:",omitempty"`
+	Checkpoint
+}
+
+// Checkpoints помнит докуда разобрана каждая сессия, чтобы в следующий раз читать только новое.
+type Checkpoints struct {
+	// map[session]state
+	sessions map[string]State
+}
+
+func NewCheckpoints() *Checkpoints {
+	return &Checkpoints{
+		sessions: make(map[string]State),
+	}
+}
+
+// LoadCheckpoints читает чекпоинты из файла. Если файла ещё нет, то ничего не разобрано.
+func LoadCheckpoints(path string) (*Checkpoints, error) {
+	c := NewCheckpoints()
+
+	data, err := os.ReadFile(path)
+	if err != nil {
+		if errors.Is(err, os.ErrNotExist) {
+			return c, nil
+		}
+		return nil, fmt.Errorf("read checkpoints: %w", err)
+	}
+	if err := json.Unmarshal(data, &c.sessions); err != nil {
+		return nil, fmt.Errorf("decode checkpoints: %q: %w", path, err)
+	}
+	return c, nil
+}
+
+// Save записывает чекпоинты в файл.
+func (c *Checkpoints) Save(path string) error {
+	data, err := json.MarshalIndent(c.sessions, "", "\t")
+	if err != nil {
+		return err
+	}
+
+	return atomicfile.WriteFile(path, data)
+}
+
+// Get возвращает докуда разобрана сессия. Если её ещё не разбирали, то State пустой.
+func (c *Checkpoints) Get(session string) State {
+	return c.sessions[session]
+}
+
+func (c *Checkpoints) Set(session string, state State) {
+	c.sessions[session] = state
+}
+
+// ErrShortLog значит что лог короче чекпоинта, то есть это уже другой файл.
+var ErrShortLog = errors.New("log is shorter than the checkpoint")
+
+// Skip проматывает лог до offset.
+func Skip(r io.Reader, offset int64) error {
+	if offset == 0 {
+		return nil
+	}
+	if s, ok := r.(io.Seeker); ok {
+		size, err := s.Seek(0, io.SeekEnd)
+		if err != nil {
+			return fmt.Errorf("seek log: %w", err)
+		}
+		if size < offset {
+			return ErrShortLog
+		}
+		_, err = s.Seek(offset, io.SeekStart)
+		if err != nil {
+			return fmt.Errorf("seek log: %w", err)
+		}
+		return nil
+	}
+
+	n, err := io.CopyN(io.Discard, r, offset)
+	if n < offset {
+		return ErrShortLog
+	}
+	if err != nil {
+		return fmt.Errorf("skip log: %w", err)
+	}
+	return nil
+}
@@ -0,0 +1,133 @@
+package session
+
+import (
+	"context"
+	"errors"
+	"io"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/Feresey/haward/rules"
+	"github.com/stretchr/testify/require"
+	"go.uber.org/zap"
+)
+
+const resumeGameLog = `12:45:25.995         | ====== starting level: 'levels/mainmenu/mainmenu' client =====
+12:46:15.000         | MasterServerSession: connect to dedicated server, session 45996460, at addr 23.111.211.203|35010
+12:46:15.531         | ====== starting level: 'levels/area1/s1338_pandora_anomaly' KingOfTheHill client =====
+12:46:16.000         | client: ADD_PLAYER 0 (ZiroTwo [xIDx], 2516405) status 4 team 1 group 4778580
+12:46:16.000         | client: ADD_PLAYER 1 (Cat [NEKO], 111) status 4 team 2 group 0
+12:50:00.000         | ====== starting level: 'levels/mainmenu/mainmenu' client =====
+12:50:59.000         | MasterServerSession: connect to dedicated server, session 45996500, at addr 23.111.211.204|35002
+12:51:00.000         | ====== starting level: 'levels/area1/s1338_pandora_anomaly' KingOfTheHill client =====
+12:51:01.000         | client: ADD_PLAYER 0 (ZiroTwo [xIDx], 2516405) status 4 team 1 group 4778580
+12:51:01.000         | client: ADD_PLAYER 1 (Dog [NEKO], 222) status 4 team 2 group 0
+12:55:00.000         | ====== starting level: 'levels/mainmenu/mainmenu' client =====
+`
+
+const resumeCombatLog = `12:47:00.000  CMBT   | ======= Start gameplay =======
+12:48:00.000  CMBT   | Killed Cat	 Ship_T5|0000000111;	 killer ZiroTwo|0000002708 Weapon_X
+12:52:00.000  CMBT   | ======= Start gameplay =======
+12:52:01.000  CMBT   | Damage        Dog|0000000222 ->        ZiroTwo|0000002708  91.25 (h:0.00 s:91.25) Weapon_Y KINETIC
+12:53:00.000  CMBT   | Killed Dog	 Ship_T5|0000000222;	 killer ZiroTwo|0000002708 Weapon_X
+12:54:00.000  CMBT   | Killed Fox	 Ship_T5|0000000333;	 killer Dog|0000000222 Weapon_X
+12:56:00.0`
+
+func parseAll(t *testing.T, p *Parser) []*LevelReport {
+	res := make(chan *LevelReport)
+	done := make(chan error, 1)
+	go func() {
+		defer close(res)
+		done <- p.Parse(context.Background(), zap.NewNop(), res)
+	}()
+
+	var reports []*LevelReport
+	for report := range res {
+		reports = append(reports, report)
+	}
+	err := <-done
+	require.True(t, errors.Is(err, io.EOF), err)
+	return reports
+}
+
+func TestParserResume(t *testing.T) {
+	r := require.New(t)
+
+	const rulesTxt = `
+=== PLAYERS ===
++5
+Cat
+Dog
+`
+	rule, err := rules.NewRules(strings.NewReader(rulesTxt), rules.WithResolver(rules.NewChainResolver()))
+	r.NoError(err)
+	startedAt := time.Date(2021, 8, 10, 12, 45, 0, 0, time.UTC)
+
+	p := NewParser("ZiroTwo", startedAt, strings.NewReader(resumeCombatLog), strings.NewReader(resumeGameLog), rule)
+	all := parseAll(t, p)
+	r.Len(all, 4)
+
+	r.Len(all[1].Score, 1)
+	r.Equal("Cat", all[1].Score[0].Killed)
+	r.Equal(2, all[1].Score[0].LineNum)
+
+	r.Len(all[3].Score, 1)
+	r.Equal("Dog", all[3].Score[0].Killed)
+	// номер строчки считается от начала combat.log, а не от начала боя
+	r.Equal(5, all[3].Score[0].LineNum)
+	// номер боя и все его сбития, не только свои
+	r.Equal("45996460", all[1].MatchID)
+	r.Empty(all[2].MatchID)
+	r.Equal("45996500", all[3].MatchID)
+	r.Len(all[3].Kills, 2)
+	r.Equal("Fox", all[3].Kills[1].Killed)
+	r.Equal("Dog", all[3].Kills[1].Killer)
+	r.Equal(6, all[3].Kills[1].LineNum)
+	// недописанная строчка не прочитана
+	r.Equal(int64(strings.LastIndexByte(resumeCombatLog, '\n')+1), all[3].Checkpoint.CombatOffset)
+
+	// второй запуск продолжает после первого боя
+	cp := all[1].Checkpoint
+	combat, game := strings.NewReader(resumeCombatLog), strings.NewReader(resumeGameLog)
+	r.NoError(Skip(combat, cp.CombatOffset))
+	r.NoError(Skip(game, cp.Game.Offset))
+
+	p = NewParser("ZiroTwo", startedAt, combat, game, rule)
+	p.Resume(cp)
+	resumed := parseAll(t, p)
+	r.Len(resumed, 2)
+	r.Equal(all[3].StartedAt, resumed[1].StartedAt)
+	r.Equal(all[3].Score, resumed[1].Score)
+	r.Equal(all[3].MatchID, resumed[1].MatchID)
+	r.Equal(all[3].Kills, resumed[1].Kills)
+	r.Equal(all[3].Checkpoint, resumed[1].Checkpoint)
+
+	r.True(errors.Is(Skip(strings.NewReader("short"), 100), ErrShortLog))
+	r.True(errors.Is(Skip(io.LimitReader(strings.NewReader("short"), 5), 100), ErrShortLog))
+}
+
+func TestCheckpoints(t *testing.T) {
+	r := require.New(t)
+	path := filepath.Join(t.TempDir(), "checkpoints.json")
+
+	c, err := LoadCheckpoints(path)
+	r.NoError(err)
+	r.Equal(State{}, c.Get("2021.08.10 20.02.16.123"))
+
+	state := State{Checkpoint: Checkpoint{CombatOffset: 10, CombatLine: 2}}
+	state.Game.Offset = 20
+	state.Game.NextLevelStart = time.Date(0, 1, 1, 12, 50, 0, 0, time.UTC)
+	c.Set("2021.08.10 20.02.16.123", State{Done: true})
+	c.Set("2021.08.11 19.00.00.5", state)
+	r.NoError(c.Save(path))
+
+	c, err = LoadCheckpoints(path)
+	r.NoError(err)
+	r.True(c.Get("2021.08.10 20.02.16.123").Done)
+	got := c.Get("2021.08.11 19.00.00.5")
+	r.True(state.Game.NextLevelStart.Equal(got.Game.NextLevelStart))
+	got.Game.NextLevelStart = state.Game.NextLevelStart
+	r.Equal(state, got)
+}
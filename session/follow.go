@@ -0,0 +1,144 @@
+package session
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"time"
+)
+
+// ErrTruncated значит что лог, за которым следили, стал короче: его пересоздали.
+var ErrTruncated = errors.New("log is truncated")
+
+// FollowOptions это как следить за логом.
+type FollowOptions struct {
+	// Poll как часто проверять, не вырос ли файл
+	Poll time.Duration
+	// Idle сколько ждать новых строчек, прежде чем сказать что файл кончился.
+	// 0 - ждать пока не скажет Stop.
+	Idle time.Duration
+	// Stop говорит что ждать больше нечего, например игра начала новую сессию
+	Stop func() bool
+}
+
+// Follower читает лог, который игра ещё пишет. Дойдя до конца, он ждёт пока файл вырастет.
+// Никаких уведомлений от системы: файл просто проверяется раз в Poll.
+type Follower struct {
+	ctx    context.Context
+	path   string
+	opts   FollowOptions
+	file   *os.File
+	offset int64
+	sleep  func(context.Context, time.Duration) error
+}
+
+// Follow начинает читать лог с offset. Файл открывается при первом чтении,
+// и если его ещё нет, то чтение ждёт пока он появится.
+func Follow(ctx context.Context, path string, offset int64, opts FollowOptions) *Follower {
+	return &Follower{
+		ctx:    ctx,
+		path:   path,
+		opts:   opts,
+		offset: offset,
+		sleep:  sleepContext,
+	}
+}
+
+// open ждёт пока файл появится. Как и при чтении, через Idle файла, которого нет, считается пустым.
+func (f *Follower) open() error {
+	var waited time.Duration
+	for {
+		file, err := os.Open(f.path)
+		if err == nil {
+			f.file = file
+			break
+		}
+		if !errors.Is(err, os.ErrNotExist) || f.stopped() {
+			return fmt.Errorf("open log: %w", err)
+		}
+		if f.opts.Idle > 0 && waited >= f.opts.Idle {
+			return io.EOF
+		}
+		if err := f.sleep(f.ctx, f.opts.Poll); err != nil {
+			return err
+		}
+		waited += f.opts.Poll
+	}
+
+	if err := Skip(f.file, f.offset); err != nil {
+		f.file.Close()
+		f.file = nil
+		return err
+	}
+	return nil
+}
+
+func (f *Follower) stopped() bool {
+	return f.opts.Stop != nil && f.opts.Stop()
+}
+
+func (f *Follower) Read(p []byte) (int, error) {
+	if f.file == nil {
+		if err := f.open(); err != nil {
+			return 0, err
+		}
+	}
+
+	var waited time.Duration
+	for {
+		n, err := f.file.Read(p)
+		f.offset += int64(n)
+		if n > 0 {
+			return n, nil
+		}
+		if err != nil && !errors.Is(err, io.EOF) {
+			return 0, err
+		}
+
+		info, err := f.file.Stat()
+		if err != nil {
+			return 0, fmt.Errorf("stat log: %w", err)
+		}
+		if info.Size() < f.offset {
+			return 0, fmt.Errorf("%w: %s", ErrTruncated, f.path)
+		}
+
+		// пока ждали, могли дописать, так что Stop проверяем до следующего чтения
+		if f.stopped() || (f.opts.Idle > 0 && waited >= f.opts.Idle) {
+			n, err := f.file.Read(p)
+			f.offset += int64(n)
+			if n > 0 {
+				return n, nil
+			}
+			if err == nil {
+				err = io.EOF
+			}
+			return 0, err
+		}
+
+		if err := f.sleep(f.ctx, f.opts.Poll); err != nil {
+			return 0, err
+		}
+		waited += f.opts.Poll
+	}
+}
+
+func (f *Follower) Close() error {
+	if f.file == nil {
+		return nil
+	}
+	return f.file.Close()
+}
+
+func sleepContext(ctx context.Context, d time.Duration) error {
+	t := time.NewTimer(d)
+	defer t.Stop()
+	select {
+	case <-t.C:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
@@ -0,0 +1,88 @@
+package session
+
+import (
+	"context"
+	"errors"
+	"io"
+	"os"
+	"path/filepath"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+)
+
+func appendFile(t *testing.T, path, data string) {
+	file, err := os.OpenFile(path, os.O_CREATE|os.O_APPEND|os.O_WRONLY, 0o644)
+	require.NoError(t, err)
+	_, err = file.WriteString(data)
+	require.NoError(t, err)
+	require.NoError(t, file.Close())
+}
+
+func TestFollower(t *testing.T) {
+	r := require.New(t)
+	path := filepath.Join(t.TempDir(), "game.log")
+	ctx := context.Background()
+
+	// файла ещё нет, игра его создаст
+	var stop int32
+	f := Follow(ctx, path, 0, FollowOptions{
+		Poll: time.Millisecond,
+		Stop: func() bool { return atomic.LoadInt32(&stop) != 0 },
+	})
+	go func() {
+		time.Sleep(10 * time.Millisecond)
+		appendFile(t, path, "first\n")
+	}()
+
+	buf := make([]byte, 64)
+	n, err := f.Read(buf)
+	r.NoError(err)
+	r.Equal("first\n", string(buf[:n]))
+
+	// дописали пока читатель ждал
+	go func() {
+		time.Sleep(10 * time.Millisecond)
+		appendFile(t, path, "second\n")
+	}()
+	n, err = f.Read(buf)
+	r.NoError(err)
+	r.Equal("second\n", string(buf[:n]))
+
+	// то что дописали перед Stop не теряется
+	appendFile(t, path, "last\n")
+	atomic.StoreInt32(&stop, 1)
+	data, err := io.ReadAll(f)
+	r.NoError(err)
+	r.Equal("last\n", string(data))
+	r.NoError(f.Close())
+
+	// продолжить с середины
+	f = Follow(ctx, path, int64(len("first\n")), FollowOptions{Poll: time.Millisecond, Idle: 5 * time.Millisecond})
+	data, err = io.ReadAll(f)
+	r.NoError(err)
+	r.Equal("second\nlast\n", string(data))
+
+	// лог пересоздали
+	r.NoError(os.WriteFile(path, []byte("new\n"), 0o644))
+	_, err = f.Read(buf)
+	r.True(errors.Is(err, ErrTruncated), err)
+	r.NoError(f.Close())
+
+	_, err = Follow(ctx, path, 100, FollowOptions{Poll: time.Millisecond}).Read(buf)
+	r.True(errors.Is(err, ErrShortLog), err)
+
+	// пока файла нет, он пустой
+	data, err = io.ReadAll(Follow(ctx, path+".missing", 0, FollowOptions{Poll: time.Millisecond, Idle: 5 * time.Millisecond}))
+	r.NoError(err)
+	r.Empty(data)
+
+	ctx, cancel := context.WithCancel(ctx)
+	f = Follow(ctx, path, 0, FollowOptions{Poll: time.Millisecond})
+	defer f.Close()
+	cancel()
+	_, err = io.ReadAll(f)
+	r.True(errors.Is(err, context.Canceled), err)
+}
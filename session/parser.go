@@ -6,6 +6,8 @@ import (
 	"errors"
 	"fmt"
 	"io"
+	"strings"
+	"time"
 
 	"github.com/Feresey/haward/parse"
 	"github.com/Feresey/haward/rules"
@@ -16,30 +18,105 @@ import (
 type Parser struct {
 	yourNickname string
 	rules        *rules.Rules
+	// startedAt время начала сессии. В логах у строчек есть только время, а дата берётся отсюда.
+	startedAt time.Time
 
-	levelIter      *parse.GameLogIter
-	gameLogScanner *bufio.Scanner
+	levelIter *parse.GameLogIter
+	combat    *combatLog
+
+	// alerts куда отправлять алерты, может не быть
+	alerts chan<- *Alert
+	// alerted про кого в этом бою уже был алерт
+	alerted map[string]bool
 
 	lastLevel bool
 }
 
 func NewParser(
 	yourNickname string,
+	startedAt time.Time,
 	combat, game io.Reader,
 	rules *rules.Rules,
 ) *Parser {
 	return &Parser{
-		yourNickname:   yourNickname,
-		rules:          rules,
-		lastLevel:      false,
-		levelIter:      parse.NewGameLogIter(yourNickname, game),
-		gameLogScanner: bufio.NewScanner(combat),
+		yourNickname: yourNickname,
+		rules:        rules,
+		startedAt:    startedAt,
+		lastLevel:    false,
+		levelIter:    parse.NewGameLogIter(yourNickname, game),
+		combat:       &combatLog{rd: bufio.NewReader(combat)},
+	}
+}
+
+// Checkpoint это место в логах сессии между боями, с которого можно продолжить разбор.
+type Checkpoint struct {
+	Game parse.GameLogState
+	// CombatOffset с какого байта combat.log продолжать
+	CombatOffset int64
+	// CombatLine сколько строчек combat.log уже прочитано
+	CombatLine int
+}
+
+// Resume продолжает разбор с места, которое раньше вернул LevelReport.Checkpoint.
+// Логи надо передать в NewParser уже промотанными до cp.Game.Offset и cp.CombatOffset.
+func (p *Parser) Resume(cp Checkpoint) {
+	p.levelIter.Resume(cp.Game)
+	p.combat.offset, p.combat.lines = cp.CombatOffset, cp.CombatLine
+}
+
+func (p *Parser) checkpoint() Checkpoint {
+	return Checkpoint{
+		Game:         p.levelIter.State(),
+		CombatOffset: p.combat.offset,
+		CombatLine:   p.combat.lines,
+	}
+}
+
+// combatLog читает combat.log по целым строчкам и считает сколько прочитано.
+// В отличие от bufio.Scanner, дойдя до конца файла, его можно читать дальше, если файл растёт.
+// Недописанная строчка откладывается, пока её не допишут.
+type combatLog struct {
+	rd      *bufio.Reader
+	partial string
+	line    string
+	// err это ошибка чтения, конец файла ошибкой не считается: его могут дописать
+	err error
+	// kills это все сбития текущего боя, LineNum от начала combat.log
+	kills []parse.DeathRecord
+
+	offset int64
+	lines  int
+}
+
+func (c *combatLog) Scan() bool {
+	line, err := c.rd.ReadString('\n')
+	if err != nil {
+		c.partial += line
+		if !errors.Is(err, io.EOF) {
+			c.err = err
+		}
+		return false
 	}
+	line, c.partial = c.partial+line, ""
+
+	c.offset += int64(len(line))
+	c.lines++
+	c.line = strings.TrimRight(line, "\r\n")
+	return true
+}
+
+func (c *combatLog) Text() string {
+	return c.line
+}
+
+func (c *combatLog) ObserveKill(record parse.DeathRecord) {
+	record.LineNum = c.lines
+	c.kills = append(c.kills, record)
 }
 
 func (p *Parser) Parse(ctx context.Context, log *zap.Logger, levelReports chan<- *LevelReport) error {
 	for !p.lastLevel {
-		levelReport, err := p.parseLogLevel(log)
+		levelReport, err := p.parseLogLevel(ctx, log)
 		if err != nil {
 			return err
 		}
@@ -57,15 +134,49 @@ type Player struct {
 	parse.Player
 	// Clan is the name of the clan or its tag
 	Clan string
+	// KnownClan это тег и название клана по отдельности, что из них известно.
+	// По ним можно заново проверить награду за корпорацию без API.
+	KnownClan rules.Clan
+	// Profile это профиль игрока, если награда за него назначена по профилю
+	Profile *rules.PlayerProfile
 }
 
 type LevelReport struct {
+	// StartedAt когда начался бой, с датой. Пустое, если время начала сессии неизвестно.
+	StartedAt time.Time
+	MapName   string
+	GameMode  string
+	// MatchID номер боя на сервере, общий для всех игроков боя. Пустой, если в логе его не было
+	MatchID string
+
+	// Checkpoint это место в логах сразу после боя
+	Checkpoint Checkpoint
+
+	// Hunter это сам охотник в этом бою
+	Hunter  parse.Player
 	Enemies map[string]Player
-	Score   []parse.DeathRecord
+	// Score это засчитанные сбития. LineNum и ContextLine считаются от начала combat.log.
+	Score []parse.DeathRecord
+	// PlayerLines это строчки ADD_PLAYER из game.log, map[ник][]строчка
+	PlayerLines map[string][]string
+	// Kills это все сбития боя, кто бы кого ни сбил. Заполнены только строчка, время и ники
+	Kills []parse.DeathRecord
+	// NearMisses это враги, ники которых похожи на ники из правил, но не совпали.
+	// map[ник_из_лога]ник_из_правил
+	NearMisses map[string]string
+	// FailedLookups это враги, клан которых узнать не удалось. map[ник]ошибка
+	FailedLookups map[string]error
 }
 
 // parseLogLevel парсит один уровень (одну игру по идее)
-func (p *Parser) parseLogLevel(logger *zap.Logger) (levelReport *LevelReport, err error) {
+func (p *Parser) parseLogLevel(ctx context.Context, logger *zap.Logger) (levelReport *LevelReport, err error) {
+	if p.alerts != nil {
+		p.alerted = make(map[string]bool)
+		p.levelIter.OnRoster(func(lvl *parse.GameLogLevel) {
+			p.alert(ctx, lvl)
+		})
+	}
+
 	lvl, err := p.levelIter.ScanNextLevel()
 	if err != nil {
 		if errors.Is(err, io.EOF) {
@@ -80,67 +191,171 @@ func (p *Parser) parseLogLevel(logger *zap.Logger) (levelReport *LevelReport, er
 
 	logger.Debug("", zap.Reflect("enemies", enemies))
 
-	enemiesAwards, err := p.getEnemiesAwards(enemies)
+	// в каких кланах все были именно в этом бою
+	at := matchTime(p.startedAt, lvl.LevelStart)
+	report.StartedAt = at
+	report.MapName = lvl.MapName
+	report.GameMode = lvl.GameMode
+	report.MatchID = lvl.MatchID
+	report.PlayerLines = lvl.PlayerLines
+	for _, players := range lvl.Players {
+		p.rules.ObserveClans(players, at)
+	}
+
+	// кланы всех, кого придётся спрашивать, узнаём разом, а не по одному
+	players := make([]parse.Player, 0, len(enemies))
+	for _, enemy := range enemies {
+		players = append(players, enemy)
+	}
+	clans := p.rules.PrefetchClans(ctx, players, at)
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+
+	hunter, _ := lvl.GetPlayer(p.yourNickname)
+
+	enemiesAwards, err := p.getEnemiesAwards(ctx, enemies, hunter, at)
 	if err != nil {
 		return nil, fmt.Errorf("get awards: %w", err)
 	}
 	logger.Debug("", zap.Reflect("enemies_awards", enemiesAwards))
 
+	report.NearMisses = p.getNearMisses(enemies, enemiesAwards)
+
+	// ParseCombatLog считает строчки с начала своего куска лога
+	linesBefore := p.combat.lines
+	p.combat.kills = nil
 	awadrs, punishments, err := parse.ParseCombatLog(
-		p.gameLogScanner, p.yourNickname, lvl.LevelEnd,
-		func(s string) (int, bool) {
-			cost, ok := enemiesAwards[s]
-			return cost, ok
+		p.combat, p.yourNickname, lvl.LevelEnd,
+		func(record parse.DeathRecord) (int, bool) {
+			bounty, ok := enemiesAwards[record.Killed]
+			if !ok {
+				return 0, false
+			}
+			// за свою же корпорацию соклановцам не платят
+			return p.rules.Award(bounty, rules.Kill{
+				Target:    enemies[record.Killed],
+				Hunter:    hunter,
+				GameMode:  lvl.GameMode,
+				ShotFirst: record.ShotFirst,
+				At:        at,
+			})
 		})
+	if err == nil {
+		err = p.combat.err
+	}
 	if err != nil {
 		return nil, fmt.Errorf("parse combat log: %w", err)
 	}
 
 	report.Score = append(awadrs, punishments...)
-
-	enemiesExtended, err := p.getEnemiesExtended(enemies)
-	if err != nil {
-		return nil, fmt.Errorf("get enemies extended: %w", err)
+	for i := range report.Score {
+		report.Score[i].LineNum += linesBefore
+		report.Score[i].ContextLine += linesBefore
 	}
+	report.Kills = p.combat.kills
+	report.Checkpoint = p.checkpoint()
 
-	report.Enemies = enemiesExtended
+	report.Hunter = hunter
+	report.Enemies = p.getEnemiesExtended(enemies, clans, enemiesAwards)
+	report.FailedLookups = getFailedLookups(clans)
 
 	return &report, nil
 }
 
-func (p *Parser) getEnemiesAwards(enemies map[string]parse.Player) (map[string]int, error) {
-	awards := make(map[string]int)
+func (p *Parser) getEnemiesAwards(
+	ctx context.Context,
+	enemies map[string]parse.Player,
+	hunter parse.Player,
+	at time.Time,
+) (map[string]rules.Bounty, error) {
+	awards := make(map[string]rules.Bounty)
 	for _, enemy := range enemies {
-		award, ok := p.rules.GetAward(enemy)
+		bounty, ok := p.rules.GetBounty(ctx, enemy, at)
 		if ok {
-			awards[enemy.Name] = award
+			awards[enemy.Name] = bounty
 			continue
 		}
 	}
 	return awards, nil
 }
 
-func (p *Parser) getEnemiesExtended(enemies map[string]parse.Player) (map[string]Player, error) {
+func (p *Parser) getNearMisses(enemies map[string]parse.Player, awards map[string]rules.Bounty) map[string]string {
+	var res map[string]string
+	for nickname := range enemies {
+		if _, ok := awards[nickname]; ok {
+			continue
+		}
+		target, ok := p.rules.Suggest(nickname)
+		if !ok {
+			continue
+		}
+		if res == nil {
+			res = make(map[string]string)
+		}
+		res[nickname] = target
+	}
+	return res
+}
+
+func (p *Parser) getEnemiesExtended(
+	enemies map[string]parse.Player,
+	clans map[string]rules.ClanResult,
+	awards map[string]rules.Bounty,
+) map[string]Player {
 	res := make(map[string]Player)
 	for nickname, enemy := range enemies {
+		player := Player{
+			Player:  enemy,
+			Profile: awards[nickname].Profile,
+		}
 		if enemy.ClanTag != "" {
-			res[nickname] = Player{
-				Player: enemy,
-				Clan:   enemy.ClanTag,
-			}
+			player.Clan = enemy.ClanTag
+			player.KnownClan.Tag = enemy.ClanTag
+			player.KnownClan.Name, _ = p.rules.Directory().Name(enemy.ClanTag)
+			res[nickname] = player
 			continue
 		}
-		clan, err := p.rules.GetPlayerClan(nickname)
-		if err != nil {
-			return nil, err
+		// без клана или не удалось узнать - тогда клан пустой
+		if clan := clans[nickname].Clan; clan != nil {
+			player.Clan = clan.Name
+			player.KnownClan = *clan
 		}
-		if clan != nil {
-			res[nickname] = Player{
-				Player: enemy,
-				Clan:   clan.Name,
-			}
+		res[nickname] = player
+	}
+
+	return res
+}
+
+// getFailedLookups выбирает игроков, про которых резолвер не ответил.
+// Отсутствие клана ошибкой не считается.
+func getFailedLookups(clans map[string]rules.ClanResult) map[string]error {
+	var res map[string]error
+	for nickname, clan := range clans {
+		if clan.Err == nil || errors.Is(clan.Err, rules.ErrNoClan) {
+			continue
 		}
+		if res == nil {
+			res = make(map[string]error)
+		}
+		res[nickname] = clan.Err
 	}
+	return res
+}
 
-	return res, nil
+// matchTime собирает полное время боя из даты начала сессии и времени из лога.
+// Сессия могла перевалить за полночь, тогда бой уже на следующий день.
+func matchTime(sessionStart, clock time.Time) time.Time {
+	if sessionStart.IsZero() || clock.IsZero() {
+		return time.Time{}
+	}
+
+	year, month, day := sessionStart.Date()
+	res := time.Date(year, month, day,
+		clock.Hour(), clock.Minute(), clock.Second(), clock.Nanosecond(),
+		sessionStart.Location())
+	if res.Before(sessionStart) {
+		res = res.AddDate(0, 0, 1)
+	}
+	return res
 }
@@ -8,6 +8,7 @@ import (
 	"os"
 	"strings"
 	"testing"
+	"time"
 
 	"github.com/Feresey/haward/rules"
 	"github.com/stretchr/testify/require"
@@ -38,7 +39,7 @@ fyringsved
 	rule, err := rules.NewRules(strings.NewReader(rulesTxt))
 	r.NoError(err)
 
-	p := NewParser("ZiroTwo", combat, game, rule)
+	p := NewParser("ZiroTwo", time.Time{}, combat, game, rule)
 	ctx := context.TODO()
 
 	res := make(chan *LevelReport)
@@ -56,3 +57,27 @@ fyringsved
 		}
 	}
 }
+
+func TestMatchTime(t *testing.T) {
+	r := require.New(t)
+
+	clock := func(s string) time.Time {
+		res, err := time.Parse("15:04:05.000", s)
+		r.NoError(err)
+		return res
+	}
+
+	start := time.Date(2021, time.October, 19, 23, 30, 0, 0, time.UTC)
+
+	r.Equal(
+		time.Date(2021, time.October, 19, 23, 45, 10, 500*int(time.Millisecond), time.UTC),
+		matchTime(start, clock("23:45:10.500")),
+	)
+	// после полуночи
+	r.Equal(
+		time.Date(2021, time.October, 20, 0, 15, 0, 0, time.UTC),
+		matchTime(start, clock("00:15:00.000")),
+	)
+	r.True(matchTime(time.Time{}, clock("00:15:00.000")).IsZero())
+	r.True(matchTime(start, time.Time{}).IsZero())
+}
@@ -0,0 +1,277 @@
+package session
+
+import (
+	"archive/tar"
+	"archive/zip"
+	"compress/gzip"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+)
+
+// TimeFormat это формат имени папки сессии: "2021.08.10 20.02.16.123"
+const TimeFormat = "2006.01.02 15.04.05.999"
+
+// StartedAt возвращает время начала сессии по имени её папки.
+func StartedAt(name string) (time.Time, error) {
+	startedAt, err := time.Parse(TimeFormat, name)
+	if err != nil {
+		return time.Time{}, fmt.Errorf("parse session date: %q: %w", name, err)
+	}
+	return startedAt, nil
+}
+
+// Source это откуда берутся логи сессий: папка с логами или архив, который прислал охотник.
+type Source interface {
+	// Sessions возвращает имена папок всех сессий по порядку.
+	Sessions() ([]string, error)
+	// Open открывает лог сессии, например combat.log. Если лог сжат (combat.log.gz), то он разжимается.
+	Open(session, name string) (io.ReadCloser, error)
+	Close() error
+}
+
+// OpenSource открывает папку с логами, zip или tar.gz архив.
+// Внутри архива папки сессий могут лежать на любой глубине.
+func OpenSource(path string) (Source, error) {
+	info, err := os.Stat(path)
+	if err != nil {
+		return nil, fmt.Errorf("open logs: %w", err)
+	}
+	if info.IsDir() {
+		return &dirSource{root: path}, nil
+	}
+
+	lower := strings.ToLower(path)
+	switch {
+	case strings.HasSuffix(lower, ".zip"):
+		return openZipSource(path)
+	case strings.HasSuffix(lower, ".tar.gz"), strings.HasSuffix(lower, ".tgz"):
+		return openTarSource(path)
+	}
+	return nil, fmt.Errorf("open logs: %q: want a directory, .zip or .tar.gz", path)
+}
+
+func isSessionName(name string) bool {
+	_, err := time.Parse(TimeFormat, name)
+	return err == nil
+}
+
+// sortSessions сортирует сессии по времени начала
+func sortSessions(names []string) {
+	sort.Slice(names, func(i, j int) bool {
+		a, _ := time.Parse(TimeFormat, names[i])
+		b, _ := time.Parse(TimeFormat, names[j])
+		return a.Before(b)
+	})
+}
+
+// openLog открывает лог, а если его нет, то сжатый лог
+func openLog(open func(name string) (io.ReadCloser, error), name string) (io.ReadCloser, error) {
+	rc, err := open(name)
+	if err == nil {
+		return rc, nil
+	}
+	if !errors.Is(err, os.ErrNotExist) {
+		return nil, err
+	}
+
+	rc, gzErr := open(name + ".gz")
+	if gzErr != nil {
+		if errors.Is(gzErr, os.ErrNotExist) {
+			return nil, err
+		}
+		return nil, gzErr
+	}
+	zr, gzErr := gzip.NewReader(rc)
+	if gzErr != nil {
+		rc.Close()
+		return nil, fmt.Errorf("open %s.gz: %w", name, gzErr)
+	}
+	return &gzipLog{Reader: zr, rc: rc}, nil
+}
+
+type gzipLog struct {
+	*gzip.Reader
+	rc io.ReadCloser
+}
+
+func (g *gzipLog) Close() error {
+	err := g.Reader.Close()
+	if cerr := g.rc.Close(); err == nil {
+		err = cerr
+	}
+	return err
+}
+
+// dirSource это обычная папка с логами игры.
+type dirSource struct {
+	root string
+	// tmp значит что папку надо удалить при закрытии
+	tmp bool
+}
+
+func (d *dirSource) Sessions() ([]string, error) {
+	entries, err := os.ReadDir(d.root)
+	if err != nil {
+		return nil, err
+	}
+
+	var res []string
+	for _, entry := range entries {
+		if entry.IsDir() && isSessionName(entry.Name()) {
+			res = append(res, entry.Name())
+		}
+	}
+	sortSessions(res)
+	return res, nil
+}
+
+func (d *dirSource) Open(session, name string) (io.ReadCloser, error) {
+	return openLog(func(name string) (io.ReadCloser, error) {
+		return os.Open(filepath.Join(d.root, session, name))
+	}, name)
+}
+
+func (d *dirSource) Close() error {
+	if d.tmp {
+		return os.RemoveAll(d.root)
+	}
+	return nil
+}
+
+// sessionFile раскладывает путь внутри архива на сессию и имя файла.
+// Подходят только файлы, которые лежат прямо в папке сессии.
+func sessionFile(name string) (session, file string, ok bool) {
+	dir, file := path.Split(strings.TrimSuffix(path.Clean(strings.ReplaceAll(name, "\\", "/")), "/"))
+	session = path.Base(dir)
+	if file == "" || !isSessionName(session) {
+		return "", "", false
+	}
+	return session, file, true
+}
+
+// zipSource читает логи прямо из zip архива.
+type zipSource struct {
+	zr *zip.ReadCloser
+	// map[session]map[file]
+	files map[string]map[string]*zip.File
+}
+
+func openZipSource(path string) (*zipSource, error) {
+	zr, err := zip.OpenReader(path)
+	if err != nil {
+		return nil, fmt.Errorf("open zip: %w", err)
+	}
+
+	z := &zipSource{
+		zr:    zr,
+		files: make(map[string]map[string]*zip.File),
+	}
+	for _, f := range zr.File {
+		if f.FileInfo().IsDir() {
+			continue
+		}
+		session, file, ok := sessionFile(f.Name)
+		if !ok {
+			continue
+		}
+		if z.files[session] == nil {
+			z.files[session] = make(map[string]*zip.File)
+		}
+		z.files[session][file] = f
+	}
+	return z, nil
+}
+
+func (z *zipSource) Sessions() ([]string, error) {
+	res := make([]string, 0, len(z.files))
+	for session := range z.files {
+		res = append(res, session)
+	}
+	sortSessions(res)
+	return res, nil
+}
+
+func (z *zipSource) Open(session, name string) (io.ReadCloser, error) {
+	return openLog(func(name string) (io.ReadCloser, error) {
+		f, ok := z.files[session][name]
+		if !ok {
+			return nil, fmt.Errorf("open %s/%s: %w", session, name, os.ErrNotExist)
+		}
+		return f.Open()
+	}, name)
+}
+
+func (z *zipSource) Close() error {
+	return z.zr.Close()
+}
+
+// openTarSource распаковывает папки сессий из tar.gz во временную папку:
+// по tar.gz нельзя прыгать, а каждую сессию читаем несколько раз.
+func openTarSource(path string) (*dirSource, error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return nil, fmt.Errorf("open tar: %w", err)
+	}
+	defer file.Close()
+
+	zr, err := gzip.NewReader(file)
+	if err != nil {
+		return nil, fmt.Errorf("open tar: %w", err)
+	}
+
+	tmp, err := os.MkdirTemp("", "haward-*")
+	if err != nil {
+		return nil, fmt.Errorf("create temp dir: %w", err)
+	}
+	d := &dirSource{root: tmp, tmp: true}
+
+	tr := tar.NewReader(zr)
+	for {
+		hdr, err := tr.Next()
+		if errors.Is(err, io.EOF) {
+			break
+		}
+		if err != nil {
+			d.Close()
+			return nil, fmt.Errorf("read tar: %w", err)
+		}
+		if hdr.Typeflag != tar.TypeReg {
+			continue
+		}
+		session, name, ok := sessionFile(hdr.Name)
+		if !ok {
+			continue
+		}
+		// имена проверены, так что за пределы tmp ничего не попадёт
+		if err := extractFile(filepath.Join(tmp, session), name, tr); err != nil {
+			d.Close()
+			return nil, err
+		}
+	}
+	return d, nil
+}
+
+func extractFile(dir, name string, r io.Reader) error {
+	if err := os.MkdirAll(dir, 0o755); err != nil {
+		return fmt.Errorf("extract: %w", err)
+	}
+	f, err := os.Create(filepath.Join(dir, name))
+	if err != nil {
+		return fmt.Errorf("extract: %w", err)
+	}
+	_, err = io.Copy(f, r)
+	if cerr := f.Close(); err == nil {
+		err = cerr
+	}
+	if err != nil {
+		return fmt.Errorf("extract %s: %w", name, err)
+	}
+	return nil
+}
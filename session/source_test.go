@@ -0,0 +1,138 @@
+package session
+
+import (
+	"archive/tar"
+	"archive/zip"
+	"bytes"
+	"compress/gzip"
+	"errors"
+	"io"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+// testLogs это файлы, как их присылают охотники: сессии лежат в папке logs, часть логов сжата
+var testLogs = map[string]string{
+	"logs/2021.08.11 19.00.00.5/combat.log":     "second combat\n",
+	"logs/2021.08.11 19.00.00.5/game.log":       "second game\n",
+	"logs/2021.08.10 20.02.16.123/combat.log":   "first combat\n",
+	"logs/2021.08.10 20.02.16.123/game.log.gz":  gzipString("first game\n"),
+	"logs/not a session/combat.log":             "nope\n",
+	"logs/readme.txt":                           "nope\n",
+	"logs/2021.08.10 20.02.16.123/deep/x/a.log": "nope\n",
+}
+
+func gzipString(s string) string {
+	var buf bytes.Buffer
+	zw := gzip.NewWriter(&buf)
+	_, _ = zw.Write([]byte(s))
+	_ = zw.Close()
+	return buf.String()
+}
+
+func writeTestDir(t *testing.T) string {
+	root := t.TempDir()
+	for name, data := range testLogs {
+		path := filepath.Join(root, filepath.FromSlash(name))
+		require.NoError(t, os.MkdirAll(filepath.Dir(path), 0o755))
+		require.NoError(t, os.WriteFile(path, []byte(data), 0o644))
+	}
+	return filepath.Join(root, "logs")
+}
+
+func writeTestZip(t *testing.T) string {
+	path := filepath.Join(t.TempDir(), "logs.zip")
+	file, err := os.Create(path)
+	require.NoError(t, err)
+	defer file.Close()
+
+	zw := zip.NewWriter(file)
+	for name, data := range testLogs {
+		w, err := zw.Create(name)
+		require.NoError(t, err)
+		_, err = io.WriteString(w, data)
+		require.NoError(t, err)
+	}
+	require.NoError(t, zw.Close())
+	return path
+}
+
+func writeTestTar(t *testing.T) string {
+	path := filepath.Join(t.TempDir(), "logs.tar.gz")
+	file, err := os.Create(path)
+	require.NoError(t, err)
+	defer file.Close()
+
+	zw := gzip.NewWriter(file)
+	tw := tar.NewWriter(zw)
+	for name, data := range testLogs {
+		require.NoError(t, tw.WriteHeader(&tar.Header{
+			Name:     name,
+			Mode:     0o644,
+			Size:     int64(len(data)),
+			Typeflag: tar.TypeReg,
+		}))
+		_, err := io.WriteString(tw, data)
+		require.NoError(t, err)
+	}
+	// кривой путь не должен вылезти за пределы временной папки
+	require.NoError(t, tw.WriteHeader(&tar.Header{Name: "../../2021.08.10 20.02.16.123/../evil", Mode: 0o644, Typeflag: tar.TypeReg}))
+	require.NoError(t, tw.Close())
+	require.NoError(t, zw.Close())
+	return path
+}
+
+func readLog(t *testing.T, src Source, session, name string) string {
+	rc, err := src.Open(session, name)
+	require.NoError(t, err)
+	defer rc.Close()
+	data, err := io.ReadAll(rc)
+	require.NoError(t, err)
+	return string(data)
+}
+
+func TestSource(t *testing.T) {
+	tests := map[string]func(t *testing.T) string{
+		"dir": writeTestDir,
+		"zip": writeTestZip,
+		"tar": writeTestTar,
+	}
+
+	for name, write := range tests {
+		write := write
+		t.Run(name, func(t *testing.T) {
+			r := require.New(t)
+
+			src, err := OpenSource(write(t))
+			r.NoError(err)
+			defer src.Close()
+
+			sessions, err := src.Sessions()
+			r.NoError(err)
+			r.Equal([]string{"2021.08.10 20.02.16.123", "2021.08.11 19.00.00.5"}, sessions)
+
+			r.Equal("first combat\n", readLog(t, src, sessions[0], "combat.log"))
+			r.Equal("first game\n", readLog(t, src, sessions[0], "game.log"))
+			r.Equal("second game\n", readLog(t, src, sessions[1], "game.log"))
+
+			_, err = src.Open(sessions[1], "chat.log")
+			r.True(errors.Is(err, os.ErrNotExist), err)
+		})
+	}
+
+	_, err := OpenSource(filepath.Join(t.TempDir(), "nope"))
+	require.Error(t, err)
+}
+
+func TestSourceTarCleanup(t *testing.T) {
+	src, err := OpenSource(writeTestTar(t))
+	require.NoError(t, err)
+
+	root := src.(*dirSource).root
+	require.NoError(t, src.Close())
+	_, err = os.Stat(root)
+	require.True(t, errors.Is(err, os.ErrNotExist))
+}
@@ -0,0 +1,242 @@
+// Package signing подписывает отчёт охотника, чтобы организаторы могли отличить его от поправленного руками.
+//
+// Подписывается не каждый файл, а манифест: список файлов отчёта с их sha256.
+// Ключи ed25519 лежат в PEM файлах, публичный ключ охотник заранее отдаёт организаторам.
+package signing
+
+import (
+	"bytes"
+	"crypto/ed25519"
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strconv"
+	"time"
+
+	"github.com/Feresey/haward/evidence"
+)
+
+// PublicKeySuffix это расширение файла с публичным ключом рядом с приватным
+const PublicKeySuffix = ".pub"
+
+// GenerateKey создаёт пару ключей: приватный в path, публичный в path+PublicKeySuffix.
+// Существующий ключ не перезаписывается.
+func GenerateKey(path string) (ed25519.PublicKey, error) {
+	public, private, err := ed25519.GenerateKey(rand.Reader)
+	if err != nil {
+		return nil, err
+	}
+
+	privateDER, err := x509.MarshalPKCS8PrivateKey(private)
+	if err != nil {
+		return nil, err
+	}
+	publicDER, err := x509.MarshalPKIXPublicKey(public)
+	if err != nil {
+		return nil, err
+	}
+
+	if err := writeNew(path, &pem.Block{Type: "PRIVATE KEY", Bytes: privateDER}, 0o600); err != nil {
+		return nil, err
+	}
+	if err := writeNew(path+PublicKeySuffix, &pem.Block{Type: "PUBLIC KEY", Bytes: publicDER}, 0o644); err != nil {
+		return nil, err
+	}
+	return public, nil
+}
+
+func writeNew(path string, block *pem.Block, perm os.FileMode) error {
+	file, err := os.OpenFile(path, os.O_CREATE|os.O_EXCL|os.O_WRONLY, perm)
+	if err != nil {
+		return fmt.Errorf("create key file: %w", err)
+	}
+	if err := pem.Encode(file, block); err != nil {
+		file.Close()
+		return fmt.Errorf("write %q: %w", path, err)
+	}
+	return file.Close()
+}
+
+// LoadPrivateKey читает приватный ключ, который создал GenerateKey.
+func LoadPrivateKey(path string) (ed25519.PrivateKey, error) {
+	der, err := readPEM(path, "PRIVATE KEY")
+	if err != nil {
+		return nil, err
+	}
+	key, err := x509.ParsePKCS8PrivateKey(der)
+	if err != nil {
+		return nil, fmt.Errorf("parse private key: %q: %w", path, err)
+	}
+	private, ok := key.(ed25519.PrivateKey)
+	if !ok {
+		return nil, fmt.Errorf("%q: not an ed25519 key", path)
+	}
+	return private, nil
+}
+
+// LoadPublicKey читает публичный ключ охотника.
+func LoadPublicKey(path string) (ed25519.PublicKey, error) {
+	der, err := readPEM(path, "PUBLIC KEY")
+	if err != nil {
+		return nil, err
+	}
+	key, err := x509.ParsePKIXPublicKey(der)
+	if err != nil {
+		return nil, fmt.Errorf("parse public key: %q: %w", path, err)
+	}
+	public, ok := key.(ed25519.PublicKey)
+	if !ok {
+		return nil, fmt.Errorf("%q: not an ed25519 key", path)
+	}
+	return public, nil
+}
+
+func readPEM(path, blockType string) ([]byte, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("read key: %w", err)
+	}
+	block, _ := pem.Decode(data)
+	if block == nil || block.Type != blockType {
+		return nil, fmt.Errorf("%q: want a PEM %s", path, blockType)
+	}
+	return block.Bytes, nil
+}
+
+// Fingerprint это короткое имя ключа, чтобы сверить его глазами
+func Fingerprint(key ed25519.PublicKey) string {
+	sum := sha256.Sum256(key)
+	return hex.EncodeToString(sum[:8])
+}
+
+// Manifest это подписанный список файлов отчёта.
+type Manifest struct {
+	Hunter string
+	// PublicKey ключ, которым подписан манифест, в base64
+	PublicKey string
+	SignedAt  time.Time
+	// Files пути к файлам относительно папки манифеста, через /
+	Files []evidence.File
+	// Signature подпись payload() в base64
+	Signature string
+}
+
+// Sign хэширует файлы и подписывает их список. names это пути относительно root.
+func Sign(key ed25519.PrivateKey, hunter, root string, names []string) (*Manifest, error) {
+	m := &Manifest{
+		Hunter:    hunter,
+		PublicKey: base64.StdEncoding.EncodeToString(key.Public().(ed25519.PublicKey)),
+		SignedAt:  time.Now().UTC().Truncate(time.Second),
+	}
+	for _, name := range names {
+		file, err := hashFile(root, name)
+		if err != nil {
+			return nil, err
+		}
+		m.Files = append(m.Files, file)
+	}
+	m.Signature = base64.StdEncoding.EncodeToString(ed25519.Sign(key, m.payload()))
+	return m, nil
+}
+
+func hashFile(root, name string) (evidence.File, error) {
+	file, err := os.Open(filepath.Join(root, filepath.FromSlash(name)))
+	if err != nil {
+		return evidence.File{}, err
+	}
+	defer file.Close()
+	return evidence.HashFile(name, file)
+}
+
+// payload это то, что подписывается. Не JSON, чтобы не зависеть от того, как его закодировали.
+func (m *Manifest) payload() []byte {
+	var buf bytes.Buffer
+	buf.WriteString("haward manifest v1\n")
+	buf.WriteString(strconv.Quote(m.Hunter) + "\n")
+	buf.WriteString(m.PublicKey + "\n")
+	buf.WriteString(m.SignedAt.UTC().Format(time.RFC3339) + "\n")
+	for _, file := range m.Files {
+		fmt.Fprintf(&buf, "%s %d %s\n", file.SHA256, file.Size, strconv.Quote(file.Name))
+	}
+	return buf.Bytes()
+}
+
+// Key возвращает ключ, которым подписан манифест.
+func (m *Manifest) Key() (ed25519.PublicKey, error) {
+	key, err := base64.StdEncoding.DecodeString(m.PublicKey)
+	if err != nil || len(key) != ed25519.PublicKeySize {
+		return nil, errors.New("bad public key in the manifest")
+	}
+	return key, nil
+}
+
+// ErrBadSignature значит что манифест поправили после подписи или подписали чужим ключом.
+var ErrBadSignature = errors.New("bad signature")
+
+// CheckSignature проверяет подпись. trusted это ключ охотника, который он отдал заранее,
+// если его нет, то проверяется только что манифест не меняли после подписи.
+func (m *Manifest) CheckSignature(trusted ed25519.PublicKey) error {
+	key, err := m.Key()
+	if err != nil {
+		return err
+	}
+	if trusted != nil && !key.Equal(trusted) {
+		return fmt.Errorf("%w: signed by %s, want %s", ErrBadSignature, Fingerprint(key), Fingerprint(trusted))
+	}
+	sig, err := base64.StdEncoding.DecodeString(m.Signature)
+	if err != nil {
+		return fmt.Errorf("%w: %v", ErrBadSignature, err)
+	}
+	if !ed25519.Verify(key, m.payload(), sig) {
+		return ErrBadSignature
+	}
+	return nil
+}
+
+// CheckFiles сверяет файлы из манифеста с тем, что лежит в root.
+// Возвращает ошибку на каждый пропавший или изменённый файл.
+func (m *Manifest) CheckFiles(root string) []error {
+	var errs []error
+	for _, want := range m.Files {
+		got, err := hashFile(root, want.Name)
+		if err != nil {
+			errs = append(errs, err)
+			continue
+		}
+		if got != want {
+			errs = append(errs, fmt.Errorf("%w: %s: want %s (%d bytes), got %s (%d bytes)",
+				evidence.ErrMismatch, want.Name, want.SHA256, want.Size, got.SHA256, got.Size))
+		}
+	}
+	return errs
+}
+
+// Save записывает манифест.
+func (m *Manifest) Save(path string) error {
+	data, err := json.MarshalIndent(m, "", "\t")
+	if err != nil {
+		return err
+	}
+	return os.WriteFile(path, append(data, '\n'), 0o644)
+}
+
+// LoadManifest читает манифест из файла.
+func LoadManifest(path string) (*Manifest, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("read manifest: %w", err)
+	}
+	var m Manifest
+	if err := json.Unmarshal(data, &m); err != nil {
+		return nil, fmt.Errorf("decode manifest: %q: %w", path, err)
+	}
+	return &m, nil
+}
@@ -0,0 +1,61 @@
+package signing
+
+import (
+	"errors"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/Feresey/haward/evidence"
+	"github.com/stretchr/testify/require"
+)
+
+func TestSign(t *testing.T) {
+	r := require.New(t)
+	dir := t.TempDir()
+	keyPath := filepath.Join(dir, "haward.key")
+
+	public, err := GenerateKey(keyPath)
+	r.NoError(err)
+	// ключ не перезаписывается
+	_, err = GenerateKey(keyPath)
+	r.Error(err)
+
+	private, err := LoadPrivateKey(keyPath)
+	r.NoError(err)
+	loaded, err := LoadPublicKey(keyPath + PublicKeySuffix)
+	r.NoError(err)
+	r.Equal(public, loaded)
+	_, err = LoadPublicKey(keyPath)
+	r.Error(err)
+
+	r.NoError(os.WriteFile(filepath.Join(dir, "out.csv"), []byte("a,b\n"), 0o644))
+	r.NoError(os.MkdirAll(filepath.Join(dir, "evidence", "s"), 0o755))
+	r.NoError(os.WriteFile(filepath.Join(dir, "evidence", "s", "1.json"), []byte("{}\n"), 0o644))
+
+	m, err := Sign(private, "ZiroTwo", dir, []string{"out.csv", "evidence/s/1.json"})
+	r.NoError(err)
+	path := filepath.Join(dir, "out.csv.sig")
+	r.NoError(m.Save(path))
+
+	m, err = LoadManifest(path)
+	r.NoError(err)
+	r.NoError(m.CheckSignature(public))
+	r.NoError(m.CheckSignature(nil))
+	r.Empty(m.CheckFiles(dir))
+
+	// чужой ключ
+	other, err := GenerateKey(filepath.Join(dir, "other.key"))
+	r.NoError(err)
+	r.True(errors.Is(m.CheckSignature(other), ErrBadSignature))
+
+	// поправили отчёт
+	r.NoError(os.WriteFile(filepath.Join(dir, "out.csv"), []byte("a,c\n"), 0o644))
+	errs := m.CheckFiles(dir)
+	r.Len(errs, 1)
+	r.True(errors.Is(errs[0], evidence.ErrMismatch))
+
+	// поправили хэш в манифесте, чтобы сошёлся
+	m.Files[0].SHA256 = "00"
+	r.True(errors.Is(m.CheckSignature(public), ErrBadSignature))
+}